@@ -0,0 +1,468 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errTimeout = errors.New("timeout")
+var errUnblocked = errors.New("client unblocked")
+
+// waiter is a client blocked on a queue. The value handed to it is delivered
+// on a buffered channel so that pushers never block on a slow waiter.
+type waiter struct {
+	ch      chan []string
+	unblock chan error // Receives the reply for a waiter woken by BLOCKED UNBLOCK, or a failed take
+
+	// take removes the values handed to the waiter from the queue, returning
+	// errQueueEmpty when there are none. The caller must hold the store write lock.
+	take func(kv *KeyValue, now time.Time) ([]string, error)
+	// giveBack returns values taken for a client that had already gone to the
+	// queue, as though they had never been taken. It is nil when the values are
+	// not lost, as with BLMOVE, which has already moved them.
+	giveBack func(kv *KeyValue, values []string)
+
+	id        uint64
+	key       string
+	addr      string // Remote address of the blocked client
+	startedAt time.Time
+	deadline  time.Time
+}
+
+// BlockedClient describes a client waiting in BQPOP, BLMOVE or BQDRAIN, as reported by BLOCKED LIST.
+type BlockedClient struct {
+	ID          uint64    `json:"id"`
+	Key         string    `json:"key"`
+	Addr        string    `json:"addr"`
+	StartedAt   time.Time `json:"started_at"`
+	RemainingMs int64     `json:"remaining_ms"`
+}
+
+// serveWaiters hands queued values to the clients blocked on key, longest-waiting
+// first, until either runs out. A queue they drain is deleted, as QPOP does, and
+// once a closed queue is drained the clients still waiting get errQueueClosed.
+// Serving a client can push onto another queue, as BLMOVE does; such queues are
+// served after the current one rather than from inside it, so clients moving
+// values between queues, or around one, cannot recurse without end.
+// The caller must hold the store write lock.
+func (store *KeyValueStore) serveWaiters(key string, kv *KeyValue) {
+	if store.serving {
+		store.pendingServe = append(store.pendingServe, key)
+		return
+	}
+	store.serving = true
+	defer func() {
+		store.serving = false
+		store.pendingServe = nil
+	}()
+
+	store.serveQueue(key, kv)
+	for len(store.pendingServe) > 0 {
+		key := store.pendingServe[0]
+		store.pendingServe = store.pendingServe[1:]
+		if kv, ok := store.lookup(key); ok && kv.Kind == kindList {
+			store.serveQueue(key, kv)
+		}
+	}
+}
+
+// serveQueue is one pass of serveWaiters over the queue at key. Each client is
+// removed from the waiters before its take runs, and put back at the head if
+// there was nothing to take. The caller must hold the store write lock.
+func (store *KeyValueStore) serveQueue(key string, kv *KeyValue) {
+	now := clock.Now()
+	served := false
+	for len(store.waiters[key]) > 0 {
+		next := store.waiters[key][0]
+		store.removeWaiter(key, next)
+
+		values, err := next.take(kv, now)
+		if err == errQueueEmpty {
+			store.waiters[key] = append([]*waiter{next}, store.waiters[key]...)
+			break
+		}
+		if err != nil {
+			next.unblock <- err
+			continue
+		}
+		next.ch <- values
+		store.recordQueue(key, 0, len(values))
+		served = true
+	}
+	if served {
+		store.dropIfDrained(key, kv)
+	}
+	if kv.exhausted() {
+		for _, w := range append([]*waiter(nil), store.waiters[key]...) {
+			store.removeWaiter(key, w)
+			w.unblock <- errQueueClosed
+		}
+	}
+}
+
+// popTaker returns the take and giveBack functions of a client blocked in
+// BQPOP. A value taken from a priority queue is given back with its priority
+// and place, so it is still popped next.
+func popTaker() (take func(kv *KeyValue, now time.Time) ([]string, error), giveBack func(kv *KeyValue, values []string)) {
+	var taken *priorityItem
+	take = func(kv *KeyValue, now time.Time) ([]string, error) {
+		kv.promoteDelayed(now)
+		if kv.Priority != nil {
+			if kv.Priority.Len() == 0 {
+				return nil, errQueueEmpty
+			}
+			item := heap.Pop(kv.Priority).(priorityItem)
+			taken = &item
+			return []string{item.value}, nil
+		}
+		if value, ok := kv.pop(now); ok {
+			return []string{value}, nil
+		}
+		return nil, errQueueEmpty
+	}
+	giveBack = func(kv *KeyValue, values []string) {
+		if taken != nil {
+			if kv.Priority == nil && len(kv.Value) == 0 {
+				kv.Priority = &priorityQueue{}
+			}
+			if kv.Priority != nil {
+				heap.Push(kv.Priority, *taken)
+				return
+			}
+		}
+		kv.Value = append(kv.Value, values...)
+	}
+	return take, giveBack
+}
+
+// blockTimeout converts a blocking command's timeout in seconds to a duration,
+// clamping one too long to represent, or NaN, to the longest duration.
+func blockTimeout(seconds float64) time.Duration {
+	if !(seconds < math.MaxInt64/float64(time.Second)) {
+		return math.MaxInt64
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// removeWaiter drops w from the waiters on key, reporting whether it was still waiting.
+// The caller must hold the store write lock.
+func (store *KeyValueStore) removeWaiter(key string, w *waiter) bool {
+	queue := store.waiters[key]
+	for i, candidate := range queue {
+		if candidate != w {
+			continue
+		}
+
+		queue = append(queue[:i:i], queue[i+1:]...)
+		if len(queue) == 0 {
+			delete(store.waiters, key)
+		} else {
+			store.waiters[key] = queue
+		}
+		return true
+	}
+	return false
+}
+
+// BQPop pops a value from the queue at key, blocking for up to timeout until one is pushed.
+// Blocked clients are served strictly in the order they started waiting.
+func (store *KeyValueStore) BQPop(key string, timeout time.Duration) (string, error) {
+	return store.blockingPop(context.Background(), key, timeout, "")
+}
+
+// blockingPop is BQPop for the client at addr, which BLOCKED UNBLOCK uses to
+// find it, giving up once ctx is done.
+func (store *KeyValueStore) blockingPop(ctx context.Context, key string, timeout time.Duration, addr string) (string, error) {
+	take, giveBack := popTaker()
+	values, err := store.blockingTake(ctx, key, timeout, addr, take, giveBack)
+	if err != nil {
+		return "", err
+	}
+	return values[0], nil
+}
+
+// BLMove moves an element from one end of the queue at src to one end of the
+// queue at dst, as LMove does, blocking for up to timeout until src has one.
+// The element is moved under the same lock acquisition as the push that
+// woke the client, so it is never lost between the two queues.
+func (store *KeyValueStore) BLMove(ctx context.Context, src, dst string, fromLeft, toLeft bool, timeout time.Duration, addr string) (string, error) {
+	values, err := store.blockingTake(ctx, src, timeout, addr, func(kv *KeyValue, now time.Time) ([]string, error) {
+		value, err := store.lmove(kv, dst, fromLeft, toLeft, now)
+		return []string{value}, err
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+	return values[0], nil
+}
+
+// BQDrain removes and returns up to max of the oldest values in the queue at
+// key, oldest first. If the queue is empty it blocks for up to timeout until a
+// push arrives, then returns everything that push made available, up to max.
+func (store *KeyValueStore) BQDrain(ctx context.Context, key string, max int, timeout time.Duration, addr string) ([]string, error) {
+	return store.blockingTake(ctx, key, timeout, addr, func(kv *KeyValue, now time.Time) ([]string, error) {
+		return kv.drain(max, now)
+	}, func(kv *KeyValue, values []string) {
+		kv.Value = append(values, kv.Value...)
+	})
+}
+
+// blockingTake waits for up to timeout until take can remove values from the
+// queue at key. Blocked clients are served strictly in the order they started waiting.
+// Without a timeout it fails at once, with errKeyNotFound for a missing key and
+// errQueueEmpty for a queue with nothing to take. Once ctx is done, as when the
+// client disconnects, it stops waiting with errCommandTimeout, and values
+// handed to it in the meantime are given back to the queue.
+func (store *KeyValueStore) blockingTake(ctx context.Context, key string, timeout time.Duration, addr string, take func(kv *KeyValue, now time.Time) ([]string, error), giveBack func(kv *KeyValue, values []string)) ([]string, error) {
+	store.mutex.Lock()
+
+	// Earlier waiters get first pick of any value (such as a delayed value that
+	// has become visible); only take one directly when nobody is still waiting.
+	missing := errKeyNotFound
+	if kv, ok := store.lookup(key); ok {
+		if kv.Kind != kindList {
+			store.mutex.Unlock()
+			return nil, errWrongType
+		}
+		missing = errQueueEmpty
+		store.serveWaiters(key, kv)
+		if len(store.waiters[key]) == 0 {
+			values, err := take(kv, clock.Now())
+			if err == nil {
+				store.recordQueue(key, 0, len(values))
+				store.dropIfDrained(key, kv)
+			}
+			if err == errQueueEmpty && kv.exhausted() {
+				err = errQueueClosed
+			}
+			if err != errQueueEmpty {
+				store.mutex.Unlock()
+				return values, err
+			}
+		}
+	}
+
+	if timeout <= 0 {
+		store.mutex.Unlock()
+		return nil, missing
+	}
+
+	now := time.Now()
+	store.lastWaiterID++
+	w := &waiter{
+		ch:        make(chan []string, 1),
+		unblock:   make(chan error, 1),
+		take:      take,
+		giveBack:  giveBack,
+		id:        store.lastWaiterID,
+		key:       key,
+		addr:      addr,
+		startedAt: now,
+		deadline:  now.Add(timeout),
+	}
+	if store.waiters == nil {
+		store.waiters = make(map[string][]*waiter)
+	}
+	store.waiters[key] = append(store.waiters[key], w)
+	store.mutex.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	reply := errTimeout
+	select {
+	case values := <-w.ch:
+		return values, nil
+	case err := <-w.unblock:
+		return nil, err
+	case <-timer.C:
+	case <-ctx.Done():
+		reply = errCommandTimeout
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	// A push or BLOCKED UNBLOCK may have served this waiter between the timer
+	// firing and taking the lock
+	if !store.removeWaiter(key, w) {
+		select {
+		case values := <-w.ch:
+			if reply == errCommandTimeout {
+				store.returnToQueue(key, w, values)
+				return nil, reply
+			}
+			return values, nil
+		case err := <-w.unblock:
+			return nil, err
+		}
+	}
+	return nil, reply
+}
+
+// returnToQueue returns values taken for w, which stopped waiting before it could
+// reply with them, to the queue at key and serves them to the next client
+// waiting. The queue is recreated if taking them drained it; values are only
+// lost if key has since been replaced by another type. The caller must hold
+// the store write lock.
+func (store *KeyValueStore) returnToQueue(key string, w *waiter, values []string) {
+	if w.giveBack == nil {
+		return
+	}
+	kv, ok := store.lookup(key)
+	if !ok {
+		kv = &KeyValue{Kind: kindList}
+		store.insert(key, kv)
+	}
+	if kv.Kind != kindList {
+		return
+	}
+	w.giveBack(kv, values)
+	store.markWritten(key)
+	store.serveWaiters(key, kv)
+}
+
+// BlockedClients lists the clients currently blocked on a queue, longest-waiting first.
+func (store *KeyValueStore) BlockedClients() []BlockedClient {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	now := time.Now()
+	clients := []BlockedClient{}
+	for _, queue := range store.waiters {
+		for _, w := range queue {
+			clients = append(clients, BlockedClient{
+				ID:          w.id,
+				Key:         w.key,
+				Addr:        w.addr,
+				StartedAt:   w.startedAt,
+				RemainingMs: w.deadline.Sub(now).Milliseconds(),
+			})
+		}
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+	return clients
+}
+
+// Unblock wakes every client blocked from addr with err and returns how many were woken.
+func (store *KeyValueStore) Unblock(addr string, err error) int {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	var woken []*waiter
+	for _, queue := range store.waiters {
+		for _, w := range queue {
+			if w.addr == addr {
+				woken = append(woken, w)
+			}
+		}
+	}
+
+	for _, w := range woken {
+		store.removeWaiter(w.key, w)
+		w.unblock <- err
+	}
+	return len(woken)
+}
+
+// handleBLOCKED handles BLOCKED LIST and BLOCKED UNBLOCK addr [ERROR|TIMEOUT].
+// An unblocked client gets a timeout reply by default, or an error with ERROR.
+func handleBLOCKED(w http.ResponseWriter, parts []string) {
+	if len(parts) < 2 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	switch strings.ToUpper(parts[1]) {
+	case "LIST":
+		if len(parts) != 2 {
+			sendErrorResponse(w, "invalid command format")
+			return
+		}
+
+		sendObjectResponse(w, store.BlockedClients())
+	case "UNBLOCK":
+		if len(parts) != 3 && len(parts) != 4 {
+			sendErrorResponse(w, "invalid command format")
+			return
+		}
+
+		reply := errTimeout
+		if len(parts) == 4 {
+			switch strings.ToUpper(parts[3]) {
+			case "TIMEOUT":
+			case "ERROR":
+				reply = errUnblocked
+			default:
+				sendErrorResponse(w, "invalid command format")
+				return
+			}
+		}
+
+		sendIntegerResponse(w, int64(store.Unblock(parts[2], reply)))
+	default:
+		sendErrorResponse(w, "invalid command")
+	}
+}
+
+// handleBLMOVE handles BLMOVE src dst LEFT|RIGHT LEFT|RIGHT timeout, returning
+// the moved element. The timeout is in seconds; 0 returns at once like LMOVE.
+func handleBLMOVE(ctx context.Context, w http.ResponseWriter, parts []string, addr string) {
+	if len(parts) != 6 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	fromLeft, toLeft, ok := parseEnds(w, parts)
+	if !ok {
+		return
+	}
+	seconds, err := strconv.ParseFloat(parts[5], 64)
+	if err != nil || seconds < 0 {
+		sendErrorResponse(w, "invalid timeout")
+		return
+	}
+
+	value, err := store.BLMove(ctx, parts[1], parts[2], fromLeft, toLeft, blockTimeout(seconds), addr)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendValueResponse(w, value)
+}
+
+// handleBQDRAIN handles BQDRAIN key max timeout, returning up to max of the
+// oldest values, waiting up to timeout seconds for the first one to arrive.
+func handleBQDRAIN(ctx context.Context, w http.ResponseWriter, parts []string, addr string) {
+	if len(parts) != 4 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	max, err := strconv.Atoi(parts[2])
+	if err != nil || max <= 0 {
+		sendErrorResponse(w, "invalid count")
+		return
+	}
+	seconds, err := strconv.ParseFloat(parts[3], 64)
+	if err != nil || seconds < 0 {
+		sendErrorResponse(w, "invalid timeout")
+		return
+	}
+
+	values, err := store.BQDrain(ctx, parts[1], max, blockTimeout(seconds), addr)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendListResponse(w, values)
+}
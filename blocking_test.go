@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBQPOPServesWaitersInArrivalOrder(t *testing.T) {
+	results := make([]chan string, 3)
+
+	// Three waiters arrive one after another
+	for i := range results {
+		results[i] = make(chan string, 1)
+		go func(result chan string) {
+			value, err := store.BQPop("fair-queue", 2*time.Second)
+			if err != nil {
+				value = err.Error()
+			}
+			result <- value
+		}(results[i])
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// A single push must go to the earliest waiter only
+	sendCommand(t, "QPUSH fair-queue first")
+
+	select {
+	case value := <-results[0]:
+		if value != "first" {
+			t.Errorf("Expected the earliest waiter to get %q, but got %q", "first", value)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the earliest waiter to be woken by the push")
+	}
+
+	for i := 1; i < 3; i++ {
+		select {
+		case value := <-results[i]:
+			t.Fatalf("Expected waiter %d to keep waiting, but it got %q", i, value)
+		default:
+		}
+	}
+
+	// Later pushes continue in arrival order
+	sendCommand(t, "QPUSH fair-queue second")
+	sendCommand(t, "QPUSH fair-queue third")
+
+	if value := <-results[1]; value != "second" {
+		t.Errorf("Expected the second waiter to get %q, but got %q", "second", value)
+	}
+	if value := <-results[2]; value != "third" {
+		t.Errorf("Expected the third waiter to get %q, but got %q", "third", value)
+	}
+}
+
+func TestBQPOPTimeout(t *testing.T) {
+	start := time.Now()
+	rr := sendCommand(t, "BQPOP empty-queue 0.1")
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, rr.Code)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected BQPOP to block for the timeout, but it returned after %v", elapsed)
+	}
+
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	if len(store.waiters["empty-queue"]) != 0 {
+		t.Errorf("Expected the timed out waiter to be removed")
+	}
+}
+
+func TestBLOCKEDListAndUnblock(t *testing.T) {
+	result := make(chan string, 1)
+	go func() {
+		req, err := http.NewRequest("POST", "/", strings.NewReader(`{"command": "BQPOP blocked-queue 5"}`))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		req.RemoteAddr = "10.0.0.7:51234"
+
+		rr := httptest.NewRecorder()
+		handleRequest(rr, req)
+
+		var response ErrorResponse
+		json.NewDecoder(rr.Body).Decode(&response)
+		result <- response.Error
+	}()
+
+	// Wait for the client to show up in the registry
+	var blocked struct {
+		Value []BlockedClient `json:"value"`
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(blocked.Value) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		decodeResponse(t, sendCommand(t, "BLOCKED LIST"), &blocked)
+	}
+
+	if len(blocked.Value) != 1 {
+		t.Fatalf("Expected 1 blocked client, but got %+v", blocked.Value)
+	}
+	if client := blocked.Value[0]; client.Key != "blocked-queue" || client.Addr != "10.0.0.7:51234" || client.RemainingMs <= 0 {
+		t.Errorf("Unexpected blocked client %+v", client)
+	}
+
+	var unblocked IntegerResponse
+	decodeResponse(t, sendCommand(t, "BLOCKED UNBLOCK 10.0.0.7:51234 ERROR"), &unblocked)
+	if unblocked.Value != 1 {
+		t.Errorf("Expected 1 client to be unblocked, but got %d", unblocked.Value)
+	}
+
+	select {
+	case reply := <-result:
+		if reply != errUnblocked.Error() {
+			t.Errorf("Expected the client to get %q, but got %q", errUnblocked.Error(), reply)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the unblocked client to return")
+	}
+
+	decodeResponse(t, sendCommand(t, "BLOCKED LIST"), &blocked)
+	if len(blocked.Value) != 0 {
+		t.Errorf("Expected no blocked clients, but got %+v", blocked.Value)
+	}
+}
+
+func TestBLMOVEPicksUpDelayedPush(t *testing.T) {
+	result := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		result <- sendCommand(t, "BLMOVE blmove-jobs blmove-processing RIGHT LEFT 5")
+	}()
+
+	// Push once the client is blocked
+	for !isBlockedOn("blmove-jobs") {
+		time.Sleep(time.Millisecond)
+	}
+	store.QPush("blmove-jobs", []string{"job-1"})
+
+	var value ValueResponse
+	decodeResponse(t, <-result, &value)
+	if value.Value != "job-1" {
+		t.Errorf("Expected BLMOVE to return job-1, but got %q", value.Value)
+	}
+
+	if processing := store.LRange("blmove-processing", 0, -1); !reflect.DeepEqual(processing, []string{"job-1"}) {
+		t.Errorf("Expected job-1 in the processing queue, but got %v", processing)
+	}
+	if length := store.QLen("blmove-jobs"); length != 0 {
+		t.Errorf("Expected the source queue to be empty, but it holds %d values", length)
+	}
+}
+
+func TestBLMOVEOntoItsOwnQueue(t *testing.T) {
+	result := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		result <- sendCommand(t, "BLMOVE blmove-rotate blmove-rotate LEFT RIGHT 5")
+	}()
+	for !isBlockedOn("blmove-rotate") {
+		time.Sleep(time.Millisecond)
+	}
+	store.QPush("blmove-rotate", []string{"a"})
+
+	var value ValueResponse
+	decodeResponse(t, <-result, &value)
+	if value.Value != "a" {
+		t.Errorf("Expected BLMOVE to return a, but got %q", value.Value)
+	}
+	if values := store.LRange("blmove-rotate", 0, -1); !reflect.DeepEqual(values, []string{"a"}) {
+		t.Errorf("Expected the rotated queue to hold a, but got %v", values)
+	}
+}
+
+func TestBLMOVEBetweenTwoBlockedQueues(t *testing.T) {
+	forward := make(chan *httptest.ResponseRecorder, 1)
+	backward := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		forward <- sendCommand(t, "BLMOVE blmove-ping blmove-pong RIGHT LEFT 5")
+	}()
+	go func() {
+		backward <- sendCommand(t, "BLMOVE blmove-pong blmove-ping RIGHT LEFT 5")
+	}()
+	for !isBlockedOn("blmove-ping") || !isBlockedOn("blmove-pong") {
+		time.Sleep(time.Millisecond)
+	}
+	store.QPush("blmove-ping", []string{"ball"})
+
+	for _, result := range []chan *httptest.ResponseRecorder{forward, backward} {
+		var value ValueResponse
+		decodeResponse(t, <-result, &value)
+		if value.Value != "ball" {
+			t.Errorf("Expected both clients to move ball, but got %q", value.Value)
+		}
+	}
+	if values := store.LRange("blmove-ping", 0, -1); !reflect.DeepEqual(values, []string{"ball"}) {
+		t.Errorf("Expected the value to end up back in blmove-ping, but got %v", values)
+	}
+	if length := store.QLen("blmove-pong"); length != 0 {
+		t.Errorf("Expected blmove-pong to be empty, but it holds %d values", length)
+	}
+}
+
+func TestLMOVEEnds(t *testing.T) {
+	store.QPush("lmove-src", []string{"a", "b", "c"})
+
+	var value ValueResponse
+	decodeResponse(t, sendCommand(t, "LMOVE lmove-src lmove-dst LEFT RIGHT"), &value)
+	decodeResponse(t, sendCommand(t, "LMOVE lmove-src lmove-dst RIGHT LEFT"), &value)
+	if value.Value != "c" {
+		t.Errorf("Expected RIGHT to take c, but got %q", value.Value)
+	}
+
+	if src := store.LRange("lmove-src", 0, -1); !reflect.DeepEqual(src, []string{"b"}) {
+		t.Errorf("Expected [b] left in the source, but got %v", src)
+	}
+	if dst := store.LRange("lmove-dst", 0, -1); !reflect.DeepEqual(dst, []string{"c", "a"}) {
+		t.Errorf("Expected [c a] in the destination, but got %v", dst)
+	}
+
+	var response ErrorResponse
+	decodeResponse(t, sendCommand(t, "LMOVE lmove-src lmove-dst UP LEFT"), &response)
+	if response.Error != "unexpected token 'UP' at position 4; expected LEFT or RIGHT" {
+		t.Errorf("Expected an error for an invalid end, but got %q", response.Error)
+	}
+}
+
+// isBlockedOn reports whether any client is blocked on key.
+func isBlockedOn(key string) bool {
+	for _, client := range store.BlockedClients() {
+		if client.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func TestBQDRAINReturnsTrickledBatches(t *testing.T) {
+	pushed := []string{"job-1", "job-2", "job-3", "job-4", "job-5", "job-6"}
+
+	// The first batch arrives in one push while the worker is blocked
+	first := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		first <- sendCommand(t, "BQDRAIN bqdrain-jobs 10 5")
+	}()
+	for !isBlockedOn("bqdrain-jobs") {
+		time.Sleep(time.Millisecond)
+	}
+	store.QPush("bqdrain-jobs", pushed[:2])
+
+	var batch ListResponse
+	decodeResponse(t, <-first, &batch)
+	if !reflect.DeepEqual(batch.Value, pushed[:2]) {
+		t.Errorf("Expected the first batch %v, but got %v", pushed[:2], batch.Value)
+	}
+	received := append([]string(nil), batch.Value...)
+
+	// The rest trickle in one at a time while the worker keeps draining
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, value := range pushed[2:] {
+			store.QPush("bqdrain-jobs", []string{value})
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	for len(received) < len(pushed) {
+		var batch ListResponse
+		decodeResponse(t, sendCommand(t, "BQDRAIN bqdrain-jobs 3 5"), &batch)
+		if len(batch.Value) == 0 || len(batch.Value) > 3 {
+			t.Fatalf("Expected a batch of 1 to 3 values, but got %v", batch.Value)
+		}
+		received = append(received, batch.Value...)
+	}
+	<-done
+
+	if !reflect.DeepEqual(received, pushed) {
+		t.Errorf("Expected the batches to deliver %v in order, but got %v", pushed, received)
+	}
+}
+
+func TestBQPOPWithoutTimeoutDistinguishesMissingAndEmptyQueues(t *testing.T) {
+	var response ErrorResponse
+	decodeResponse(t, sendCommand(t, "BQPOP bqpop-missing 0"), &response)
+	if response.Error != errKeyNotFound.Error() {
+		t.Errorf("Expected %q for a missing key, but got %q", errKeyNotFound, response.Error)
+	}
+
+	sendCommand(t, "ENSURE bqpop-empty list")
+	decodeResponse(t, sendCommand(t, "BQPOP bqpop-empty 0"), &response)
+	if response.Error != errQueueEmpty.Error() {
+		t.Errorf("Expected %q for an empty queue, but got %q", errQueueEmpty, response.Error)
+	}
+
+	sendCommand(t, "QPUSH bqpop-last value")
+	var value ValueResponse
+	decodeResponse(t, sendCommand(t, "BQPOP bqpop-last 0"), &value)
+	if value.Value != "value" {
+		t.Errorf("Expected %q, but got %q", "value", value.Value)
+	}
+	decodeResponse(t, sendCommand(t, "BQPOP bqpop-last 0"), &response)
+	if response.Error != errKeyNotFound.Error() {
+		t.Errorf("Expected popping the last value to delete the key, but got %q", response.Error)
+	}
+}
+
+func TestBlockedClientStopsWaitingWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		_, err := store.blockingPop(ctx, "cancelled-jobs", 10*time.Second, "")
+		result <- err
+	}()
+	for !isBlockedOn("cancelled-jobs") {
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case err := <-result:
+		if err != errCommandTimeout {
+			t.Errorf("Expected %v, but got %v", errCommandTimeout, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the cancelled client to stop waiting")
+	}
+	if isBlockedOn("cancelled-jobs") {
+		t.Error("Expected the cancelled client to be removed from the waiters")
+	}
+}
+
+func TestValueTakenAfterCancellationIsGivenBack(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		_, err := store.blockingPop(ctx, "late-jobs", 10*time.Second, "")
+		result <- err
+	}()
+	for !isBlockedOn("late-jobs") {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Cancel while the lock is held, so the value is handed over before the
+	// cancelled client can remove itself
+	store.mutex.Lock()
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	kv := &KeyValue{Kind: kindList, Value: []string{"job"}}
+	store.insert("late-jobs", kv)
+	store.serveWaiters("late-jobs", kv)
+	store.mutex.Unlock()
+
+	if err := <-result; err != errCommandTimeout {
+		t.Errorf("Expected %v, but got %v", errCommandTimeout, err)
+	}
+	if value, err := store.QPop("late-jobs"); err != nil || value != "job" {
+		t.Errorf("Expected the value to be back on the queue, but got %q, %v", value, err)
+	}
+}
+
+func TestBlockTimeoutClampsHugeValues(t *testing.T) {
+	for _, seconds := range []float64{1e300, math.Inf(1), math.NaN()} {
+		if timeout := blockTimeout(seconds); timeout != math.MaxInt64 {
+			t.Errorf("blockTimeout(%v): expected the longest duration, but got %v", seconds, timeout)
+		}
+	}
+	if timeout := blockTimeout(1.5); timeout != 1500*time.Millisecond {
+		t.Errorf("Expected 1.5s, but got %v", timeout)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// bulkMaxLine is the longest command line POST /bulk accepts.
+const bulkMaxLine = 1 << 20
+
+// bulkMaxErrors bounds the failures listed in a bulk load summary; later ones are only counted.
+const bulkMaxErrors = 100
+
+// BulkError describes a command that failed during a bulk load.
+type BulkError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// BulkResult summarises a bulk load.
+type BulkResult struct {
+	Applied int         `json:"applied"`
+	Failed  int         `json:"failed"`
+	Errors  []BulkError `json:"errors"` // The first bulkMaxErrors failures
+}
+
+// commandRecorder collects the reply to a command run on the server's behalf.
+type commandRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *commandRecorder) Header() http.Header         { return w.header }
+func (w *commandRecorder) Write(b []byte) (int, error) { return w.body.Write(b) }
+func (w *commandRecorder) WriteHeader(status int)      { w.status = status }
+
+// runCommand runs command through dispatchRequest in namespace, as a client
+// request would, and returns the reply's status and body.
+func runCommand(command, namespace string) (int, []byte) {
+	return runCommandContext(context.Background(), command, namespace, "internal")
+}
+
+// runCommandContext is runCommand on behalf of the client at addr, which
+// blocking commands register under, giving up on waits once ctx is done.
+func runCommandContext(ctx context.Context, command, namespace, addr string) (int, []byte) {
+	body, _ := json.Marshal(Command{Command: command})
+	r, _ := http.NewRequestWithContext(ctx, http.MethodPost, "/", bytes.NewReader(body))
+	if namespace != "" {
+		r.Header.Set(namespaceHeader, namespace)
+	}
+	r.RemoteAddr = addr
+
+	w := &commandRecorder{header: make(http.Header), status: http.StatusOK}
+	dispatchRequest(w, r)
+	return w.status, w.body.Bytes()
+}
+
+// handleBulk runs the commands in the request body, one per line, and replies
+// with how many succeeded and failed. The body is read a line at a time, so
+// it can be far larger than memory, and each command takes the store lock on
+// its own, so other clients are served during a long load. Blank lines and
+// lines starting with # are skipped. A failed command does not stop the load,
+// but a client that disconnects does: its commands run under the request's
+// context, so a blocking command gives up too.
+func handleBulk(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		sendStatusErrorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if _, err := keyNamespace(r); err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+	namespace := r.Header.Get(namespaceHeader)
+
+	result := BulkResult{Errors: []BulkError{}}
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 64*1024), bulkMaxLine)
+	line := 0
+	for scanner.Scan() {
+		if r.Context().Err() != nil {
+			return
+		}
+		line++
+		command := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(command) == "" || strings.HasPrefix(command, "#") {
+			continue
+		}
+
+		status, body := runCommandContext(r.Context(), command, namespace, r.RemoteAddr)
+		if status == http.StatusOK {
+			result.Applied++
+			continue
+		}
+		result.Failed++
+		if len(result.Errors) < bulkMaxErrors {
+			var response ErrorResponse
+			json.Unmarshal(body, &response)
+			result.Errors = append(result.Errors, BulkError{Line: line, Error: response.Error})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		// Report what was applied before the body broke off, such as at an overlong line
+		result.Failed++
+		if len(result.Errors) < bulkMaxErrors {
+			result.Errors = append(result.Errors, BulkError{Line: line + 1, Error: err.Error()})
+		}
+	}
+
+	sendObjectResponse(w, result)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBulkLoadAppliesCommandsAndReportsFailures(t *testing.T) {
+	const keys = 3000
+
+	var body strings.Builder
+	body.WriteString("# seed data\n")
+	for i := 0; i < keys; i++ {
+		fmt.Fprintf(&body, "SET bulk:%d value-%d\n", i, i)
+	}
+	body.WriteString("\nSET only-a-key\r\nINCR bulk:7\n")
+
+	req, err := http.NewRequest(http.MethodPost, "/bulk", strings.NewReader(body.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	handleBulk(rr, req)
+
+	var result struct {
+		Value BulkResult `json:"value"`
+	}
+	decodeResponse(t, rr, &result)
+	if result.Value.Applied != keys || result.Value.Failed != 2 {
+		t.Errorf("Expected %d applied and 2 failed, but got %+v", keys, result.Value)
+	}
+	if len(result.Value.Errors) != 2 || result.Value.Errors[0].Line != keys+3 {
+		t.Errorf("Expected the failures to point at their lines, but got %+v", result.Value.Errors)
+	}
+
+	loaded := 0
+	for key := range store.Data {
+		if strings.HasPrefix(key, "bulk:") {
+			loaded++
+		}
+	}
+	if loaded != keys {
+		t.Errorf("Expected %d keys loaded, but found %d", keys, loaded)
+	}
+	for _, i := range []int{0, 1234, keys - 1} {
+		if value, err := store.Get(fmt.Sprintf("bulk:%d", i)); err != nil || value != fmt.Sprintf("value-%d", i) {
+			t.Errorf("Expected bulk:%d to be value-%d, but got %q, %v", i, i, value, err)
+		}
+	}
+}
+
+func TestBulkLoadStopsWhenClientDisconnects(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	body := strings.NewReader("BQPOP bulk-wait 30\nSET bulk-after-disconnect 1\n")
+	req := httptest.NewRequest("POST", "/bulk", body).WithContext(ctx)
+
+	done := make(chan struct{})
+	go func() {
+		handleBulk(httptest.NewRecorder(), req)
+		close(done)
+	}()
+	for !isBlockedOn("bulk-wait") {
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the load to stop once the client disconnected")
+	}
+	if _, err := store.Get("bulk-after-disconnect"); err != errKeyNotFound {
+		t.Errorf("Expected the rest of the load to be skipped, but got %v", err)
+	}
+}
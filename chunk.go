@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type ChunkResponse struct {
+	Value    string `json:"value"`               // The chunk, when it is valid UTF-8.
+	ValueB64 string `json:"value_b64,omitempty"` // The chunk base64-encoded, set instead of Value for binary chunks.
+	Total    int    `json:"total"`               // Length in bytes of the whole value.
+}
+
+// GetChunk returns up to length bytes of the string stored at key starting at
+// offset, along with the length of the whole value, so that large values can
+// be read a chunk at a time. An offset at or past the end gives an empty chunk.
+func (store *KeyValueStore) GetChunk(key string, offset, length int) (string, int, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	kv, ok := store.lookup(key)
+	if !ok {
+		return "", 0, errKeyNotFound
+	}
+	if kv.Kind != kindString {
+		return "", 0, errWrongType
+	}
+
+	value := strings.Join(kv.Value, " ")
+	if offset >= len(value) {
+		return "", len(value), nil
+	}
+	// Clamp before adding, so a huge length cannot overflow past the end
+	if length > len(value)-offset {
+		length = len(value) - offset
+	}
+	return value[offset : offset+length], len(value), nil
+}
+
+// handleGETCHUNK handles GETCHUNK key offset length, replying with the chunk and the total length.
+// Chunks split at byte offsets, so one that is not valid UTF-8 is sent base64-encoded.
+func handleGETCHUNK(w http.ResponseWriter, parts []string) {
+	if len(parts) != 4 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	offset, err := strconv.Atoi(parts[2])
+	if err != nil || offset < 0 {
+		sendErrorResponse(w, "invalid offset")
+		return
+	}
+	length, err := strconv.Atoi(parts[3])
+	if err != nil || length <= 0 {
+		sendErrorResponse(w, "invalid length")
+		return
+	}
+
+	chunk, total, err := store.GetChunk(parts[1], offset, length)
+	if err != nil {
+		sendReadError(w, err)
+		return
+	}
+
+	response := ChunkResponse{Value: chunk, Total: total}
+	if !utf8.ValidString(chunk) {
+		response = ChunkResponse{ValueB64: base64.StdEncoding.EncodeToString([]byte(chunk)), Total: total}
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
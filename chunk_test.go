@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestGETCHUNKReassemblesLargeValue(t *testing.T) {
+	// Three-byte characters make some 64KB chunk boundaries split a character
+	value := strings.Repeat("abc€", 1<<20/6)
+	store.Set("chunked", value, nil, "")
+
+	const chunkSize = 64 << 10
+	var assembled bytes.Buffer
+	for offset := 0; ; offset += chunkSize {
+		var chunk ChunkResponse
+		decodeResponse(t, sendCommand(t, "GETCHUNK chunked "+strconv.Itoa(offset)+" "+strconv.Itoa(chunkSize)), &chunk)
+		if chunk.Total != len(value) {
+			t.Fatalf("Expected a total length of %d, but got %d", len(value), chunk.Total)
+		}
+
+		if chunk.ValueB64 != "" {
+			b, err := base64.StdEncoding.DecodeString(chunk.ValueB64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assembled.Write(b)
+		} else {
+			assembled.WriteString(chunk.Value)
+		}
+
+		if offset+chunkSize >= chunk.Total {
+			break
+		}
+	}
+
+	if assembled.String() != value {
+		t.Errorf("Expected the reassembled chunks to match the %d byte value, but got %d bytes", len(value), assembled.Len())
+	}
+}
+
+func TestGETCHUNKClampsHugeLength(t *testing.T) {
+	store.Set("chunk-huge", "abcdef", nil, "")
+
+	chunk, total, err := store.GetChunk("chunk-huge", 2, math.MaxInt)
+	if err != nil || chunk != "cdef" || total != 6 {
+		t.Errorf("Expected the rest of the value, but got %q, %d, %v", chunk, total, err)
+	}
+}
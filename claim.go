@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// claim is a value taken from a queue by QCLAIM that has not been acknowledged yet.
+type claim struct {
+	value     string
+	worker    string
+	claimedAt time.Time
+	deadline  time.Time // The value goes back on the queue if it is not acknowledged by then
+}
+
+// claimTable holds the outstanding claims on one queue, by token, and how many
+// claims each worker let lapse. It is kept by key name, so claims survive the
+// queue being drained and deleted.
+type claimTable struct {
+	claims   map[string]*claim
+	failures map[string]int
+}
+
+// ClaimResult is the reply to QCLAIM.
+type ClaimResult struct {
+	Value string `json:"value"`
+	Token string `json:"token"` // Passed to QACK to acknowledge the value
+}
+
+// ClaimedItem is an entry in the reply to QCLAIMED.
+type ClaimedItem struct {
+	Token     string `json:"token"`
+	Value     string `json:"value"`
+	Worker    string `json:"worker"`
+	Age       int64  `json:"age"`        // Seconds since the value was claimed
+	ExpiresIn int64  `json:"expires_in"` // Seconds left before the value is requeued, rounded up
+}
+
+// ClaimReport is the reply to QCLAIMED.
+type ClaimReport struct {
+	Claims   []ClaimedItem  `json:"claims"`   // Oldest claim first
+	Failures map[string]int `json:"failures"` // Claims each worker let lapse, by worker
+}
+
+// newClaimToken returns a random token that other workers cannot guess.
+func newClaimToken() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// QClaim pops the next value from the queue at key on behalf of worker and
+// holds it for visibility. Unless it is acknowledged with QAck before then, it
+// is pushed back to be popped next, and the lapse is counted against worker.
+// As with LMOVE, priority queues are not supported.
+func (store *KeyValueStore) QClaim(key, worker string, visibility time.Duration) (ClaimResult, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := clock.Now()
+	store.requeueClaims(key, now)
+
+	kv, ok := store.lookup(key)
+	if !ok {
+		return ClaimResult{}, errKeyNotFound
+	}
+	if kv.Kind != kindList || kv.Priority != nil {
+		return ClaimResult{}, errWrongType
+	}
+	value, ok := kv.pop(now)
+	if !ok {
+		return ClaimResult{}, errQueueEmpty
+	}
+	store.recordQueue(key, 0, 1)
+	store.dropIfDrained(key, kv)
+
+	table := store.claims[key]
+	if table == nil {
+		if store.claims == nil {
+			store.claims = make(map[string]*claimTable)
+		}
+		table = &claimTable{claims: make(map[string]*claim), failures: make(map[string]int)}
+		store.claims[key] = table
+	}
+	token := newClaimToken()
+	table.claims[token] = &claim{value: value, worker: worker, claimedAt: now, deadline: now.Add(visibility)}
+	return ClaimResult{Value: value, Token: token}, nil
+}
+
+// QAck acknowledges the claim with token on the queue at key, reporting
+// whether it was still held. A lapsed claim has already been requeued.
+func (store *KeyValueStore) QAck(key, token string) bool {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.requeueClaims(key, clock.Now())
+
+	table := store.claims[key]
+	if table == nil {
+		return false
+	}
+	if _, ok := table.claims[token]; !ok {
+		return false
+	}
+	delete(table.claims, token)
+	if len(table.claims) == 0 && len(table.failures) == 0 {
+		delete(store.claims, key)
+	}
+	return true
+}
+
+// QClaimed reports the outstanding claims on the queue at key and the lapsed claims of each worker.
+func (store *KeyValueStore) QClaimed(key string) ClaimReport {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := clock.Now()
+	store.requeueClaims(key, now)
+
+	report := ClaimReport{Claims: []ClaimedItem{}, Failures: map[string]int{}}
+	table := store.claims[key]
+	if table == nil {
+		return report
+	}
+	for token, c := range table.claims {
+		report.Claims = append(report.Claims, ClaimedItem{
+			Token:     token,
+			Value:     c.value,
+			Worker:    c.worker,
+			Age:       int64(now.Sub(c.claimedAt).Seconds()),
+			ExpiresIn: ttlSeconds(&c.deadline, now),
+		})
+	}
+	sort.Slice(report.Claims, func(i, j int) bool {
+		return table.claims[report.Claims[i].Token].claimedAt.Before(table.claims[report.Claims[j].Token].claimedAt)
+	})
+	for worker, n := range table.failures {
+		report.Failures[worker] = n
+	}
+	return report
+}
+
+// RequeueClaims pushes back every lapsed claim on every queue. The sweeper
+// calls it so values are not left claimed until the next command on their queue.
+func (store *KeyValueStore) RequeueClaims() {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := clock.Now()
+	for key := range store.claims {
+		store.requeueClaims(key, now)
+	}
+}
+
+// requeueClaims pushes the values of lapsed claims on key back onto the end
+// QPOP takes from, recreating the queue if it was drained, and counts each
+// lapse against its worker. If key has since been replaced by another type the
+// values are dropped. The caller must hold the store write lock.
+func (store *KeyValueStore) requeueClaims(key string, now time.Time) {
+	table := store.claims[key]
+	if table == nil {
+		return
+	}
+
+	var lapsed []*claim
+	for token, c := range table.claims {
+		if now.Before(c.deadline) {
+			continue
+		}
+		lapsed = append(lapsed, c)
+		delete(table.claims, token)
+		table.failures[c.worker]++
+	}
+	if len(lapsed) == 0 {
+		return
+	}
+
+	kv, ok := store.lookup(key)
+	if ok && (kv.Kind != kindList || kv.Priority != nil) {
+		return
+	}
+	if !ok {
+		kv = &KeyValue{Kind: kindList}
+		store.insert(key, kv)
+	}
+	// The longest-held value is popped first
+	sort.Slice(lapsed, func(i, j int) bool { return lapsed[i].claimedAt.After(lapsed[j].claimedAt) })
+	for _, c := range lapsed {
+		kv.Value = append(kv.Value, c.value)
+	}
+	store.recordQueue(key, len(lapsed), 0)
+	store.serveWaiters(key, kv)
+}
+
+// handleQCLAIM handles QCLAIM key worker seconds, returning the value and its claim token.
+func handleQCLAIM(w http.ResponseWriter, parts []string) {
+	if len(parts) != 4 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	seconds, err := strconv.Atoi(parts[3])
+	if err != nil || seconds <= 0 {
+		sendErrorResponse(w, "invalid visibility timeout")
+		return
+	}
+
+	result, err := store.QClaim(parts[1], parts[2], time.Duration(seconds)*time.Second)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendObjectResponse(w, result)
+}
+
+// handleQACK handles QACK key token, returning 1 if the claim was still held.
+func handleQACK(w http.ResponseWriter, parts []string) {
+	if len(parts) != 3 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	if store.QAck(parts[1], parts[2]) {
+		sendIntegerResponse(w, 1)
+		return
+	}
+	sendIntegerResponse(w, 0)
+}
+
+// handleQCLAIMED handles QCLAIMED key.
+func handleQCLAIMED(w http.ResponseWriter, parts []string) {
+	if len(parts) != 2 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	sendObjectResponse(w, store.QClaimed(parts[1]))
+}
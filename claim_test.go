@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQCLAIMTracksWorkerUntilAcked(t *testing.T) {
+	fake := useFakeClock(t)
+	sendCommand(t, "QPUSH claim-jobs job-1 job-2")
+
+	var claimed struct {
+		Value ClaimResult `json:"value"`
+	}
+	decodeResponse(t, sendCommand(t, "QCLAIM claim-jobs worker-a 30"), &claimed)
+	if claimed.Value.Value != "job-2" || claimed.Value.Token == "" {
+		t.Fatalf("Expected job-2 with a token, but got %+v", claimed.Value)
+	}
+
+	fake.Advance(5 * time.Second)
+	var report struct {
+		Value ClaimReport `json:"value"`
+	}
+	decodeResponse(t, sendCommand(t, "QCLAIMED claim-jobs"), &report)
+	if len(report.Value.Claims) != 1 {
+		t.Fatalf("Expected one claim, but got %+v", report.Value.Claims)
+	}
+	if c := report.Value.Claims[0]; c.Worker != "worker-a" || c.Value != "job-2" || c.Age != 5 || c.ExpiresIn != 25 {
+		t.Errorf("Expected job-2 held by worker-a for 5s with 25s left, but got %+v", c)
+	}
+
+	var acked IntegerResponse
+	decodeResponse(t, sendCommand(t, "QACK claim-jobs "+claimed.Value.Token), &acked)
+	if acked.Value != 1 {
+		t.Errorf("Expected QACK to return 1, but got %d", acked.Value)
+	}
+	decodeResponse(t, sendCommand(t, "QCLAIMED claim-jobs"), &report)
+	if len(report.Value.Claims) != 0 {
+		t.Errorf("Expected no claims after QACK, but got %+v", report.Value.Claims)
+	}
+}
+
+func TestQCLAIMRequeuesLapsedClaims(t *testing.T) {
+	fake := useFakeClock(t)
+	testStore := &KeyValueStore{Data: make(map[string]*KeyValue)}
+	testStore.QPush("jobs", []string{"job-1"})
+
+	first, err := testStore.QClaim("jobs", "worker-a", 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testStore.QClaim("jobs", "worker-b", 10*time.Second); err != errKeyNotFound {
+		t.Fatalf("Expected the drained queue to be gone, but got %v", err)
+	}
+
+	fake.Advance(10 * time.Second)
+	testStore.RequeueClaims()
+	second, err := testStore.QClaim("jobs", "worker-b", 10*time.Second)
+	if err != nil || second.Value != "job-1" {
+		t.Fatalf("Expected job-1 to be requeued, but got %+v, %v", second, err)
+	}
+	if testStore.QAck("jobs", first.Token) {
+		t.Error("Expected the lapsed claim not to be acknowledged")
+	}
+	if report := testStore.QClaimed("jobs"); report.Failures["worker-a"] != 1 || len(report.Claims) != 1 || report.Claims[0].Worker != "worker-b" {
+		t.Errorf("Expected the lapse counted against worker-a and job-1 held by worker-b, but got %+v", report)
+	}
+}
@@ -0,0 +1,147 @@
+// Package client is a small Go client for the key-value store's HTTP API.
+package client
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Client sends commands to a single server.
+type Client struct {
+	Addr       string       // Base URL of the server, e.g. "http://localhost:8080"
+	HTTPClient *http.Client // HTTP client used for requests
+	Retry      RetryPolicy  // Retries for overloaded servers; disabled by default
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for requests.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.HTTPClient = httpClient }
+}
+
+// WithRetry enables retrying commands that the server rejected with 429 or 503.
+func WithRetry(policy RetryPolicy) Option {
+	return func(c *Client) { c.Retry = policy }
+}
+
+// Response is a decoded server reply. Value holds the raw JSON value so callers
+// can decode strings, integers or lists as appropriate.
+type Response struct {
+	Value    json.RawMessage `json:"value"`
+	ValueB64 string          `json:"value_b64"` // Set instead of Value for values that are not valid UTF-8
+	Error    string          `json:"error"`
+}
+
+// ServerError is returned when the server answers a command with an error body.
+type ServerError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("server error (%d): %s", e.StatusCode, e.Message)
+}
+
+// New returns a Client for the server at addr.
+func New(addr string, options ...Option) *Client {
+	c := &Client{
+		Addr:       strings.TrimSuffix(addr, "/"),
+		HTTPClient: http.DefaultClient,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// Do sends a single command and returns the decoded response. Idempotent
+// commands are retried according to the client's RetryPolicy.
+func (c *Client) Do(command string) (*Response, error) {
+	return c.do(command, "")
+}
+
+// DoIdempotent sends a command with an Idempotency-Key header. The server
+// executes it at most once per key, so it is retried even if the command
+// itself is not idempotent.
+func (c *Client) DoIdempotent(command, idempotencyKey string) (*Response, error) {
+	return c.do(command, idempotencyKey)
+}
+
+func (c *Client) do(command, idempotencyKey string) (*Response, error) {
+	retryable := idempotencyKey != "" || isIdempotent(command)
+
+	for attempt := 1; ; attempt++ {
+		reply, retryAfter, err := c.send(command, idempotencyKey)
+		if err == nil || !retryable || attempt >= c.Retry.MaxAttempts || !isRetryableStatus(err) {
+			return reply, err
+		}
+
+		time.Sleep(c.Retry.delay(attempt, retryAfter))
+	}
+}
+
+// send makes a single attempt and returns the server's Retry-After delay, if any.
+func (c *Client) send(command, idempotencyKey string) (*Response, time.Duration, error) {
+	body, err := json.Marshal(struct {
+		Command string `json:"command"`
+	}{command})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.Addr+"/", bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	// Overload responses may come from a proxy and need not carry a JSON body
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		var reply Response
+		json.NewDecoder(resp.Body).Decode(&reply)
+		return nil, parseRetryAfter(resp.Header.Get("Retry-After")), &ServerError{StatusCode: resp.StatusCode, Message: reply.Error}
+	}
+
+	var reply Response
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return nil, 0, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, &ServerError{StatusCode: resp.StatusCode, Message: reply.Error}
+	}
+	return &reply, 0, nil
+}
+
+// String decodes a string value from the response, including binary values
+// sent base64-encoded.
+func (r *Response) String() (string, error) {
+	if r.ValueB64 != "" {
+		b, err := base64.StdEncoding.DecodeString(r.ValueB64)
+		return string(b), err
+	}
+
+	var s string
+	if len(r.Value) == 0 {
+		return "", errors.New("response has no value")
+	}
+	err := json.Unmarshal(r.Value, &s)
+	return s, err
+}
@@ -0,0 +1,133 @@
+package client
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Consistency selects where a ShardedClient sends read commands.
+type Consistency int
+
+const (
+	// Eventual reads from a healthy replica of the owning shard when there is
+	// one, and may observe slightly stale data.
+	Eventual Consistency = iota
+	// Strong always reads from the shard's primary.
+	Strong
+)
+
+// readCommands only read data, so they may be served by a replica.
+var readCommands = map[string]bool{
+	"GET": true, "MGET": true, "MGETMAP": true, "GETDEFAULT": true, "GETCHUNK": true, "STRLEN": true,
+	"LRANGE": true, "QPEEK": true, "QLEN": true, "SORT": true, "SMEMBERS": true, "SRANDMEMBER": true,
+	"HGET": true, "HGETALL": true, "HRANDFIELD": true, "TSRANGE": true,
+	"EXPIRETIME": true, "EXPIRING": true, "PEXPIRETIME": true, "DUMP": true, "OBJECT": true,
+}
+
+func isRead(command string) bool {
+	name := command
+	if i := strings.IndexByte(command, ' '); i >= 0 {
+		name = command[:i]
+	}
+	return readCommands[strings.ToUpper(name)]
+}
+
+// replica is a read-only copy of a shard, tracked by health checks.
+type replica struct {
+	client  *Client
+	healthy int32 // 1 while the replica answers PING, updated atomically
+}
+
+func (r *replica) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+func (r *replica) setHealthy(healthy bool) {
+	var value int32
+	if healthy {
+		value = 1
+	}
+	atomic.StoreInt32(&r.healthy, value)
+}
+
+// replicaSet holds the replicas of one shard, used in round-robin order.
+type replicaSet struct {
+	members []*replica
+	next    uint32 // Round-robin position, updated atomically
+}
+
+// pick returns the next healthy replica, or nil when none is healthy.
+func (rs *replicaSet) pick() *replica {
+	for range rs.members {
+		i := atomic.AddUint32(&rs.next, 1)
+		if r := rs.members[int(i)%len(rs.members)]; r.isHealthy() {
+			return r
+		}
+	}
+	return nil
+}
+
+// AddReplica registers addr as a replica of the shard whose primary is primary.
+// Replicas start out healthy until a health check finds otherwise.
+func (sc *ShardedClient) AddReplica(primary, addr string) error {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	if _, ok := sc.clients[primary]; !ok {
+		return errors.New("unknown primary " + primary)
+	}
+	if sc.replicas == nil {
+		sc.replicas = make(map[string]*replicaSet)
+	}
+
+	// Replica sets are replaced rather than modified, so readers need no lock
+	old := sc.replicas[primary]
+	rs := &replicaSet{}
+	if old != nil {
+		rs.members = append(rs.members, old.members...)
+	}
+	rs.members = append(rs.members, &replica{client: New(addr), healthy: 1})
+	sc.replicas[primary] = rs
+	return nil
+}
+
+// CheckReplicas sends PING to every replica and records which ones answered.
+func (sc *ShardedClient) CheckReplicas() {
+	sc.mutex.RLock()
+	var all []*replica
+	for _, rs := range sc.replicas {
+		all = append(all, rs.members...)
+	}
+	sc.mutex.RUnlock()
+
+	for _, r := range all {
+		reply, err := r.client.Do("PING")
+		healthy := false
+		if err == nil {
+			pong, err := reply.String()
+			healthy = err == nil && pong == "PONG"
+		}
+		r.setHealthy(healthy)
+	}
+}
+
+// StartHealthChecks runs CheckReplicas every interval until the returned stop function is called.
+func (sc *ShardedClient) StartHealthChecks(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				sc.CheckReplicas()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// stubServer records the commands it receives and answers PING according to healthy.
+type stubServer struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	commands []string
+	healthy  bool
+}
+
+func newStubServer() *stubServer {
+	s := &stubServer{healthy: true}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Command string `json:"command"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		if body.Command == "PING" {
+			if !s.healthy {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{"error": "down"})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"value": "PONG"})
+			return
+		}
+		s.commands = append(s.commands, body.Command)
+		json.NewEncoder(w).Encode(map[string]string{"value": "ok"})
+	}))
+	return s
+}
+
+// received returns and clears the non-PING commands seen so far.
+func (s *stubServer) received() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	commands := s.commands
+	s.commands = nil
+	return commands
+}
+
+func TestShardedClientReadsFromReplica(t *testing.T) {
+	primary := newStubServer()
+	defer primary.Close()
+	replica := newStubServer()
+	defer replica.Close()
+
+	sc := NewShardedClient(primary.URL)
+	if err := sc.AddReplica(primary.URL, replica.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, command := range []string{"GET user:1", "SET user:1 alice", "LRANGE jobs 0 -1", "QPUSH jobs a"} {
+		if _, err := sc.Do(command); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := strings.Join(replica.received(), ","); got != "GET user:1,LRANGE jobs 0 -1" {
+		t.Errorf("Expected reads on the replica, but it received %q", got)
+	}
+	if got := strings.Join(primary.received(), ","); got != "SET user:1 alice,QPUSH jobs a" {
+		t.Errorf("Expected writes on the primary, but it received %q", got)
+	}
+
+	// Strong consistency forces reads to the primary
+	if _, err := sc.DoConsistent("GET user:1", Strong); err != nil {
+		t.Fatal(err)
+	}
+	if got := primary.received(); len(got) != 1 {
+		t.Errorf("Expected the strong read on the primary, but it received %q", got)
+	}
+
+	// Once the replica fails its health check, reads fall back to the primary
+	replica.mutex.Lock()
+	replica.healthy = false
+	replica.mutex.Unlock()
+	sc.CheckReplicas()
+
+	if _, err := sc.Do("GET user:1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := replica.received(); len(got) != 0 {
+		t.Errorf("Expected the unhealthy replica to be skipped, but it received %q", got)
+	}
+	if got := primary.received(); len(got) != 1 {
+		t.Errorf("Expected the read to fall back to the primary, but it received %q", got)
+	}
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy controls how commands rejected with 429 Too Many Requests or
+// 503 Service Unavailable are retried. The zero value disables retries.
+type RetryPolicy struct {
+	MaxAttempts int           // Total attempts including the first; values below 2 disable retries
+	BaseDelay   time.Duration // Backoff before the first retry, doubled for each further attempt
+	MaxDelay    time.Duration // Upper bound on a single backoff; 0 means no bound
+}
+
+// DefaultRetryPolicy is a reasonable policy for interactive clients.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   50 * time.Millisecond,
+	MaxDelay:    2 * time.Second,
+}
+
+// idempotentCommands can be safely repeated, so they are retried without an
+// idempotency key. Anything else (INCR, QPUSH, QPOP, ...) is never retried
+// automatically because a lost response may hide a command that did run.
+var idempotentCommands = map[string]bool{
+	"GET": true, "MGET": true, "MGETMAP": true, "GETCHUNK": true, "STRLEN": true, "LRANGE": true, "QPEEK": true, "QLEN": true, "QSTATS": true, "QCLAIMED": true, "SORT": true,
+	"SMEMBERS": true, "SRANDMEMBER": true, "HGET": true, "HGETALL": true, "HRANDFIELD": true, "TSRANGE": true,
+	"SCAN": true, "EXPIRING": true, "PUBSUB": true, "DUMP": true, "OBJECT": true,
+	"SET": true, "MSETEX": true, "ENSURE": true, "DEL": true, "SADD": true, "HSET": true, "SETMAX": true, "SETMIN": true,
+	"PIN": true, "UNPIN": true, "QREPLACE": true, "LDEDUP": true, "QACK": true, "EXPIREPATTERN": true, "QCLOSE": true,
+}
+
+func isIdempotent(command string) bool {
+	name := command
+	if i := strings.IndexByte(command, ' '); i >= 0 {
+		name = command[:i]
+	}
+	return idempotentCommands[strings.ToUpper(name)]
+}
+
+func isRetryableStatus(err error) bool {
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) {
+		return false
+	}
+	return serverErr.StatusCode == http.StatusTooManyRequests || serverErr.StatusCode == http.StatusServiceUnavailable
+}
+
+// delay returns how long to wait before retry number attempt. A Retry-After
+// from the server is honoured up to MaxDelay, so a server cannot stall the
+// client for longer than its policy allows; otherwise the exponential backoff
+// is jittered uniformly over [0, backoff) so that clients do not retry in lockstep.
+func (p RetryPolicy) delay(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		if p.MaxDelay > 0 && retryAfter > p.MaxDelay {
+			return p.MaxDelay
+		}
+		return retryAfter
+	}
+
+	backoff := p.BaseDelay << (attempt - 1)
+	if backoff <= 0 || (p.MaxDelay > 0 && backoff > p.MaxDelay) {
+		backoff = p.MaxDelay
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(backoff)))
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an HTTP date.
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
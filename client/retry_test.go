@@ -0,0 +1,104 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyServer fails the first failures requests with status, then answers with "ok".
+func flakyServer(failures int32, status int, retryAfter string) (*httptest.Server, *int32) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= failures {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(status)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"value": "ok"})
+	}))
+	return server, &calls
+}
+
+func TestClientRetriesOverloadedServer(t *testing.T) {
+	server, calls := flakyServer(2, http.StatusServiceUnavailable, "")
+	defer server.Close()
+
+	c := New(server.URL, WithRetry(RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}))
+
+	resp, err := c.Do("GET key")
+	if err != nil {
+		t.Fatalf("Expected the client to succeed after retrying, but got %v", err)
+	}
+	if value, _ := resp.String(); value != "ok" {
+		t.Errorf("Expected value ok, but got %q", value)
+	}
+	if *calls != 3 {
+		t.Errorf("Expected 3 attempts, but the server saw %d", *calls)
+	}
+}
+
+func TestClientHonoursRetryAfter(t *testing.T) {
+	server, calls := flakyServer(1, http.StatusTooManyRequests, "1")
+	defer server.Close()
+
+	c := New(server.URL, WithRetry(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}))
+
+	start := time.Now()
+	if _, err := c.Do("GET key"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Expected the client to wait for Retry-After, but it retried after %v", elapsed)
+	}
+	if *calls != 2 {
+		t.Errorf("Expected 2 attempts, but the server saw %d", *calls)
+	}
+}
+
+func TestClientClampsRetryAfterToMaxDelay(t *testing.T) {
+	server, calls := flakyServer(1, http.StatusServiceUnavailable, "3600")
+	defer server.Close()
+
+	c := New(server.URL, WithRetry(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}))
+
+	start := time.Now()
+	if _, err := c.Do("GET key"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected Retry-After to be capped at MaxDelay, but the retry took %v", elapsed)
+	}
+	if *calls != 2 {
+		t.Errorf("Expected 2 attempts, but the server saw %d", *calls)
+	}
+}
+
+func TestClientDoesNotRetryNonIdempotentCommands(t *testing.T) {
+	server, calls := flakyServer(2, http.StatusServiceUnavailable, "")
+	defer server.Close()
+
+	c := New(server.URL, WithRetry(RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond}))
+
+	if _, err := c.Do("INCR counter"); err == nil {
+		t.Error("Expected INCR to fail without retrying")
+	}
+	if *calls != 1 {
+		t.Errorf("Expected a single attempt for INCR, but the server saw %d", *calls)
+	}
+
+	// With an idempotency key the server deduplicates, so retrying is safe
+	if _, err := c.DoIdempotent("INCR counter", "request-1"); err != nil {
+		t.Errorf("Expected INCR with an idempotency key to be retried, but got %v", err)
+	}
+
+	// DEBUG RELOAD and DEBUG VERIFY REPAIR change the keyspace, so DEBUG is never retried
+	if isIdempotent("DEBUG RELOAD") {
+		t.Error("Expected DEBUG not to be treated as idempotent")
+	}
+}
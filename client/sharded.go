@@ -0,0 +1,222 @@
+package client
+
+import (
+	"errors"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultVirtualNodes is the number of points each server gets on the hash ring.
+const DefaultVirtualNodes = 160
+
+var (
+	errNoNodes    = errors.New("sharded client has no nodes")
+	errNoKey      = errors.New("command has no key to route by")
+	errCrossShard = errors.New("command keys map to different shards")
+)
+
+// multiKeyCommands returns the key arguments of commands that touch several keys.
+// Such commands are only sent when all of their keys live on the same shard.
+var multiKeyCommands = map[string]func(parts []string) []string{
+	"SMOVE":         func(parts []string) []string { return parts[1:3] },
+	"SWAP":          func(parts []string) []string { return parts[1:3] },
+	"SINTERSTORE":   setOpStoreKeys,
+	"SUNIONSTORE":   setOpStoreKeys,
+	"SDIFFSTORE":    setOpStoreKeys,
+	"MGET":          func(parts []string) []string { return parts[1:] },
+	"QPUSHMULTI":    func(parts []string) []string { return parts[2:] },
+	"EXPIREATMULTI": func(parts []string) []string { return parts[2:] },
+	"MGETMAP":       func(parts []string) []string { return parts[1:] },
+	"LMOVE":         func(parts []string) []string { return parts[1:3] },
+	"BLMOVE":        func(parts []string) []string { return parts[1:3] },
+	"MSETEX": func(parts []string) []string {
+		var keys []string
+		for i := 1; i < len(parts); i += 3 {
+			keys = append(keys, parts[i])
+		}
+		return keys
+	},
+}
+
+// setOpStoreKeys returns the keys of SINTERSTORE and friends: dest and the
+// sources, skipping the optional EX seconds after dest.
+func setOpStoreKeys(parts []string) []string {
+	if len(parts) > 4 && strings.EqualFold(parts[2], "EX") {
+		return append([]string{parts[1]}, parts[4:]...)
+	}
+	return parts[1:]
+}
+
+// hashRing maps keys to nodes using consistent hashing with virtual nodes,
+// so adding or removing a node only remaps the keys next to its points.
+type hashRing struct {
+	virtualNodes int
+	points       []uint32          // Sorted hashes of all virtual nodes
+	owners       map[uint32]string // Virtual node hash to node address
+}
+
+func newHashRing(virtualNodes int) *hashRing {
+	return &hashRing{virtualNodes: virtualNodes, owners: make(map[uint32]string)}
+}
+
+func (r *hashRing) add(node string) {
+	for i := 0; i < r.virtualNodes; i++ {
+		point := crc32.ChecksumIEEE([]byte(node + "#" + strconv.Itoa(i)))
+		if _, taken := r.owners[point]; taken {
+			continue
+		}
+		r.owners[point] = node
+		r.points = append(r.points, point)
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+}
+
+func (r *hashRing) remove(node string) {
+	points := r.points[:0]
+	for _, point := range r.points {
+		if r.owners[point] == node {
+			delete(r.owners, point)
+			continue
+		}
+		points = append(points, point)
+	}
+	r.points = points
+}
+
+// get returns the node owning key: the first virtual node clockwise from the key's hash.
+func (r *hashRing) get(key string) (string, bool) {
+	if len(r.points) == 0 {
+		return "", false
+	}
+
+	hash := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]], true
+}
+
+// ShardedClient spreads keys across several servers with consistent hashing.
+// Single-key commands are routed by their key (the first argument); multi-key
+// commands are rejected when their keys span more than one shard. Shards may
+// have replicas, which serve reads unless the caller asks for Strong consistency.
+type ShardedClient struct {
+	mutex    sync.RWMutex
+	ring     *hashRing
+	clients  map[string]*Client     // Primary of each shard
+	replicas map[string]*replicaSet // Replicas by primary address
+}
+
+// NewShardedClient returns a ShardedClient over the given server addresses.
+func NewShardedClient(addrs ...string) *ShardedClient {
+	sc := &ShardedClient{
+		ring:    newHashRing(DefaultVirtualNodes),
+		clients: make(map[string]*Client),
+	}
+	for _, addr := range addrs {
+		sc.AddNode(addr)
+	}
+	return sc
+}
+
+// AddNode adds a server to the ring.
+func (sc *ShardedClient) AddNode(addr string) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	if _, ok := sc.clients[addr]; ok {
+		return
+	}
+	sc.clients[addr] = New(addr)
+	sc.ring.add(addr)
+}
+
+// RemoveNode removes a server from the ring.
+func (sc *ShardedClient) RemoveNode(addr string) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	delete(sc.clients, addr)
+	delete(sc.replicas, addr)
+	sc.ring.remove(addr)
+}
+
+// NodeFor returns the address of the server owning key.
+func (sc *ShardedClient) NodeFor(key string) (string, error) {
+	sc.mutex.RLock()
+	defer sc.mutex.RUnlock()
+
+	node, ok := sc.ring.get(key)
+	if !ok {
+		return "", errNoNodes
+	}
+	return node, nil
+}
+
+// Do routes command to the shard owning its key and sends it. Reads go to a
+// healthy replica of the shard when it has one.
+func (sc *ShardedClient) Do(command string) (*Response, error) {
+	return sc.DoConsistent(command, Eventual)
+}
+
+// DoConsistent is Do with an explicit read consistency. With Strong, reads are
+// always sent to the shard's primary. Writes always go to the primary.
+func (sc *ShardedClient) DoConsistent(command string, consistency Consistency) (*Response, error) {
+	// The node and its clients are read together, so a concurrent RemoveNode
+	// cannot leave a node without a primary
+	sc.mutex.RLock()
+	node, err := sc.nodeFor(command)
+	primary, rs := sc.clients[node], sc.replicas[node]
+	sc.mutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if primary == nil {
+		return nil, errNoNodes
+	}
+
+	if consistency == Eventual && rs != nil && isRead(command) {
+		if r := rs.pick(); r != nil {
+			reply, err := r.client.Do(command)
+
+			// An unreachable replica is skipped until a health check sees it again
+			var serverErr *ServerError
+			if err == nil || errors.As(err, &serverErr) {
+				return reply, err
+			}
+			r.setHealthy(false)
+		}
+	}
+	return primary.Do(command)
+}
+
+// nodeFor picks the shard for a command based on its keys. The caller must
+// hold sc.mutex.
+func (sc *ShardedClient) nodeFor(command string) (string, error) {
+	parts := strings.Split(command, " ")
+	if len(parts) < 2 {
+		return "", errNoKey
+	}
+
+	keys := parts[1:2]
+	if keysOf, ok := multiKeyCommands[strings.ToUpper(parts[0])]; ok && len(parts) >= 3 {
+		keys = keysOf(parts)
+	}
+
+	var node string
+	for _, key := range keys {
+		owner, ok := sc.ring.get(key)
+		if !ok {
+			return "", errNoNodes
+		}
+		if node != "" && owner != node {
+			return "", errCrossShard
+		}
+		node = owner
+	}
+	return node, nil
+}
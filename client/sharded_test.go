@@ -0,0 +1,112 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestShardedClientStableOnAddNode(t *testing.T) {
+	sc := NewShardedClient("node-a", "node-b", "node-c")
+
+	const keys = 10000
+	before := make(map[string]string, keys)
+	for i := 0; i < keys; i++ {
+		key := "key:" + strconv.Itoa(i)
+		node, err := sc.NodeFor(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before[key] = node
+	}
+
+	sc.AddNode("node-d")
+
+	moved := 0
+	for key, oldNode := range before {
+		node, err := sc.NodeFor(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if node == oldNode {
+			continue
+		}
+		moved++
+
+		// Keys may only move onto the new node, never between existing ones
+		if node != "node-d" {
+			t.Fatalf("Expected %s to stay on %s or move to node-d, but it moved to %s", key, oldNode, node)
+		}
+	}
+
+	// Ideally a quarter of the keys move; allow some slack for hash variance
+	if fraction := float64(moved) / keys; fraction < 0.1 || fraction > 0.4 {
+		t.Errorf("Expected roughly a quarter of the keys to move, but %.2f moved", fraction)
+	}
+}
+
+func TestShardedClientRouting(t *testing.T) {
+	// Each server answers with its own name so the test can see where a command went
+	newServer := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(map[string]string{"value": name})
+		}))
+	}
+	a, b := newServer("a"), newServer("b")
+	defer a.Close()
+	defer b.Close()
+
+	sc := NewShardedClient(a.URL, b.URL)
+
+	for i := 0; i < 20; i++ {
+		key := "user:" + strconv.Itoa(i)
+		node, _ := sc.NodeFor(key)
+
+		resp, err := sc.Do("GET " + key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name, _ := resp.String()
+
+		if (node == a.URL) != (name == "a") {
+			t.Errorf("Expected GET %s to be sent to %s, but server %s answered", key, node, name)
+		}
+	}
+
+	// Find two keys on different shards and check a multi-key command is rejected
+	var other string
+	first, _ := sc.NodeFor("user:0")
+	for i := 1; other == ""; i++ {
+		if node, _ := sc.NodeFor("user:" + strconv.Itoa(i)); node != first {
+			other = "user:" + strconv.Itoa(i)
+		}
+	}
+	if _, err := sc.Do("SINTERSTORE user:0 " + other); err != errCrossShard {
+		t.Errorf("Expected a cross-shard error, but got %v", err)
+	}
+}
+
+func TestShardedClientDoDuringRemoveNode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"value": "ok"})
+	}))
+	defer server.Close()
+
+	sc := NewShardedClient(server.URL, "http://127.0.0.1:1")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			sc.RemoveNode(server.URL)
+			sc.AddNode(server.URL)
+		}
+	}()
+
+	// A command routed to a node that is removed meanwhile must not panic
+	for i := 0; i < 200; i++ {
+		sc.Do("GET key:" + strconv.Itoa(i))
+	}
+	<-done
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Clock tells the store the current time, for expiry, idle tracking and
+// delayed values. Tests and -debug-clock servers use a FakeClock instead of
+// real time. Blocking timeouts and lock deadlines always use real time.
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock reads the system clock.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// FakeClock is a Clock that only moves when it is set or advanced.
+type FakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+// NewFakeClock returns a FakeClock stopped at now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the clock's current time.
+func (c *FakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+// Set moves the clock to now.
+func (c *FakeClock) Set(now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = now
+}
+
+// Advance moves the clock forward by d.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// clock is the time source used by the store. It is replaced before the server
+// starts, or by tests, and never while commands are running.
+var clock Clock = realClock{}
+
+var errRealClock = errors.New("the clock can only be changed on a server started with -debug-clock")
+
+// handleDebugClock handles DEBUG TIME, returning the clock's Unix time in
+// milliseconds, and on servers started with -debug-clock, DEBUG SET-TIME
+// unix-ms and DEBUG ADVANCE-TIME duration (such as 90s or 1h).
+func handleDebugClock(w http.ResponseWriter, parts []string) {
+	subcommand := strings.ToUpper(parts[1])
+	if subcommand == "TIME" {
+		if len(parts) != 2 {
+			sendErrorResponse(w, "invalid command format")
+			return
+		}
+		sendIntegerResponse(w, clock.Now().UnixMilli())
+		return
+	}
+
+	if len(parts) != 3 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+	fake, ok := clock.(*FakeClock)
+	if !ok {
+		sendErrorResponse(w, errRealClock.Error())
+		return
+	}
+
+	if subcommand == "SET-TIME" {
+		ms, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			sendErrorResponse(w, "invalid time")
+			return
+		}
+		fake.Set(time.UnixMilli(ms))
+	} else {
+		d, err := time.ParseDuration(parts[2])
+		if err != nil || d < 0 {
+			sendErrorResponse(w, "invalid duration")
+			return
+		}
+		fake.Advance(d)
+	}
+	sendIntegerResponse(w, fake.Now().UnixMilli())
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// useFakeClock replaces the store clock with a FakeClock for the rest of the test.
+func useFakeClock(t *testing.T) *FakeClock {
+	fake := NewFakeClock(time.Now())
+	clock = fake
+	t.Cleanup(func() { clock = realClock{} })
+	return fake
+}
+
+func TestFakeClockExpiresKeys(t *testing.T) {
+	fake := useFakeClock(t)
+	testStore := &KeyValueStore{Data: make(map[string]*KeyValue)}
+
+	expiry := fake.Now().Add(10 * time.Second)
+	testStore.Set("session", "abc", &expiry, "")
+	testStore.QPushDelayed("jobs", "later", 5*time.Second)
+
+	fake.Advance(9 * time.Second)
+	if value, err := testStore.Get("session"); err != nil || value != "abc" {
+		t.Errorf("Expected the key to live until its expiry, but got %q, %v", value, err)
+	}
+	if value, err := testStore.QPop("jobs"); err != nil || value != "later" {
+		t.Errorf("Expected the delayed value to be visible after 5s, but got %q, %v", value, err)
+	}
+
+	fake.Advance(2 * time.Second)
+	if _, err := testStore.Get("session"); err != errKeyNotFound {
+		t.Errorf("Expected the key to expire once the clock passes its expiry, but got %v", err)
+	}
+
+	_, expired := testStore.sweepRound(10)
+	if expired != 1 || len(testStore.Data) != 0 {
+		t.Errorf("Expected the sweeper to remove the expired key, but removed %d and %d keys remain", expired, len(testStore.Data))
+	}
+}
+
+func TestFakeClockExpiresIdleKeys(t *testing.T) {
+	fake := useFakeClock(t)
+	testStore := &KeyValueStore{Data: make(map[string]*KeyValue), maxIdle: time.Minute}
+	testStore.Set("cache", "v", nil, "")
+
+	// Each read slides the idle window forward
+	for i := 0; i < 3; i++ {
+		fake.Advance(50 * time.Second)
+		if _, err := testStore.Get("cache"); err != nil {
+			t.Fatalf("Expected the key to stay alive while read within its idle window, but got %v", err)
+		}
+	}
+
+	fake.Advance(61 * time.Second)
+	if _, err := testStore.Get("cache"); err != errKeyNotFound {
+		t.Errorf("Expected the key to expire after a minute idle, but got %v", err)
+	}
+}
+
+func TestDEBUGADVANCETIME(t *testing.T) {
+	var response ErrorResponse
+	decodeResponse(t, sendCommand(t, "DEBUG ADVANCE-TIME 1h"), &response)
+	if response.Error != errRealClock.Error() {
+		t.Errorf("Expected the real clock to refuse changes, but got %q", response.Error)
+	}
+
+	fake := useFakeClock(t)
+	sendCommand(t, "SET debug-clock-key value EX10")
+
+	var now IntegerResponse
+	decodeResponse(t, sendCommand(t, "DEBUG ADVANCE-TIME 11s"), &now)
+	if now.Value != fake.Now().UnixMilli() {
+		t.Errorf("Expected the advanced clock time %d, but got %d", fake.Now().UnixMilli(), now.Value)
+	}
+
+	decodeResponse(t, sendCommand(t, "GET debug-clock-key"), &response)
+	if response.Error != errKeyNotFound.Error() {
+		t.Errorf("Expected the key to expire after advancing the clock, but got %q", response.Error)
+	}
+
+	decodeResponse(t, sendCommand(t, "DEBUG SET-TIME 1000"), &now)
+	decodeResponse(t, sendCommand(t, "DEBUG TIME"), &now)
+	if now.Value != 1000 {
+		t.Errorf("Expected DEBUG TIME to report 1000 after SET-TIME, but got %d", now.Value)
+	}
+}
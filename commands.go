@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// arity bounds the number of arguments a command takes, not counting its name.
+// A max of -1 means any number of arguments from min upwards.
+type arity struct {
+	min, max int
+}
+
+// commandArity lists the argument counts accepted by each command, so malformed
+// commands get the same descriptive error before reaching their handlers.
+// Every command handled by dispatchRequest must be listed here.
+var commandArity = map[string]arity{
+	"PING":          {0, 0},
+	"SET":           {2, -1},
+	"SETNX":         {2, 2},
+	"MSETEX":        {3, -1},
+	"ENSURE":        {2, 2},
+	"GET":           {1, 2},
+	"UNLINK":        {1, -1},
+	"MGET":          {1, -1},
+	"MGETMAP":       {1, -1},
+	"GETDEFAULT":    {2, 2},
+	"EXPIREPATTERN": {2, 2},
+	"EXPIREATMULTI": {2, -1},
+	"EXPIRING":      {1, 3},
+	"EXPIRETIME":    {1, 1},
+	"PEXPIRETIME":   {1, 1},
+	"GETVER":        {1, 1},
+	"WATCHGET":      {2, 3},
+	"SETVER":        {3, 3},
+	"SETIF":         {4, 4},
+	"GETORLOCK":     {3, 3},
+	"LOCKEXTEND":    {3, 3},
+	"DEL":           {1, -1},
+	"GETCHUNK":      {3, 3},
+	"STRLEN":        {1, 1},
+	"INCR":          {1, 1},
+	"INCREX":        {2, 2},
+	"GETRESET":      {1, 1},
+	"APPLY":         {2, -1},
+	"DECRDEL":       {1, 1},
+	"SETMAX":        {2, 2},
+	"SETMIN":        {2, 2},
+	"QPUSH":         {2, -1},
+	"QPUSHMULTI":    {2, -1},
+	"QPOP":          {1, 1},
+	"QPUSHDELAYED":  {3, 3},
+	"QLEN":          {1, 1},
+	"QSTATS":        {1, 1},
+	"QCLAIM":        {3, 3},
+	"QACK":          {2, 2},
+	"QCLAIMED":      {1, 1},
+	"LRANGE":        {3, 3},
+	"LREMPREFIX":    {3, 3},
+	"LDEDUP":        {1, 1},
+	"QSWAP":         {2, 2},
+	"ROTATE":        {1, 1},
+	"QCLOSE":        {1, 1},
+	"SWAP":          {2, 2},
+	"QREPLACE":      {2, -1},
+	"QPEEK":         {1, 3},
+	"LPUSHGET":      {2, -1},
+	"BQPOP":         {1, 2},
+	"LMOVE":         {4, 4},
+	"BLMOVE":        {5, 5},
+	"BQDRAIN":       {3, 3},
+	"BLOCKED":       {1, 3},
+	"EVICT":         {1, 1},
+	"PIN":           {1, 1},
+	"UNPIN":         {1, 1},
+	"DUMP":          {1, 1},
+	"RESTORE":       {3, 4},
+	"MIGRATE":       {5, -1},
+	"SCAN":          {1, -1},
+	"PUBLISH":       {2, 5},
+	"PUBACK":        {2, 2},
+	"PUBSUB":        {1, -1},
+	"SCHEDULE":      {1, -1},
+	"EXPIRED":       {1, 1},
+	"MEMORY":        {1, 4},
+	"DEBUG":         {1, 3},
+	"OBJECT":        {2, 2},
+	"SORT":          {1, -1},
+	"SADD":          {2, -1},
+	"SMEMBERS":      {1, 1},
+	"SMOVE":         {3, 3},
+	"SRANDMEMBER":   {1, 2},
+	"TSADD":         {3, -1},
+	"TSRANGE":       {3, 3},
+	"HSET":          {3, -1},
+	"HGET":          {2, 2},
+	"HGETALL":       {1, 1},
+	"HRANDFIELD":    {1, 3},
+	"SINTERSTORE":   {2, -1},
+	"SUNIONSTORE":   {2, -1},
+	"SDIFFSTORE":    {2, -1},
+}
+
+// denyOOMCommands lists the commands that may grow memory usage. They are
+// refused once usage passes the limit under the noeviction policy, and warned
+// about under memory pressure, while reads and deletes always run.
+var denyOOMCommands = map[string]bool{
+	"SET": true, "SETNX": true, "MSETEX": true, "ENSURE": true, "SETVER": true, "SETIF": true,
+	"INCR": true, "INCREX": true, "SETMAX": true, "SETMIN": true, "APPLY": true,
+	"QPUSH": true, "QPUSHMULTI": true, "QPUSHDELAYED": true, "QREPLACE": true, "LPUSHGET": true,
+	"LMOVE": true, "BLMOVE": true, "QSWAP": true, "QCLAIM": true, "RESTORE": true,
+	"SADD": true, "SMOVE": true, "HSET": true, "TSADD": true, "SINTERSTORE": true, "SUNIONSTORE": true, "SDIFFSTORE": true,
+	"GETORLOCK": true, "SCHEDULE": true,
+}
+
+// checkCommand validates the command name and argument count of parts,
+// returning a message that says what was wrong.
+func checkCommand(parts []string) error {
+	name := strings.ToUpper(parts[0])
+	a, ok := commandArity[name]
+	if !ok {
+		return fmt.Errorf("unknown command '%s'", parts[0])
+	}
+
+	got := len(parts) - 1
+	switch {
+	case a.min == a.max && got != a.min:
+		return fmt.Errorf("%s requires %s, got %d", name, plural(a.min, "argument"), got)
+	case a.max == -1 && got < a.min:
+		return fmt.Errorf("%s requires at least %s, got %d", name, plural(a.min, "argument"), got)
+	case a.max != -1 && (got < a.min || got > a.max):
+		return fmt.Errorf("%s requires %d to %d arguments, got %d", name, a.min, a.max, got)
+	}
+	return nil
+}
+
+// commandRenames holds the -rename-command settings, mapping an uppercase
+// name clients may send to the command it runs. A renamed or disabled
+// command maps from its own name to "", so it is unknown under that name.
+type commandRenames map[string]string
+
+var renamedCommands = commandRenames{}
+
+func (r commandRenames) String() string {
+	var specs []string
+	for from, to := range r {
+		if to != "" && to != from {
+			specs = append(specs, to+" "+from)
+		}
+	}
+	return strings.Join(specs, ",")
+}
+
+// Set parses "COMMAND NEWNAME", which makes COMMAND available only as
+// NEWNAME, or "COMMAND" or `COMMAND ""`, which disables it, as Redis'
+// rename-command does.
+func (r commandRenames) Set(spec string) error {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 || len(fields) > 2 {
+		return fmt.Errorf("invalid rename %q; expected COMMAND NEWNAME or COMMAND \"\"", spec)
+	}
+	name := strings.ToUpper(fields[0])
+	if _, ok := commandArity[name]; !ok {
+		return fmt.Errorf("unknown command '%s'", fields[0])
+	}
+	if _, ok := r[name]; !ok {
+		r[name] = ""
+	}
+	if len(fields) == 1 || fields[1] == `""` {
+		return nil
+	}
+	alias := strings.ToUpper(fields[1])
+	if _, ok := commandArity[alias]; ok {
+		return fmt.Errorf("cannot rename %s to %s, which is a command", name, alias)
+	}
+	if _, ok := r[alias]; ok {
+		return fmt.Errorf("%s is already renamed", alias)
+	}
+	r[alias] = name
+	return nil
+}
+
+// resolveCommand returns the command that name runs under -rename-command.
+// A command that has been renamed or disabled is unknown under its own name.
+func resolveCommand(name string) (string, error) {
+	to, ok := renamedCommands[strings.ToUpper(name)]
+	if !ok {
+		return name, nil
+	}
+	if to == "" {
+		return "", fmt.Errorf("unknown command '%s'", name)
+	}
+	return to, nil
+}
+
+// allowRoute reports whether an HTTP route that runs the command name may be
+// used, replying with 403 Forbidden if not. A route is refused once its
+// command is renamed or disabled, so the route cannot be used to get around
+// -rename-command.
+func allowRoute(w http.ResponseWriter, name string) bool {
+	if _, err := resolveCommand(name); err != nil {
+		sendStatusErrorResponse(w, http.StatusForbidden, fmt.Sprintf("%s is disabled", name))
+		return false
+	}
+	return true
+}
+
+// unexpectedToken describes an unrecognised option at parts[i]. Positions
+// count from 1 at the command name.
+func unexpectedToken(parts []string, i int, expected string) string {
+	return fmt.Sprintf("unexpected token '%s' at position %d; expected %s", parts[i], i+1, expected)
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMalformedCommandErrors(t *testing.T) {
+	tests := []struct {
+		command  string
+		expected string
+	}{
+		{"SET key", "SET requires at least 2 arguments, got 1"},
+		{"GET", "GET requires 1 to 2 arguments, got 0"},
+		{"get a b c", "GET requires 1 to 2 arguments, got 3"},
+		{"STRLEN a b", "STRLEN requires 1 argument, got 2"},
+		{"BQPOP q 1 2", "BQPOP requires 1 to 2 arguments, got 3"},
+		{"FROB key", "unknown command 'FROB'"},
+		{"SET key value FOO", "unexpected token 'FOO' at position 4; expected EX<seconds>, NX, XX, or IDLE"},
+		{"SORT key ALPHA BACKWARDS", "unexpected token 'BACKWARDS' at position 4; expected ALPHA, LIMIT, ASC, or DESC"},
+	}
+	for _, test := range tests {
+		var response ErrorResponse
+		decodeResponse(t, sendCommand(t, test.command), &response)
+		if response.Error != test.expected {
+			t.Errorf("%s: expected %q, but got %q", test.command, test.expected, response.Error)
+		}
+	}
+}
+
+func TestRenameCommand(t *testing.T) {
+	saved := renamedCommands
+	t.Cleanup(func() { renamedCommands = saved })
+	renamedCommands = commandRenames{}
+
+	for _, spec := range []string{"DEBUG", "strlen SECRET_STRLEN"} {
+		if err := renamedCommands.Set(spec); err != nil {
+			t.Fatalf("Set(%q): %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"FROB NEWNAME", "GET SET", "PING a b"} {
+		if err := renamedCommands.Set(spec); err == nil {
+			t.Errorf("Expected rename %q to be rejected", spec)
+		}
+	}
+
+	sendCommand(t, "SET renamed-key hello")
+
+	for _, command := range []string{"DEBUG OBJECT renamed-key", "STRLEN renamed-key"} {
+		var response ErrorResponse
+		rr := sendCommand(t, command)
+		decodeResponse(t, rr, &response)
+		name := strings.Fields(command)[0]
+		if rr.Code != 400 || response.Error != "unknown command '"+name+"'" {
+			t.Errorf("%s: expected the command to be unknown, but got %d %q", command, rr.Code, response.Error)
+		}
+	}
+
+	var length IntegerResponse
+	rr := sendCommand(t, "secret_strlen renamed-key")
+	decodeResponse(t, rr, &length)
+	if rr.Code != 200 || length.Value != 5 {
+		t.Errorf("Expected the new name to run STRLEN, but got %d %+v", rr.Code, length)
+	}
+}
+
+func TestRenamedCommandsRefuseTheirRoutes(t *testing.T) {
+	saved := renamedCommands
+	t.Cleanup(func() { renamedCommands = saved })
+	renamedCommands = commandRenames{}
+
+	for _, spec := range []string{"DEL", "SET SECRET_SET", "DUMP", "MEMORY"} {
+		if err := renamedCommands.Set(spec); err != nil {
+			t.Fatalf("Set(%q): %v", spec, err)
+		}
+	}
+
+	tests := []struct {
+		method, path string
+		handler      http.HandlerFunc
+		code         int
+	}{
+		{http.MethodDelete, "/kv/route-key", handleKeyRequest, http.StatusForbidden},
+		{http.MethodPut, "/kv/route-key", handleKeyRequest, http.StatusForbidden},
+		{http.MethodGet, "/kv/route-key", handleKeyRequest, http.StatusNotFound},
+		{http.MethodGet, "/dump.rdb", handleDumpRDB, http.StatusForbidden},
+		{http.MethodGet, "/report.csv", handleReport, http.StatusForbidden},
+		{http.MethodGet, "/metrics", handleMetrics, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		rr := httptest.NewRecorder()
+		test.handler(rr, httptest.NewRequest(test.method, test.path, strings.NewReader("value")))
+		if rr.Code != test.code {
+			t.Errorf("%s %s: expected status %d, but got %d", test.method, test.path, test.code, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errNotInteger = errors.New("value is not an integer")
+
+// lookupInt returns the integer stored at key and its entry, or ok=false when the key is missing.
+// The caller must hold the store mutex.
+func (store *KeyValueStore) lookupInt(key string) (n int64, kv *KeyValue, ok bool, err error) {
+	kv, ok = store.lookup(key)
+	if !ok {
+		return 0, nil, false, nil
+	}
+	if kv.Kind != kindString {
+		return 0, nil, false, errWrongType
+	}
+
+	n, err = strconv.ParseInt(strings.Join(kv.Value, " "), 10, 64)
+	if err != nil {
+		return 0, nil, false, errNotInteger
+	}
+	return n, kv, true, nil
+}
+
+// setBound stores n at key when the key is missing or when keep reports that n
+// should replace the current value. It returns the value stored afterwards.
+func (store *KeyValueStore) setBound(key string, n int64, keep func(current, n int64) bool) (int64, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	current, kv, ok, err := store.lookupInt(key)
+	if err != nil {
+		return 0, err
+	}
+
+	if !ok {
+		store.insert(key, &KeyValue{Kind: kindString, Value: []string{strconv.FormatInt(n, 10)}})
+		return n, nil
+	}
+
+	if keep(current, n) {
+		kv.Value = []string{strconv.FormatInt(n, 10)}
+		store.stamp(key, kv)
+		return n, nil
+	}
+	return current, nil
+}
+
+// SetMax stores n at key only if it is greater than the current value, returning the resulting value.
+func (store *KeyValueStore) SetMax(key string, n int64) (int64, error) {
+	return store.setBound(key, n, func(current, n int64) bool { return n > current })
+}
+
+// SetMin stores n at key only if it is less than the current value, returning the resulting value.
+func (store *KeyValueStore) SetMin(key string, n int64) (int64, error) {
+	return store.setBound(key, n, func(current, n int64) bool { return n < current })
+}
+
+// handleSETMAX handles SETMAX key n and SETMIN key n.
+func handleSETMAX(w http.ResponseWriter, parts []string) {
+	if len(parts) != 3 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	// ParseInt rejects values outside the int64 range
+	n, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		sendErrorResponse(w, errNotInteger.Error())
+		return
+	}
+
+	var result int64
+	if strings.ToUpper(parts[0]) == "SETMIN" {
+		result, err = store.SetMin(parts[1], n)
+	} else {
+		result, err = store.SetMax(parts[1], n)
+	}
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendIntegerResponse(w, result)
+}
+
+// IncrExResult is the reply to INCREX: the new count and the seconds left in
+// the current window, or -1 if the counter has no expiry.
+type IncrExResult struct {
+	Count int64 `json:"count"`
+	TTL   int64 `json:"ttl"`
+}
+
+// IncrEx increments the integer at key and, when that starts a new count of 1,
+// sets the key to expire after window. This implements a fixed-window rate
+// limiter without the race between a separate INCR and EXPIRE.
+func (store *KeyValueStore) IncrEx(key string, window time.Duration) (IncrExResult, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	current, kv, ok, err := store.lookupInt(key)
+	if err != nil {
+		return IncrExResult{}, err
+	}
+	if current == math.MaxInt64 {
+		return IncrExResult{}, errors.New("increment would overflow")
+	}
+	current++
+
+	if !ok {
+		kv = &KeyValue{Kind: kindString}
+		store.insert(key, kv)
+	}
+	kv.Value = []string{strconv.FormatInt(current, 10)}
+	store.stamp(key, kv)
+	if current == 1 {
+		kv.refreshTTL(window)
+	}
+
+	return IncrExResult{Count: current, TTL: ttlSeconds(kv.ExpiryTime, clock.Now())}, nil
+}
+
+// handleINCREX handles INCREX key window, where window is in seconds.
+func handleINCREX(w http.ResponseWriter, parts []string) {
+	if len(parts) != 3 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	seconds, err := strconv.Atoi(parts[2])
+	if err != nil || seconds <= 0 {
+		sendErrorResponse(w, "invalid expiry time")
+		return
+	}
+
+	result, err := store.IncrEx(parts[1], time.Duration(seconds)*time.Second)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendObjectResponse(w, result)
+}
+
+// GetReset returns the integer stored at key and zeroes it in one step, so no
+// increment lands between the read and the reset. The key keeps its TTL; a
+// missing key reads as 0 and is not created.
+func (store *KeyValueStore) GetReset(key string) (int64, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	current, kv, ok, err := store.lookupInt(key)
+	if err != nil || !ok {
+		return 0, err
+	}
+
+	kv.Value = []string{"0"}
+	store.stamp(key, kv)
+	return current, nil
+}
+
+// handleGETRESET handles GETRESET key, returning the counter's value before the reset.
+func handleGETRESET(w http.ResponseWriter, parts []string) {
+	if len(parts) != 2 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	n, err := store.GetReset(parts[1])
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendIntegerResponse(w, n)
+}
+
+// DecrDel decrements the integer stored at key and deletes the key once the
+// result is 0 or less, returning the result. This releases a reference count
+// without a race between a DECR and a DEL. A missing key reads as 0, so it
+// returns -1 and is not created.
+func (store *KeyValueStore) DecrDel(key string) (int64, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	current, kv, ok, err := store.lookupInt(key)
+	if err != nil {
+		return 0, err
+	}
+	if current == math.MinInt64 {
+		return 0, errors.New("decrement would overflow")
+	}
+	current--
+
+	if !ok {
+		return current, nil
+	}
+	if current <= 0 {
+		store.drop(key)
+		return current, nil
+	}
+	kv.Value = []string{strconv.FormatInt(current, 10)}
+	store.stamp(key, kv)
+	return current, nil
+}
+
+// handleDECRDEL handles DECRDEL key, returning the decremented value. A result
+// of 0 or less means the key was deleted.
+func handleDECRDEL(w http.ResponseWriter, parts []string) {
+	if len(parts) != 2 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	n, err := store.DecrDel(parts[1])
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendIntegerResponse(w, n)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSETMAXConcurrentWriters(t *testing.T) {
+	var wg sync.WaitGroup
+	var maxMutex sync.Mutex
+	highest := int64(math.MinInt64)
+
+	// Writers send a mix of increasing and decreasing values
+	for writer := 0; writer < 8; writer++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+
+			for i := 0; i < 200; i++ {
+				n := r.Int63n(1000000) - 500000
+				if _, err := store.SetMax("high-water", n); err != nil {
+					t.Error(err)
+					return
+				}
+
+				maxMutex.Lock()
+				if n > highest {
+					highest = n
+				}
+				maxMutex.Unlock()
+			}
+		}(int64(writer))
+	}
+	wg.Wait()
+
+	var response ValueResponse
+	decodeResponse(t, sendCommand(t, "GET high-water"), &response)
+	if response.Value != strconv.FormatInt(highest, 10) {
+		t.Errorf("Expected the stored value to be the highest seen %d, but got %s", highest, response.Value)
+	}
+}
+
+func TestSETMIN(t *testing.T) {
+	cases := []struct {
+		command  string
+		expected int64
+	}{
+		{"SETMIN low-water 10", 10},
+		{"SETMIN low-water 20", 10},
+		{"SETMIN low-water -5", -5},
+	}
+
+	for _, c := range cases {
+		var response IntegerResponse
+		decodeResponse(t, sendCommand(t, c.command), &response)
+		if response.Value != c.expected {
+			t.Errorf("%s: expected %d, but got %d", c.command, c.expected, response.Value)
+		}
+	}
+
+	if rr := sendCommand(t, "SETMIN low-water 99999999999999999999"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected an out of range value to be rejected, but got status %d", rr.Code)
+	}
+}
+
+func TestINCREXSetsTTLOnFirstIncrement(t *testing.T) {
+	var first, second struct {
+		Value IncrExResult `json:"value"`
+	}
+	decodeResponse(t, sendCommand(t, "INCREX ratelimit:client-1 60"), &first)
+	if first.Value.Count != 1 || first.Value.TTL != 60 {
+		t.Errorf("Expected count 1 with a 60s window, but got %+v", first.Value)
+	}
+
+	// Later increments in the window must not extend it
+	store.mutex.Lock()
+	shortened := time.Now().Add(30 * time.Second)
+	store.Data["ratelimit:client-1"].ExpiryTime = &shortened
+	store.mutex.Unlock()
+
+	decodeResponse(t, sendCommand(t, "INCREX ratelimit:client-1 60"), &second)
+	if second.Value.Count != 2 || second.Value.TTL != 30 {
+		t.Errorf("Expected count 2 with the window left at 30s, but got %+v", second.Value)
+	}
+
+	// A counter created by plain INCR has no window to report
+	sendCommand(t, "INCR ratelimit:plain")
+	decodeResponse(t, sendCommand(t, "INCREX ratelimit:plain 60"), &second)
+	if second.Value.Count != 2 || second.Value.TTL != -1 {
+		t.Errorf("Expected count 2 without a TTL, but got %+v", second.Value)
+	}
+}
+
+func TestGETRESETLosesNoIncrements(t *testing.T) {
+	const incrementers, increments = 4, 500
+
+	var wg sync.WaitGroup
+	for i := 0; i < incrementers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < increments; j++ {
+				sendCommand(t, "INCR getreset-counter")
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// Scrape continuously while the incrementers run
+	var scraped int64
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+
+		var response IntegerResponse
+		decodeResponse(t, sendCommand(t, "GETRESET getreset-counter"), &response)
+		scraped += response.Value
+	}
+
+	final, err := store.GetReset("getreset-counter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total := scraped + final; total != incrementers*increments {
+		t.Errorf("Expected scraped counts plus the final value to total %d, but got %d", incrementers*increments, total)
+	}
+}
+
+func TestDECRDELDeletesAtZero(t *testing.T) {
+	sendCommand(t, "SET refcount 2")
+
+	for _, expected := range []int64{1, 0} {
+		var response IntegerResponse
+		decodeResponse(t, sendCommand(t, "DECRDEL refcount"), &response)
+		if response.Value != expected {
+			t.Errorf("Expected DECRDEL to return %d, but got %d", expected, response.Value)
+		}
+	}
+	if rr := sendCommand(t, "GET refcount"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected the key to be deleted at zero, but GET returned %d %s", rr.Code, rr.Body.String())
+	}
+
+	// A missing key is not recreated
+	var response IntegerResponse
+	decodeResponse(t, sendCommand(t, "DECRDEL refcount"), &response)
+	if response.Value != -1 {
+		t.Errorf("Expected -1 for a missing key, but got %d", response.Value)
+	}
+	if rr := sendCommand(t, "GET refcount"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected DECRDEL not to create the key, but GET returned %d", rr.Code)
+	}
+
+	sendCommand(t, "SET refcount-text abc")
+	if rr := sendCommand(t, "DECRDEL refcount-text"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected a non-integer value to be rejected, but got %d", rr.Code)
+	}
+}
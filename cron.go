@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minEveryInterval is the shortest interval "@every" accepts, so a job cannot
+// keep the scheduler busy.
+var minEveryInterval = time.Second
+
+var errInvalidSchedule = errors.New("invalid schedule; expected a cron expression such as \"*/30 * * * *\" or \"@every 30s\"")
+
+// cronSchedule tells the scheduler when a job runs next.
+type cronSchedule interface {
+	// next returns the first run time after t, or the zero time if there is none.
+	next(t time.Time) time.Time
+}
+
+// everySchedule runs a job at a fixed interval, for "@every duration".
+type everySchedule struct {
+	interval time.Duration
+}
+
+func (s everySchedule) next(t time.Time) time.Time {
+	return t.Add(s.interval)
+}
+
+// cronSpec is a standard five-field cron expression: minute, hour, day of
+// month, month and day of week. Each field is a bitset of the values it matches.
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool // The day fields were "*", which changes how they combine
+}
+
+// cronField describes the range of values one field of a cron expression accepts.
+type cronField struct {
+	min, max int
+}
+
+var cronFields = [5]cronField{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+// parseSchedule parses a five-field cron expression, where each field is "*",
+// a value, a range a-b, or a comma-separated list of them, each optionally
+// followed by /step, or "@every duration" for jobs that run more often than
+// once a minute, down to minEveryInterval.
+func parseSchedule(spec string) (cronSchedule, error) {
+	if strings.HasPrefix(spec, "@every ") {
+		interval, err := time.ParseDuration(strings.TrimPrefix(spec, "@every "))
+		if err != nil || interval < minEveryInterval {
+			return nil, errInvalidSchedule
+		}
+		return everySchedule{interval}, nil
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, errInvalidSchedule
+	}
+	var sets [5]uint64
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = set
+	}
+
+	// Sunday may be written as 0 or 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	s := &cronSpec{
+		minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		domStar: fields[2] == "*", dowStar: fields[4] == "*",
+	}
+	if s.next(time.Now()).IsZero() {
+		return nil, errInvalidSchedule // Such as February 30th
+	}
+	return s, nil
+}
+
+// parseCronField returns the bitset of values matched by one field.
+func parseCronField(field string, bounds cronField) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.IndexByte(item, '/'); i >= 0 {
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || n <= 0 {
+				return 0, errInvalidSchedule
+			}
+			step, item = n, item[:i]
+		}
+
+		low, high := bounds.min, bounds.max
+		if item != "*" {
+			ends := strings.SplitN(item, "-", 2)
+			var err error
+			if low, err = strconv.Atoi(ends[0]); err != nil {
+				return 0, errInvalidSchedule
+			}
+			high = low
+			if len(ends) == 2 {
+				if high, err = strconv.Atoi(ends[1]); err != nil {
+					return 0, errInvalidSchedule
+				}
+			} else if step > 1 {
+				high = bounds.max // "a/n" runs from a to the end of the range
+			}
+		}
+		if low < bounds.min || high > bounds.max || low > high {
+			return 0, errInvalidSchedule
+		}
+
+		for v := low; v <= high; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// cronSearchLimit bounds how far ahead next looks for a matching time.
+const cronSearchLimit = 5 * 366 * 24 * time.Hour
+
+// next returns the first whole minute after t that the expression matches,
+// in t's location. As in cron, when both day fields are restricted a day
+// matching either of them is enough.
+func (s *cronSpec) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(cronSearchLimit)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			// Built from the local fields, as zones such as UTC+5:30 do not start hours on UTC hours
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *cronSpec) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DebugObject describes the internals of a stored value. It is only exposed
+// through the DEBUG namespace and is not a stable API.
+type DebugObject struct {
+	Kind     string `json:"kind"`
+	Encoding string `json:"encoding"`
+	Length   int    `json:"length"`             // Length in bytes of the string value, or of the joined list elements
+	RefCount int    `json:"refcount"`           // Values are never shared between keys
+	ExpiryNs *int64 `json:"expiry_ns"`          // Raw expiry time in Unix nanoseconds, null without a TTL
+	Elements *int   `json:"elements,omitempty"` // Element count for lists, sets and hashes
+}
+
+// DebugObject returns the internal details of the value stored at key.
+func (store *KeyValueStore) DebugObject(key string) (DebugObject, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	kv, ok := store.lookup(key)
+	if !ok {
+		return DebugObject{}, errKeyNotFound
+	}
+
+	info := DebugObject{
+		Kind:     kv.Kind,
+		Encoding: kv.encoding(),
+		Length:   len(strings.Join(kv.Value, " ")),
+		RefCount: 1,
+	}
+	if kv.ExpiryTime != nil {
+		expiry := kv.ExpiryTime.UnixNano()
+		info.ExpiryNs = &expiry
+	}
+
+	switch kv.Kind {
+	case kindList:
+		count := len(kv.Value) + len(kv.Delayed)
+		if kv.Priority != nil {
+			count += kv.Priority.Len()
+		}
+		info.Elements = &count
+	case kindSet:
+		count := len(kv.Set)
+		info.Elements = &count
+	case kindHash:
+		count := len(kv.Hash)
+		info.Elements = &count
+	case kindTimeSeries:
+		count := len(kv.Series.timestamps)
+		info.Elements = &count
+	}
+
+	return info, nil
+}
+
+// DebugReload saves the keyspace to a temporary RDB file, clears it and loads
+// it back. Keys keep their queue state, pins, idle limits, etags and versions,
+// which travel in the file's rdbExtrasAux field. Writes made by other clients
+// while the reload runs are discarded.
+func (store *KeyValueStore) DebugReload() error {
+	file, err := os.CreateTemp("", "greedy-reload-*.rdb")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if err := store.WriteRDB(file); err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return store.LoadRDB(bufio.NewReader(file))
+}
+
+// VerifyProblem is a broken invariant found by DEBUG VERIFY.
+type VerifyProblem struct {
+	Key     string `json:"key"`
+	Problem string `json:"problem"`
+}
+
+// VerifyReport is the reply to DEBUG VERIFY.
+type VerifyReport struct {
+	Checked  int             `json:"checked"`
+	Problems []VerifyProblem `json:"problems"`
+	Repaired int             `json:"repaired"` // Keys dropped by DEBUG VERIFY REPAIR
+}
+
+// Expiry times outside these years can only come from a corrupt snapshot.
+const (
+	minExpiryYear = 1970
+	maxExpiryYear = 9999
+)
+
+// DebugVerify checks every key against the invariants the commands rely on,
+// such as a known type whose fields match it and a sane expiry time, so that a
+// snapshot loaded from an older or corrupt file can be checked before use.
+// With repair set, keys that break an invariant are dropped.
+func (store *KeyValueStore) DebugVerify(repair bool) VerifyReport {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	report := VerifyReport{Checked: len(store.Data), Problems: []VerifyProblem{}}
+	for key, kv := range store.Data {
+		problem := kv.verify()
+		if problem == "" {
+			continue
+		}
+		report.Problems = append(report.Problems, VerifyProblem{Key: key, Problem: problem})
+		if repair {
+			store.drop(key)
+			report.Repaired++
+		}
+	}
+	sort.Slice(report.Problems, func(i, j int) bool { return report.Problems[i].Key < report.Problems[j].Key })
+	return report
+}
+
+// verify returns the first invariant kv breaks, or "" if it is consistent.
+func (kv *KeyValue) verify() string {
+	if kv.ExpiryTime != nil {
+		if year := kv.ExpiryTime.Year(); year < minExpiryYear || year > maxExpiryYear {
+			return fmt.Sprintf("expiry time %s out of range", kv.ExpiryTime.UTC().Format(time.RFC3339))
+		}
+	}
+
+	if kv.Kind != kindList && (kv.Priority != nil || len(kv.Delayed) > 0) {
+		return fmt.Sprintf("%s holds queue values", kv.Kind)
+	}
+	if kv.Kind != kindSet && kv.Set != nil {
+		return fmt.Sprintf("%s holds set members", kv.Kind)
+	}
+	if kv.Kind != kindHash && kv.Hash != nil {
+		return fmt.Sprintf("%s holds hash fields", kv.Kind)
+	}
+	if kv.Kind != kindTimeSeries && kv.Series != nil {
+		return fmt.Sprintf("%s holds time series samples", kv.Kind)
+	}
+
+	switch kv.Kind {
+	case kindString:
+		if len(kv.Value) == 0 {
+			return "string without a value"
+		}
+	case kindList:
+		if kv.Priority != nil && len(kv.Value) > 0 {
+			return "priority queue holds plain values"
+		}
+		for i := 1; i < len(kv.Delayed); i++ {
+			if kv.Delayed[i].visibleAt.Before(kv.Delayed[i-1].visibleAt) {
+				return "delayed values out of order"
+			}
+		}
+	case kindSet:
+		// ENSURE creates empty sets, so only a missing member table is broken
+		if kv.Set == nil {
+			return "set without members"
+		}
+		if len(kv.Value) > 0 {
+			return "set holds list values"
+		}
+	case kindHash:
+		if kv.Hash == nil {
+			return "hash without fields"
+		}
+		if len(kv.Value) > 0 {
+			return "hash holds list values"
+		}
+	case kindTimeSeries:
+		if kv.Series == nil || len(kv.Series.timestamps) != len(kv.Series.values) {
+			return "time series without matching samples"
+		}
+		for i := 1; i < len(kv.Series.timestamps); i++ {
+			if kv.Series.timestamps[i] <= kv.Series.timestamps[i-1] {
+				return "time series samples out of order"
+			}
+		}
+	default:
+		return fmt.Sprintf("unknown type %q", kv.Kind)
+	}
+	return ""
+}
+
+// handleDEBUG handles the DEBUG family of diagnostic commands.
+func handleDEBUG(w http.ResponseWriter, parts []string) {
+	if len(parts) < 2 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	switch strings.ToUpper(parts[1]) {
+	case "OBJECT":
+		if len(parts) != 3 {
+			sendErrorResponse(w, "invalid command format")
+			return
+		}
+
+		info, err := store.DebugObject(parts[2])
+		if err != nil {
+			sendReadError(w, err)
+			return
+		}
+
+		sendObjectResponse(w, info)
+	case "RELOAD":
+		if len(parts) != 2 {
+			sendErrorResponse(w, "invalid command format")
+			return
+		}
+
+		if err := store.DebugReload(); err != nil {
+			sendStatusErrorResponse(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		sendOKResponse(w)
+	case "VERIFY":
+		repair := len(parts) == 3 && strings.EqualFold(parts[2], "REPAIR")
+		if len(parts) == 3 && !repair {
+			sendErrorResponse(w, unexpectedToken(parts, 2, "REPAIR"))
+			return
+		}
+
+		sendObjectResponse(w, store.DebugVerify(repair))
+	case "TIME", "SET-TIME", "ADVANCE-TIME":
+		handleDebugClock(w, parts)
+	default:
+		sendErrorResponse(w, "invalid command")
+	}
+}
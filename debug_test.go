@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDebugObjectLengthMatchesSTRLEN(t *testing.T) {
+	sendCommand(t, "SET debug-key some-value EX60")
+
+	var strlen IntegerResponse
+	decodeResponse(t, sendCommand(t, "STRLEN debug-key"), &strlen)
+
+	var debug struct {
+		Value DebugObject `json:"value"`
+	}
+	decodeResponse(t, sendCommand(t, "DEBUG OBJECT debug-key"), &debug)
+
+	if int64(debug.Value.Length) != strlen.Value {
+		t.Errorf("Expected DEBUG OBJECT length %d to match STRLEN %d", debug.Value.Length, strlen.Value)
+	}
+	if debug.Value.Encoding != "embstr" {
+		t.Errorf("Expected encoding embstr, but got %q", debug.Value.Encoding)
+	}
+	if debug.Value.ExpiryNs == nil {
+		t.Errorf("Expected the raw expiry to be reported for a key with a TTL")
+	}
+
+	sendCommand(t, "QPUSH debug-list a b c")
+	decodeResponse(t, sendCommand(t, "DEBUG OBJECT debug-list"), &debug)
+	if debug.Value.Elements == nil || *debug.Value.Elements != 3 {
+		t.Errorf("Expected 3 list elements, but got %v", debug.Value.Elements)
+	}
+}
+
+func TestDebugReloadPreservesKeys(t *testing.T) {
+	testStore := &KeyValueStore{Data: make(map[string]*KeyValue)}
+
+	expiry := time.Now().Add(time.Hour)
+	testStore.Set("string", "hello world", nil, "")
+	testStore.Set("expiring", "soon", &expiry, "")
+	testStore.QPush("list", []string{"a", "b", "c"})
+	testStore.SAdd("set", []string{"x", "y"})
+	testStore.HSet("hash", []string{"field", "value", "other", "1"})
+
+	before := make(map[string]*KeyValue)
+	for key, kv := range testStore.Data {
+		before[key] = kv
+	}
+
+	if err := testStore.DebugReload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(testStore.Data) != len(before) {
+		t.Errorf("Expected %d keys after the reload, but got %d", len(before), len(testStore.Data))
+	}
+	for key, old := range before {
+		kv, ok := testStore.Data[key]
+		if !ok {
+			t.Errorf("Expected %s to survive the reload", key)
+			continue
+		}
+		if kv == old {
+			t.Errorf("Expected %s to be reloaded rather than kept in memory", key)
+		}
+		if kv.Kind != old.Kind || !reflect.DeepEqual(kv.Value, old.Value) ||
+			!reflect.DeepEqual(kv.Set, old.Set) || !reflect.DeepEqual(kv.Hash, old.Hash) {
+			t.Errorf("Expected %s to round-trip as %+v, but got %+v", key, old, kv)
+		}
+		if (kv.ExpiryTime == nil) != (old.ExpiryTime == nil) ||
+			(old.ExpiryTime != nil && kv.ExpiryTime.UnixMilli() != old.ExpiryTime.UnixMilli()) {
+			t.Errorf("Expected %s to keep its expiry %v, but got %v", key, old.ExpiryTime, kv.ExpiryTime)
+		}
+	}
+}
+
+func TestDebugReloadPreservesQueueStateAndMetadata(t *testing.T) {
+	testStore := &KeyValueStore{Data: make(map[string]*KeyValue)}
+
+	testStore.QPushPriority("priority", []string{"low"}, 1)
+	testStore.QPushPriority("priority", []string{"high"}, 9)
+	testStore.QPushDelayed("delayed", "later", time.Hour)
+	testStore.QPush("closed", []string{"last"})
+	testStore.QClose("closed")
+	testStore.TSAdd("series", 1000, 1.5, time.Hour, 10)
+	testStore.SetIf("tagged", "v", "", "etag-1")
+	testStore.Set("pinned", "v", nil, "")
+	testStore.Pin("pinned", true)
+	testStore.Data["pinned"].MaxIdle = time.Minute
+
+	before := make(map[string]dumpedValue)
+	versions := make(map[string]uint64)
+	for key, kv := range testStore.Data {
+		before[key] = dumpValue(kv)
+		versions[key] = kv.version
+	}
+
+	if err := testStore.DebugReload(); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range before {
+		kv, ok := testStore.Data[key]
+		if !ok {
+			t.Errorf("Expected %s to survive the reload", key)
+			continue
+		}
+		if got := dumpValue(kv); !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %s to round-trip as %+v, but got %+v", key, want, got)
+		}
+		if kv.version != versions[key] {
+			t.Errorf("Expected %s to keep version %d, but got %d", key, versions[key], kv.version)
+		}
+	}
+	if kv := testStore.Data["tagged"]; kv.etag != "etag-1" {
+		t.Errorf("Expected the etag to survive the reload, but got %q", kv.etag)
+	}
+	if kv := testStore.Data["pinned"]; !kv.Pinned || kv.MaxIdle != time.Minute {
+		t.Errorf("Expected the pin and idle limit to survive the reload, but got %v and %v", kv.Pinned, kv.MaxIdle)
+	}
+}
+
+func TestDebugVerifyReportsCorruptSnapshotKeys(t *testing.T) {
+	// A snapshot written by a buggy serializer: an absurd expiry
+	corrupt := &KeyValueStore{Data: make(map[string]*KeyValue)}
+	corrupt.Set("good", "value", nil, "")
+	farFuture := time.Date(200000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	corrupt.Data["far-expiry"] = &KeyValue{Kind: kindString, Value: []string{"v"}, ExpiryTime: &farFuture}
+
+	var snapshot bytes.Buffer
+	if err := corrupt.WriteRDB(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	testStore := &KeyValueStore{Data: make(map[string]*KeyValue)}
+	if err := testStore.LoadRDB(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	// Loading rejects payloads that break an invariant, so plant one directly
+	later := time.Now().Add(time.Hour)
+	testStore.Data["unsorted-delays"] = &KeyValue{Kind: kindList, Delayed: []delayedItem{
+		{value: "b", visibleAt: later.Add(time.Minute)},
+		{value: "a", visibleAt: later},
+	}}
+
+	report := testStore.DebugVerify(false)
+	if report.Checked != 3 || len(report.Problems) != 2 {
+		t.Fatalf("Expected 2 problems among 3 keys, but got %+v", report)
+	}
+	if report.Problems[0].Key != "far-expiry" || report.Problems[1].Key != "unsorted-delays" {
+		t.Errorf("Expected far-expiry and unsorted-delays to be reported, but got %+v", report.Problems)
+	}
+	if len(testStore.Data) != 3 {
+		t.Errorf("Expected VERIFY without REPAIR to keep every key, but %d remain", len(testStore.Data))
+	}
+
+	report = testStore.DebugVerify(true)
+	if report.Repaired != 2 || len(testStore.Data) != 1 || testStore.Data["good"] == nil {
+		t.Errorf("Expected REPAIR to drop the 2 bad keys and keep good, but got %+v with %d keys", report, len(testStore.Data))
+	}
+}
+
+func TestDebugVerifyKeepsEnsuredEmptyValues(t *testing.T) {
+	testStore := &KeyValueStore{Data: make(map[string]*KeyValue)}
+	testStore.Ensure("ensured-set", kindSet)
+	testStore.Ensure("ensured-hash", kindHash)
+
+	report := testStore.DebugVerify(true)
+	if len(report.Problems) != 0 || report.Repaired != 0 {
+		t.Errorf("Expected empty sets and hashes from ENSURE to pass, but got %+v", report)
+	}
+	if len(testStore.Data) != 2 {
+		t.Errorf("Expected REPAIR to keep both ensured keys, but %d remain", len(testStore.Data))
+	}
+}
+
+func TestDebugReloadKeepsVersionsIncreasing(t *testing.T) {
+	source := &KeyValueStore{Data: make(map[string]*KeyValue)}
+	for i := 0; i < 5; i++ {
+		source.Set("versioned", "v"+strconv.Itoa(i), nil, "")
+	}
+	loaded := source.Data["versioned"].version
+
+	var snapshot bytes.Buffer
+	if err := source.WriteRDB(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	// A fresh server has handed out no versions yet
+	testStore := &KeyValueStore{Data: make(map[string]*KeyValue)}
+	if err := testStore.LoadRDB(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if got := testStore.Data["versioned"].version; got != loaded {
+		t.Fatalf("Expected the loaded version %d, but got %d", loaded, got)
+	}
+
+	testStore.Set("other", "v", nil, "")
+	testStore.Set("versioned", "next", nil, "")
+	for key, kv := range testStore.Data {
+		if kv.version <= loaded {
+			t.Errorf("Expected %s to get a version above the loaded %d, but got %d", key, loaded, kv.version)
+		}
+	}
+}
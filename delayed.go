@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// delayedItem is a queued value that stays invisible to consumers until visibleAt.
+type delayedItem struct {
+	value     string
+	visibleAt time.Time
+}
+
+// promoteDelayed moves every delayed value that has become visible onto the queue,
+// in the order they became visible. The caller must hold the store write lock.
+func (kv *KeyValue) promoteDelayed(now time.Time) {
+	due := 0
+	for due < len(kv.Delayed) && !kv.Delayed[due].visibleAt.After(now) {
+		due++
+	}
+	if due == 0 {
+		return
+	}
+
+	for _, item := range kv.Delayed[:due] {
+		if kv.Priority != nil {
+			kv.Priority.push(item.value, 0)
+		} else {
+			kv.Value = append(kv.Value, item.value)
+		}
+	}
+	kv.Delayed = kv.Delayed[due:]
+}
+
+// QPushDelayed schedules value to be pushed onto the queue at key once delay has elapsed.
+func (store *KeyValueStore) QPushDelayed(key, value string, delay time.Duration) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	kv, ok := store.lookup(key)
+	if ok && kv.closed {
+		return errQueueClosed
+	}
+	if !ok {
+		kv = &KeyValue{Kind: kindList}
+		store.insert(key, kv)
+	}
+
+	item := delayedItem{value: value, visibleAt: clock.Now().Add(delay)}
+
+	// Keep the delayed items sorted by visibility time so promotion only scans the due prefix
+	i := sort.Search(len(kv.Delayed), func(i int) bool {
+		return kv.Delayed[i].visibleAt.After(item.visibleAt)
+	})
+	kv.Delayed = append(kv.Delayed, delayedItem{})
+	copy(kv.Delayed[i+1:], kv.Delayed[i:])
+	kv.Delayed[i] = item
+	store.recordQueue(key, 1, 0)
+	return nil
+}
+
+// QLen returns the number of values currently visible in the queue at key.
+func (store *KeyValueStore) QLen(key string) int {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	kv, ok := store.lookup(key)
+	if !ok {
+		return 0
+	}
+
+	kv.promoteDelayed(clock.Now())
+	return kv.queueLen()
+}
+
+// handleQPUSHDELAYED handles QPUSHDELAYED key value delaySeconds.
+func handleQPUSHDELAYED(w http.ResponseWriter, parts []string) {
+	if len(parts) != 4 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	seconds, err := strconv.Atoi(parts[3])
+	if err != nil || seconds < 0 {
+		sendErrorResponse(w, "invalid delay")
+		return
+	}
+
+	if err := store.QPushDelayed(parts[1], parts[2], time.Duration(seconds)*time.Second); err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendOKResponse(w)
+}
+
+// handleQLEN returns the number of visible values in a queue.
+func handleQLEN(w http.ResponseWriter, parts []string) {
+	if len(parts) != 2 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	sendIntegerResponse(w, int64(store.QLen(parts[1])))
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestQPUSHDELAYED(t *testing.T) {
+	if rr := sendCommand(t, "QPUSHDELAYED deferred job-1 1"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+
+	// The value is invisible until the delay elapses
+	if rr := sendCommand(t, "QPOP deferred"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d before the delay, but got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	var length IntegerResponse
+	decodeResponse(t, sendCommand(t, "QLEN deferred"), &length)
+	if length.Value != 0 {
+		t.Errorf("Expected QLEN 0 before the delay, but got %d", length.Value)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+
+	rr := sendCommand(t, "QPOP deferred")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d after the delay, but got %d", http.StatusOK, rr.Code)
+	}
+
+	var response ValueResponse
+	decodeResponse(t, rr, &response)
+	if response.Value != "job-1" {
+		t.Errorf("Expected job-1, but got %q", response.Value)
+	}
+}
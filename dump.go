@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dumpVersion is written at the start of every DUMP payload so the format can evolve.
+const dumpVersion = 1
+
+// migrateDefaultTimeout is how long MIGRATE waits for the target when given a
+// timeout of 0, as Redis does, rather than waiting forever.
+var migrateDefaultTimeout = time.Second
+
+var (
+	errBusyKey        = errors.New("target key name is busy")
+	errInvalidPayload = errors.New("DUMP payload version or checksum are wrong")
+	errInvalidValue   = errors.New("DUMP payload holds an invalid value")
+)
+
+// dumpedValue is the serialized form of a KeyValue, without its expiry.
+type dumpedValue struct {
+	Kind     string            `json:"kind"`
+	Value    []string          `json:"value,omitempty"`
+	Set      []string          `json:"set,omitempty"`
+	Hash     map[string]string `json:"hash,omitempty"`
+	Priority []dumpedItem      `json:"priority,omitempty"`
+	Delayed  []dumpedDelay     `json:"delayed,omitempty"`
+	Series   *dumpedSeries     `json:"series,omitempty"`
+	Closed   bool              `json:"closed,omitempty"` // Set for a queue closed with QCLOSE
+}
+
+type dumpedItem struct {
+	Value    string `json:"value"`
+	Priority int    `json:"priority"`
+}
+
+type dumpedSeries struct {
+	Timestamps []int64   `json:"timestamps"`
+	Values     []float64 `json:"values"`
+	Retention  int64     `json:"retention,omitempty"` // Milliseconds
+	MaxSamples int       `json:"max_samples,omitempty"`
+}
+
+type dumpedDelay struct {
+	Value     string `json:"value"`
+	VisibleAt int64  `json:"visible_at"` // Unix nanoseconds
+}
+
+// dumpValue converts kv to its serializable form.
+func dumpValue(kv *KeyValue) dumpedValue {
+	dumped := dumpedValue{
+		Kind:   kv.Kind,
+		Value:  kv.Value,
+		Closed: kv.closed,
+	}
+	if kv.Set != nil {
+		dumped.Set = sortedMembers(kv.Set)
+	}
+	if kv.Hash != nil {
+		dumped.Hash = kv.Hash
+	}
+	if kv.Priority != nil {
+		// Copy the heap and pop it so the items are stored in pop order
+		pq := &priorityQueue{items: append([]priorityItem(nil), kv.Priority.items...)}
+		for pq.Len() > 0 {
+			item := pq.items[0]
+			pq.pop()
+			dumped.Priority = append(dumped.Priority, dumpedItem{Value: item.value, Priority: item.priority})
+		}
+	}
+	for _, item := range kv.Delayed {
+		dumped.Delayed = append(dumped.Delayed, dumpedDelay{Value: item.value, VisibleAt: item.visibleAt.UnixNano()})
+	}
+	if kv.Series != nil {
+		dumped.Series = &dumpedSeries{
+			Timestamps: kv.Series.timestamps,
+			Values:     kv.Series.values,
+			Retention:  kv.Series.retention.Milliseconds(),
+			MaxSamples: kv.Series.maxSamples,
+		}
+	}
+	return dumped
+}
+
+// restoreValue rebuilds a KeyValue from its serialized form.
+func restoreValue(dumped dumpedValue) *KeyValue {
+	kv := &KeyValue{
+		Kind:   dumped.Kind,
+		Value:  dumped.Value,
+		closed: dumped.Closed,
+	}
+	if dumped.Kind == kindSet {
+		kv.Set = make(map[string]struct{}, len(dumped.Set))
+		for _, member := range dumped.Set {
+			kv.Set[member] = struct{}{}
+		}
+	}
+	if dumped.Kind == kindHash {
+		kv.Hash = make(map[string]string, len(dumped.Hash))
+		for field, value := range dumped.Hash {
+			kv.Hash[field] = value
+		}
+	}
+	if dumped.Priority != nil {
+		kv.Priority = &priorityQueue{}
+		for _, item := range dumped.Priority {
+			kv.Priority.push(item.Value, item.Priority)
+		}
+	}
+	for _, item := range dumped.Delayed {
+		kv.Delayed = append(kv.Delayed, delayedItem{value: item.Value, visibleAt: time.Unix(0, item.VisibleAt)})
+	}
+	if dumped.Kind == kindTimeSeries {
+		kv.Series = &timeSeries{}
+		if series := dumped.Series; series != nil {
+			kv.Series.timestamps = append([]int64(nil), series.Timestamps...)
+			kv.Series.values = append([]float64(nil), series.Values...)
+			kv.Series.retention = time.Duration(series.Retention) * time.Millisecond
+			kv.Series.maxSamples = series.MaxSamples
+		}
+	}
+	return kv
+}
+
+// encodeDump serializes kv as a base64 payload: a version byte, the JSON body and a CRC32 of both.
+func encodeDump(kv *KeyValue) (string, error) {
+	body, err := json.Marshal(dumpValue(kv))
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(dumpVersion)
+	buf.Write(body)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(buf.Bytes()))
+
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// decodeDump validates and deserializes a payload produced by encodeDump,
+// rejecting values that break the invariants DEBUG VERIFY checks.
+func decodeDump(payload string) (*KeyValue, error) {
+	raw, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil || len(raw) < 5 || raw[0] != dumpVersion {
+		return nil, errInvalidPayload
+	}
+
+	data, checksum := raw[:len(raw)-4], binary.BigEndian.Uint32(raw[len(raw)-4:])
+	if crc32.ChecksumIEEE(data) != checksum {
+		return nil, errInvalidPayload
+	}
+
+	var dumped dumpedValue
+	if err := json.Unmarshal(data[1:], &dumped); err != nil {
+		return nil, errInvalidPayload
+	}
+
+	// The checksum only proves the payload is intact, not that it came from
+	// DUMP, so check the value's shape before it can reach the store
+	kv := restoreValue(dumped)
+	if problem := kv.verify(); problem != "" {
+		return nil, fmt.Errorf("%w: %s", errInvalidValue, problem)
+	}
+	return kv, nil
+}
+
+// remainingTTL returns the time left before kv expires, or 0 if it has no expiry.
+func (kv *KeyValue) remainingTTL(now time.Time) time.Duration {
+	if kv.ExpiryTime == nil {
+		return 0
+	}
+	return kv.ExpiryTime.Sub(now)
+}
+
+// Dump serializes the value stored at key along with its remaining TTL.
+func (store *KeyValueStore) Dump(key string) (string, time.Duration, error) {
+	payload, ttl, _, err := store.dump(key)
+	return payload, ttl, err
+}
+
+// dump is Dump that also returns the dumped value, so a caller can tell later
+// whether the key still holds what was serialized.
+func (store *KeyValueStore) dump(key string) (string, time.Duration, *KeyValue, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	kv, ok := store.lookup(key)
+	if !ok {
+		return "", 0, nil, errKeyNotFound
+	}
+
+	payload, err := encodeDump(kv)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	return payload, kv.remainingTTL(clock.Now()), kv, nil
+}
+
+// Restore creates key from a DUMP payload. A ttl of 0 means no expiry.
+// An existing key is only overwritten when replace is set.
+func (store *KeyValueStore) Restore(key string, ttl time.Duration, payload string, replace bool) error {
+	kv, err := decodeDump(payload)
+	if err != nil {
+		return err
+	}
+	if ttl > 0 {
+		expiryTime := clock.Now().Add(ttl)
+		kv.ExpiryTime = &expiryTime
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, ok := store.lookup(key); ok && !replace {
+		return errBusyKey
+	}
+	store.insert(key, kv)
+	return nil
+}
+
+// Migrate moves key to the server at addr by sending it a RESTORE of its DUMP
+// payload, waiting up to timeout for a reply, or migrateDefaultTimeout for 0.
+// The local key is deleted once the target accepts it, unless copy is set or
+// the key was written while the request was in flight, in which case the new
+// value is kept.
+func (store *KeyValueStore) Migrate(addr, key string, timeout time.Duration, copy, replace bool) error {
+	payload, ttl, dumped, err := store.dump(key)
+	if err != nil {
+		return err
+	}
+	version := dumped.version
+
+	// A key that expired while being dumped is reported as missing rather than restored without a TTL
+	if ttl < 0 {
+		return errKeyNotFound
+	}
+
+	// Round up, as a TTL under a millisecond sent as 0 would make the key persistent on the target
+	ms := int64((ttl + time.Millisecond - 1) / time.Millisecond)
+	command := fmt.Sprintf("RESTORE %s %d %s", key, ms, payload)
+	if replace {
+		command += " REPLACE"
+	}
+	body, err := json.Marshal(Command{Command: command})
+	if err != nil {
+		return err
+	}
+
+	if timeout <= 0 {
+		timeout = migrateDefaultTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Post("http://"+addr+"/", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("migrate: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errorResponse ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&errorResponse)
+		return fmt.Errorf("migrate: target returned %s", errorResponse.Error)
+	}
+
+	if !copy {
+		store.mutex.Lock()
+		defer store.mutex.Unlock()
+		kv, ok := store.lookup(key)
+		if !ok || kv != dumped || kv.version != version {
+			return nil
+		}
+		// Not every write bumps the version, so compare the contents as well
+		if current, err := encodeDump(kv); err == nil && current == payload {
+			store.drop(key)
+		}
+	}
+	return nil
+}
+
+// handleDUMP handles DUMP key.
+func handleDUMP(w http.ResponseWriter, parts []string) {
+	if len(parts) != 2 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	payload, _, err := store.Dump(parts[1])
+	if err != nil {
+		sendReadError(w, err)
+		return
+	}
+
+	sendValueResponse(w, payload)
+}
+
+// handleRESTORE handles RESTORE key ttl-milliseconds payload [REPLACE].
+func handleRESTORE(w http.ResponseWriter, parts []string) {
+	if len(parts) != 4 && len(parts) != 5 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	ttl, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || ttl < 0 {
+		sendErrorResponse(w, "invalid TTL value")
+		return
+	}
+
+	replace := false
+	if len(parts) == 5 {
+		if strings.ToUpper(parts[4]) != "REPLACE" {
+			sendErrorResponse(w, "invalid command format")
+			return
+		}
+		replace = true
+	}
+
+	if err := store.Restore(parts[1], time.Duration(ttl)*time.Millisecond, parts[3], replace); err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendOKResponse(w)
+}
+
+// handleMIGRATE handles MIGRATE host port key destdb timeout-milliseconds [COPY] [REPLACE].
+// Only database 0 exists, so destdb must be 0.
+func handleMIGRATE(w http.ResponseWriter, parts []string) {
+	if len(parts) < 6 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	if parts[4] != "0" {
+		sendErrorResponse(w, "invalid database")
+		return
+	}
+
+	timeout, err := strconv.Atoi(parts[5])
+	if err != nil || timeout < 0 {
+		sendErrorResponse(w, "invalid timeout")
+		return
+	}
+
+	var copy, replace bool
+	for _, option := range parts[6:] {
+		switch strings.ToUpper(option) {
+		case "COPY":
+			copy = true
+		case "REPLACE":
+			replace = true
+		default:
+			sendErrorResponse(w, "invalid command format")
+			return
+		}
+	}
+
+	addr := net.JoinHostPort(parts[1], parts[2])
+	if err := store.Migrate(addr, parts[3], time.Duration(timeout)*time.Millisecond, copy, replace); err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendOKResponse(w)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDumpRestoreRoundTrip(t *testing.T) {
+	sendCommand(t, "QPUSH dump-queue a b c")
+
+	var payload ValueResponse
+	decodeResponse(t, sendCommand(t, "DUMP dump-queue"), &payload)
+
+	if rr := sendCommand(t, "RESTORE dump-copy 0 "+payload.Value); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+
+	// Restoring onto an existing key requires REPLACE
+	if rr := sendCommand(t, "RESTORE dump-copy 0 "+payload.Value); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for a busy key, but got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	// A corrupted payload fails the checksum
+	corrupted := []byte(payload.Value)
+	corrupted[5] ^= 1
+	if rr := sendCommand(t, "RESTORE dump-corrupt 0 "+string(corrupted)); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for a corrupted payload, but got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	var values ListResponse
+	decodeResponse(t, sendCommand(t, "LRANGE dump-copy 0 -1"), &values)
+	if strings.Join(values.Value, ",") != "a,b,c" {
+		t.Errorf("Expected restored queue a,b,c, but got %q", values.Value)
+	}
+}
+
+func TestRESTORERejectsInvalidValues(t *testing.T) {
+	queued := &priorityQueue{}
+	queued.push("job", 1)
+
+	cases := map[string]*KeyValue{
+		"unknown kind":      {Kind: "bogus", Value: []string{"v"}},
+		"uneven series":     {Kind: kindTimeSeries, Series: &timeSeries{timestamps: []int64{1, 2}, values: []float64{1}}},
+		"set with values":   {Kind: kindSet, Set: map[string]struct{}{"a": {}}, Value: []string{"x"}},
+		"string with queue": {Kind: kindString, Value: []string{"v"}, Priority: queued},
+	}
+	for name, kv := range cases {
+		payload, err := encodeDump(kv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := sendCommand(t, "RESTORE restore-invalid 0 "+payload)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status code %d, but got %d", name, http.StatusBadRequest, rr.Code)
+		}
+	}
+	if rr := sendCommand(t, "GET restore-invalid"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected no invalid value to be stored, but GET returned %d", rr.Code)
+	}
+
+	// The snapshot, which would have panicked on the uneven series, still works
+	var snapshot bytes.Buffer
+	if err := store.WriteRDB(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// migrateTarget starts a server running the real command handler. Its
+// requests land in namespace, so the target's keys sit apart from the source's
+// in the shared store.
+func migrateTarget(t *testing.T, namespace string) (host, port string) {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set(namespaceHeader, namespace)
+		handleRequest(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return host, port
+}
+
+func TestMIGRATE(t *testing.T) {
+	host, port := migrateTarget(t, "migrate-target")
+
+	sendCommand(t, "SET migrate-me hello EX100")
+
+	rr := sendCommand(t, "MIGRATE "+host+" "+port+" migrate-me 0 1000")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	// Gone from the source, present on the target with its TTL preserved
+	if rr := sendCommand(t, "GET migrate-me"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected the key to be removed from the source, but GET returned %d", rr.Code)
+	}
+
+	var value ValueResponse
+	decodeResponse(t, sendNamespacedCommand(t, "migrate-target", "GET migrate-me"), &value)
+	if value.Value != "hello" {
+		t.Errorf("Expected value hello on the target, but got %q", value.Value)
+	}
+	store.mutex.RLock()
+	kv, ok := store.Data["migrate-target:migrate-me"]
+	store.mutex.RUnlock()
+	if !ok || kv.ExpiryTime == nil || time.Until(*kv.ExpiryTime) < 90*time.Second {
+		t.Errorf("Expected the TTL to be preserved on the target")
+	}
+
+	// Migrating onto an existing key fails without REPLACE and keeps the source
+	sendCommand(t, "SET migrate-me again")
+	if rr := sendCommand(t, "MIGRATE "+host+" "+port+" migrate-me 0 1000"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for a busy target key, but got %d", http.StatusBadRequest, rr.Code)
+	}
+	if rr := sendCommand(t, "GET migrate-me"); rr.Code != http.StatusOK {
+		t.Errorf("Expected the source key to survive a failed migration, but GET returned %d", rr.Code)
+	}
+}
+
+func TestMIGRATEKeepsAKeyWrittenInFlight(t *testing.T) {
+	host, port := migrateTarget(t, "migrate-inflight")
+	addr := net.JoinHostPort(host, port)
+
+	// The target's reply is held until the source key has been pushed to
+	var once sync.Once
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { sendCommand(t, "QPUSH migrate-busy late") })
+		resp, err := http.Post("http://"+addr+"/", "application/json", r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+	defer proxy.Close()
+	host, port, _ = net.SplitHostPort(strings.TrimPrefix(proxy.URL, "http://"))
+
+	sendCommand(t, "QPUSH migrate-busy early")
+	if rr := sendCommand(t, "MIGRATE "+host+" "+port+" migrate-busy 0 1000"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var values ListResponse
+	decodeResponse(t, sendCommand(t, "LRANGE migrate-busy 0 -1"), &values)
+	if strings.Join(values.Value, ",") != "early,late" {
+		t.Errorf("Expected the source to keep early,late, but got %q", values.Value)
+	}
+}
+
+func TestMIGRATERoundsUpASubMillisecondTTL(t *testing.T) {
+	host, port := migrateTarget(t, "migrate-short")
+	fake := useFakeClock(t)
+
+	expiry := fake.Now().Add(500 * time.Microsecond)
+	store.Set("migrate-short", "hello", &expiry, "")
+
+	if rr := sendCommand(t, "MIGRATE "+host+" "+port+" migrate-short 0 1000"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	store.mutex.RLock()
+	kv, ok := store.Data["migrate-short:migrate-short"]
+	store.mutex.RUnlock()
+	if !ok || kv.ExpiryTime == nil {
+		t.Errorf("Expected the key to keep an expiry on the target")
+	}
+}
+
+func TestMIGRATEWithZeroTimeoutUsesTheDefault(t *testing.T) {
+	defer func(timeout time.Duration) { migrateDefaultTimeout = timeout }(migrateDefaultTimeout)
+	migrateDefaultTimeout = 20 * time.Millisecond
+
+	// A target that never answers
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+
+	sendCommand(t, "SET migrate-stuck v")
+	start := time.Now()
+	if rr := sendCommand(t, "MIGRATE "+host+" "+port+" migrate-stuck 0 0"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected the migration to time out, but got %d: %s", rr.Code, rr.Body.String())
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected a timeout of 0 to use the default, but MIGRATE took %v", elapsed)
+	}
+	if rr := sendCommand(t, "GET migrate-stuck"); rr.Code != http.StatusOK {
+		t.Errorf("Expected the source key to survive a timed-out migration, but GET returned %d", rr.Code)
+	}
+}
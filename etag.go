@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+var errEtagConflict = errors.New("etag conflict")
+
+// SetIf stores value at key with the etag newEtag only if the key's current
+// etag is expected, and returns newEtag. An empty expected matches a missing
+// key, or one last written without an etag, such as by SET or INCR. Like SetVer, the key keeps its TTL.
+func (store *KeyValueStore) SetIf(key, value, expected, newEtag string) (string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	kv, ok := store.lookup(key)
+	if !ok {
+		if expected != "" {
+			return "", errEtagConflict
+		}
+		kv = &KeyValue{Kind: kindString, Value: []string{value}}
+		store.insert(key, kv)
+		kv.etag = newEtag
+		return newEtag, nil
+	}
+
+	if kv.Kind != kindString {
+		return "", errWrongType
+	}
+	if kv.etag != expected {
+		return "", errEtagConflict
+	}
+
+	kv.Value = []string{value}
+	store.stamp(key, kv)
+	kv.etag = newEtag
+	return newEtag, nil
+}
+
+// handleSETIF handles SETIF key value expected-etag new-etag, returning the new etag.
+// An empty expected-etag, given as two spaces in a row, creates the key.
+func handleSETIF(w http.ResponseWriter, parts []string) {
+	if len(parts) != 5 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+	if parts[4] == "" {
+		sendErrorResponse(w, "invalid etag")
+		return
+	}
+
+	etag, err := store.SetIf(parts[1], parts[2], parts[3], parts[4])
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendValueResponse(w, etag)
+}
@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestSETIFRejectsMismatchedEtag(t *testing.T) {
+	var etag ValueResponse
+	decodeResponse(t, sendCommand(t, "SETIF artifact build-1  e1"), &etag)
+	if etag.Value != "e1" {
+		t.Fatalf("Expected SETIF to create the key with etag e1, but got %q", etag.Value)
+	}
+
+	decodeResponse(t, sendCommand(t, "SETIF artifact build-2 e1 e2"), &etag)
+	if etag.Value != "e2" {
+		t.Errorf("Expected the new etag e2, but got %q", etag.Value)
+	}
+
+	// A writer holding the old etag must not overwrite build-2
+	var response ErrorResponse
+	decodeResponse(t, sendCommand(t, "SETIF artifact build-3 e1 e3"), &response)
+	if response.Error != errEtagConflict.Error() {
+		t.Errorf("Expected %q, but got %q", errEtagConflict, response.Error)
+	}
+	var value ValueResponse
+	decodeResponse(t, sendCommand(t, "GET artifact"), &value)
+	if value.Value != "build-2" {
+		t.Errorf("Expected the value to stay build-2 after a conflict, but got %q", value.Value)
+	}
+
+	// A plain SET clears the etag
+	sendCommand(t, "SET artifact build-4")
+	decodeResponse(t, sendCommand(t, "SETIF artifact build-5 e2 e5"), &response)
+	if response.Error != errEtagConflict.Error() {
+		t.Errorf("Expected SET to invalidate the etag, but got %q", response.Error)
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+var errEvictionDisabled = errors.New("eviction is disabled; start the server with -maxmemory")
+var errOOM = errors.New("OOM command not allowed when used memory > maxmemory")
+
+// memoryPressureWarning is added to write replies once usage passes -maxmemory-warn.
+const memoryPressureWarning = "memory pressure"
+
+// Rough per-entry and per-element overheads used to estimate memory usage.
+const (
+	entryOverhead   = 64
+	elementOverhead = 16
+)
+
+// touch records an access to kv for the LRU eviction policy. It only uses an
+// atomic store so that readers holding the read lock can call it.
+func (kv *KeyValue) touch(now time.Time) {
+	atomic.StoreInt64(&kv.lastAccess, now.UnixNano())
+}
+
+// lastAccessed returns the time kv was last read or written.
+func (kv *KeyValue) lastAccessed() int64 {
+	return atomic.LoadInt64(&kv.lastAccess)
+}
+
+// isIdle reports whether kv has gone unaccessed for longer than its idle
+// window: its own MaxIdle, or else the store-wide maxIdle.
+func (store *KeyValueStore) isIdle(kv *KeyValue, now time.Time) bool {
+	window := kv.MaxIdle
+	if window == 0 {
+		window = store.maxIdle
+	}
+	return window > 0 && now.UnixNano()-kv.lastAccessed() > int64(window)
+}
+
+// entrySize estimates the memory used by key and its value in bytes.
+func entrySize(key string, kv *KeyValue) int64 {
+	size := int64(entryOverhead + len(key))
+	for _, value := range kv.Value {
+		size += int64(elementOverhead + len(value))
+	}
+	for member := range kv.Set {
+		size += int64(elementOverhead + len(member))
+	}
+	for field, value := range kv.Hash {
+		size += int64(2*elementOverhead + len(field) + len(value))
+	}
+	if kv.Priority != nil {
+		for _, item := range kv.Priority.items {
+			size += int64(2*elementOverhead + len(item.value))
+		}
+	}
+	for _, item := range kv.Delayed {
+		size += int64(2*elementOverhead + len(item.value))
+	}
+	if kv.Series != nil {
+		size += int64(16 * len(kv.Series.timestamps)) // An int64 timestamp and a float64 value per sample
+	}
+	return size
+}
+
+// usedMemory estimates the memory used by all keys. The caller must hold the store mutex.
+func (store *KeyValueStore) usedMemory() int64 {
+	var used int64
+	for key, kv := range store.Data {
+		used += entrySize(key, kv)
+	}
+	return used
+}
+
+// markWritten notes that key may have been created, resized or deleted, so
+// that its size is accounted by the next evictIfNeeded. It is only tracked
+// while a memory limit is set. The caller must hold the store write lock.
+func (store *KeyValueStore) markWritten(key string) {
+	if store.maxMemory <= 0 {
+		return
+	}
+	if store.unaccounted == nil {
+		store.unaccounted = make(map[string]struct{})
+	}
+	store.unaccounted[key] = struct{}{}
+}
+
+// account brings the memory usage up to date with the keys written since it
+// last ran, measuring only those keys, and returns it. The caller must hold
+// the store write lock.
+func (store *KeyValueStore) account() int64 {
+	if store.entrySizes == nil {
+		store.entrySizes = make(map[string]int64)
+	}
+	used := atomic.LoadInt64(&store.usedBytes)
+	for key := range store.unaccounted {
+		used -= store.entrySizes[key]
+		if kv, ok := store.Data[key]; ok {
+			size := entrySize(key, kv)
+			store.entrySizes[key] = size
+			used += size
+		} else {
+			delete(store.entrySizes, key)
+		}
+	}
+	store.unaccounted = nil
+	atomic.StoreInt64(&store.usedBytes, used)
+	return used
+}
+
+// recount measures every key again, correcting any growth that was not
+// marked as written, and returns the memory usage. The caller must hold the
+// store write lock.
+func (store *KeyValueStore) recount() int64 {
+	store.entrySizes = make(map[string]int64, len(store.Data))
+	store.unaccounted = nil
+	var used int64
+	for key, kv := range store.Data {
+		size := entrySize(key, kv)
+		store.entrySizes[key] = size
+		used += size
+	}
+	atomic.StoreInt64(&store.usedBytes, used)
+	return used
+}
+
+// eviction returns the store's eviction policy, defaulting to LRU.
+func (store *KeyValueStore) eviction() EvictionPolicy {
+	store.policyOnce.Do(func() {
+		if store.evictionPolicy == nil {
+			store.evictionPolicy = newLRUPolicy()
+		}
+	})
+	return store.evictionPolicy
+}
+
+// recordAccess reports an access to key to the eviction policy. Accesses are
+// only tracked while a memory limit is set.
+func (store *KeyValueStore) recordAccess(key string) {
+	if store.maxMemory > 0 {
+		store.eviction().RecordAccess(key)
+	}
+}
+
+// recordInsert reports a write of key to the eviction policy, unless the key is pinned.
+func (store *KeyValueStore) recordInsert(key string, kv *KeyValue) {
+	if store.maxMemory > 0 && !kv.Pinned {
+		store.eviction().RecordInsert(key, int(entrySize(key, kv)))
+	}
+}
+
+// drop deletes key from the store and from the eviction policy, and wakes any
+// WATCHGET on key. The caller must hold the store write lock.
+func (store *KeyValueStore) drop(key string) {
+	delete(store.Data, key)
+	store.markWritten(key)
+	store.notifyWatchers(key)
+	if store.maxMemory > 0 {
+		store.eviction().Remove(key)
+	}
+}
+
+// evictIfNeeded evicts the keys chosen by the eviction policy until the estimated
+// memory usage is within maxMemory. Pinned keys are never evicted. A maxMemory of 0 disables eviction.
+// The usage is kept up to date by measuring only the keys written since the
+// last call, including the given keys; the whole keyspace is only measured
+// again when it appears to be over the limit, before evicting.
+func (store *KeyValueStore) evictIfNeeded(written ...string) {
+	if store.maxMemory <= 0 {
+		return
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, key := range written {
+		store.markWritten(key)
+	}
+	if store.account() <= store.maxMemory {
+		return
+	}
+	if used := store.recount(); used > store.maxMemory {
+		store.evict(used - store.maxMemory)
+		store.account()
+	}
+}
+
+// checkMemory reports how a command that may grow memory should be treated
+// given the memory usage measured after the previous command: errOOM past
+// maxMemory when the policy cannot evict, or memoryPressureWarning past the
+// memoryWarn percentage of it.
+func (store *KeyValueStore) checkMemory() (string, error) {
+	if store.maxMemory <= 0 {
+		return "", nil
+	}
+
+	used := atomic.LoadInt64(&store.usedBytes)
+	if _, ok := store.eviction().(noEvictionPolicy); ok && used > store.maxMemory {
+		return "", errOOM
+	}
+	if store.memoryWarn > 0 && used*100 > store.maxMemory*int64(store.memoryWarn) {
+		return memoryPressureWarning, nil
+	}
+	return "", nil
+}
+
+// admitWrite applies memory backpressure to the command name before it runs,
+// replying with errOOM and returning false if it must be rejected. Commands
+// that cannot grow memory, such as reads and deletes, are always admitted.
+func admitWrite(w http.ResponseWriter, name string) bool {
+	if !denyOOMCommands[name] {
+		return true
+	}
+
+	warning, err := store.checkMemory()
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return false
+	}
+	if fw, ok := w.(*formatWriter); ok {
+		fw.warning = warning
+	}
+	return true
+}
+
+// evict removes the keys chosen by the eviction policy until at least bytes
+// have been freed or the policy runs out of keys, and returns the evicted keys
+// in eviction order with the bytes freed. Pinned keys are never evicted.
+// The caller must hold the store write lock.
+func (store *KeyValueStore) evict(bytes int64) ([]string, int64) {
+	evicted := []string{}
+	var freed int64
+	for freed < bytes {
+		// Sizes recorded by the policy can be stale, so ask again until enough is freed
+		victims := store.eviction().Evict(int(bytes - freed))
+		if len(victims) == 0 {
+			break
+		}
+		for _, key := range victims {
+			if freed >= bytes {
+				break
+			}
+			kv, ok := store.Data[key]
+			if ok && kv.Pinned {
+				store.eviction().Remove(key)
+				continue
+			}
+			if ok {
+				freed += entrySize(key, kv)
+				evicted = append(evicted, key)
+			}
+			store.drop(key)
+		}
+	}
+	return evicted, freed
+}
+
+// EvictResult is the reply to EVICT.
+type EvictResult struct {
+	Keys  []string `json:"keys"`
+	Freed int64    `json:"freed"`
+}
+
+// Evict runs the eviction policy to free at least bytes now, regardless of
+// how much memory is in use. The policy only tracks keys while a memory limit is set.
+func (store *KeyValueStore) Evict(bytes int64) (EvictResult, error) {
+	if store.maxMemory <= 0 {
+		return EvictResult{}, errEvictionDisabled
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	keys, freed := store.evict(bytes)
+	return EvictResult{Keys: keys, Freed: freed}, nil
+}
+
+// handleEVICT handles EVICT bytes, replying with the evicted keys and the bytes freed.
+func handleEVICT(w http.ResponseWriter, parts []string) {
+	if len(parts) != 2 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	bytes, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || bytes <= 0 {
+		sendErrorResponse(w, "invalid byte count")
+		return
+	}
+
+	result, err := store.Evict(bytes)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendObjectResponse(w, result)
+}
+
+// Pin marks key as exempt from eviction, returning false if the key does not exist.
+// Pinned keys are still removed by DEL and by their TTL.
+func (store *KeyValueStore) Pin(key string, pinned bool) bool {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	kv, ok := store.lookup(key)
+	if !ok {
+		return false
+	}
+	kv.Pinned = pinned
+	if pinned {
+		store.eviction().Remove(key)
+	} else {
+		store.recordInsert(key, kv)
+	}
+	return true
+}
+
+// handlePIN handles PIN key and UNPIN key, returning 1 when the key exists.
+func handlePIN(w http.ResponseWriter, parts []string, pinned bool) {
+	if len(parts) != 2 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	if store.Pin(parts[1], pinned) {
+		sendIntegerResponse(w, 1)
+		return
+	}
+	sendIntegerResponse(w, 0)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPinnedKeySurvivesEviction(t *testing.T) {
+	// Accesses are only tracked once a limit is set, so start with one that is never reached
+	testStore := &KeyValueStore{Data: make(map[string]*KeyValue), maxMemory: 1 << 40}
+
+	// The pinned key is the least recently used, so LRU would pick it first
+	testStore.QPush("config", []string{"feature-flags"})
+	if !testStore.Pin("config", true) {
+		t.Fatal("Expected PIN to find the key")
+	}
+	time.Sleep(time.Millisecond)
+
+	value := strings.Repeat("x", 100)
+	for i := 0; i < 50; i++ {
+		testStore.QPush("cache:"+strconv.Itoa(i), []string{value})
+	}
+
+	testStore.maxMemory = testStore.usedMemory() / 2
+	testStore.evictIfNeeded()
+
+	if _, ok := testStore.Data["config"]; !ok {
+		t.Error("Expected the pinned key to survive eviction")
+	}
+	if len(testStore.Data) >= 51 {
+		t.Errorf("Expected unpinned keys to be evicted, but %d keys remain", len(testStore.Data))
+	}
+	if used := testStore.usedMemory(); used > testStore.maxMemory {
+		t.Errorf("Expected memory usage within %d bytes, but got %d", testStore.maxMemory, used)
+	}
+
+	// The most recently written keys are kept
+	if _, ok := testStore.Data["cache:49"]; !ok {
+		t.Error("Expected the most recently used key to survive eviction")
+	}
+	if _, ok := testStore.Data["cache:0"]; ok {
+		t.Error("Expected the least recently used unpinned key to be evicted")
+	}
+}
+
+func TestUsedMemoryIsAccountedIncrementally(t *testing.T) {
+	testStore := &KeyValueStore{Data: make(map[string]*KeyValue), maxMemory: 1 << 40}
+	check := func(step string) {
+		t.Helper()
+		if used, want := atomic.LoadInt64(&testStore.usedBytes), testStore.usedMemory(); used != want {
+			t.Errorf("%s: expected %d bytes accounted, but got %d", step, want, used)
+		}
+	}
+
+	testStore.Set("accounted-string", strings.Repeat("x", 100), nil, "")
+	testStore.QPush("accounted-queue", []string{"a"})
+	testStore.evictIfNeeded()
+	check("insert")
+
+	testStore.QPush("accounted-queue", []string{strings.Repeat("y", 500)})
+	testStore.evictIfNeeded()
+	check("push")
+
+	// Growth the store does not mark is counted through the command's keys
+	testStore.SAdd("accounted-set", []string{"a"})
+	testStore.evictIfNeeded()
+	testStore.SAdd("accounted-set", []string{strings.Repeat("z", 300)})
+	testStore.evictIfNeeded("accounted-set")
+	check("written key")
+
+	testStore.Del("accounted-string", "accounted-queue")
+	testStore.evictIfNeeded()
+	check("delete")
+}
+
+func TestEvictFreesKeysInPolicyOrder(t *testing.T) {
+	testStore := &KeyValueStore{Data: make(map[string]*KeyValue), maxMemory: 1 << 40}
+	if _, err := (&KeyValueStore{Data: make(map[string]*KeyValue)}).Evict(1); err != errEvictionDisabled {
+		t.Errorf("Expected %v without a memory limit, but got %v", errEvictionDisabled, err)
+	}
+
+	// Each key holds one 20 byte value, so every entry is the same size
+	value := strings.Repeat("x", 20)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		testStore.QPush(key, []string{value})
+	}
+	size := entrySize("a", testStore.Data["a"])
+	testStore.QLen("a")
+
+	result, err := testStore.Evict(size + 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.Keys, []string{"b", "c"}) || result.Freed != 2*size {
+		t.Errorf("Expected [b c] evicted freeing %d bytes, but got %v freeing %d", 2*size, result.Keys, result.Freed)
+	}
+	if len(testStore.Data) != 2 {
+		t.Errorf("Expected 2 keys to remain, but got %d", len(testStore.Data))
+	}
+
+	result, _ = testStore.Evict(1 << 20)
+	if !reflect.DeepEqual(result.Keys, []string{"d", "a"}) {
+		t.Errorf("Expected the remaining keys [d a] to be evicted, but got %v", result.Keys)
+	}
+}
+
+func TestMemoryPressureThresholds(t *testing.T) {
+	testStore := &KeyValueStore{Data: make(map[string]*KeyValue), maxMemory: 1000, memoryWarn: 90, evictionPolicy: noEvictionPolicy{}}
+
+	testStore.QPush("small", []string{strings.Repeat("x", 500)})
+	testStore.evictIfNeeded()
+	if warning, err := testStore.checkMemory(); warning != "" || err != nil {
+		t.Errorf("Expected no backpressure below 90%%, but got %q, %v", warning, err)
+	}
+
+	testStore.QPush("small", []string{strings.Repeat("x", 330)})
+	testStore.evictIfNeeded()
+	if warning, err := testStore.checkMemory(); warning != memoryPressureWarning || err != nil {
+		t.Errorf("Expected a warning above 90%%, but got %q, %v", warning, err)
+	}
+
+	testStore.QPush("small", []string{strings.Repeat("x", 100)})
+	testStore.evictIfNeeded()
+	if _, err := testStore.checkMemory(); err != errOOM {
+		t.Errorf("Expected %v past the limit under noeviction, but got %v", errOOM, err)
+	}
+
+	// A policy that can evict brings usage back under the limit instead
+	testStore.evictionPolicy = newLRUPolicy()
+	if _, err := testStore.checkMemory(); err == errOOM {
+		t.Error("Expected writes to be admitted when the policy can evict")
+	}
+}
+
+func TestWritesRejectedPastLimitUnderNoEviction(t *testing.T) {
+	store.mutex.Lock()
+	maxMemory, policy := store.maxMemory, store.evictionPolicy
+	store.maxMemory, store.memoryWarn, store.evictionPolicy = 1<<40, 90, noEvictionPolicy{}
+	store.mutex.Unlock()
+	defer func() {
+		store.mutex.Lock()
+		store.maxMemory, store.memoryWarn, store.evictionPolicy = maxMemory, 0, policy
+		store.mutex.Unlock()
+	}()
+
+	atomic.StoreInt64(&store.usedBytes, 1<<40-1)
+	var ok struct{ Warning string }
+	decodeResponse(t, sendCommand(t, "SET pressure-key value"), &ok)
+	if ok.Warning != memoryPressureWarning {
+		t.Errorf("Expected a %q warning, but got %q", memoryPressureWarning, ok.Warning)
+	}
+
+	atomic.StoreInt64(&store.usedBytes, 1<<40+1)
+	var response ErrorResponse
+	decodeResponse(t, sendCommand(t, "SET pressure-key other"), &response)
+	if response.Error != errOOM.Error() {
+		t.Errorf("Expected %q, but got %q", errOOM, response.Error)
+	}
+
+	// Reads still run, without a warning
+	atomic.StoreInt64(&store.usedBytes, 1<<40+1)
+	var value ValueResponse
+	decodeResponse(t, sendCommand(t, "GET pressure-key"), &value)
+	if value.Value != "value" || value.Warning != "" {
+		t.Errorf("Expected GET to return the first value without a warning, but got %+v", value)
+	}
+}
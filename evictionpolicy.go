@@ -0,0 +1,293 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// EvictionPolicy decides which keys to evict once the store exceeds maxMemory.
+// The store reports every write and access to it, and asks it for victims when
+// it needs to free memory. Implementations must be safe for concurrent use, as
+// readers report accesses while holding only the store's read lock.
+type EvictionPolicy interface {
+	// RecordAccess records a read or write of an existing key.
+	RecordAccess(key string)
+	// RecordInsert records that key was created or replaced with a value of
+	// roughly size bytes.
+	RecordInsert(key string, size int)
+	// Remove stops tracking key, because it was deleted or pinned.
+	Remove(key string)
+	// Evict returns keys in the order they should be evicted, enough of them for
+	// their recorded sizes to add up to bytesNeeded. The keys stay tracked until
+	// the store removes them, so it may stop part way through the list.
+	Evict(bytesNeeded int) []string
+}
+
+// Names accepted by the -eviction-policy flag.
+const (
+	policyLRU        = "lru"
+	policyLFU        = "lfu"
+	policyRandom     = "random"
+	policyNoEviction = "noeviction"
+)
+
+// newEvictionPolicy returns the policy with the given name.
+func newEvictionPolicy(name string) (EvictionPolicy, error) {
+	switch name {
+	case policyLRU:
+		return newLRUPolicy(), nil
+	case policyLFU:
+		return newLFUPolicy(), nil
+	case policyRandom:
+		return newRandomPolicy(rand.New(rand.NewSource(time.Now().UnixNano()))), nil
+	case policyNoEviction:
+		return noEvictionPolicy{}, nil
+	}
+	return nil, fmt.Errorf("unknown eviction policy %q (want %s, %s, %s or %s)", name, policyLRU, policyLFU, policyRandom, policyNoEviction)
+}
+
+// lruEntry is a key tracked by lruPolicy.
+type lruEntry struct {
+	key  string
+	size int
+}
+
+// lruPolicy evicts the least recently used keys first. Accesses move a key to
+// the front of a list in O(1), so eviction walks the list from the back.
+type lruPolicy struct {
+	mutex   sync.Mutex
+	order   *list.List // Most recently used first; values are *lruEntry
+	entries map[string]*list.Element
+}
+
+func newLRUPolicy() *lruPolicy {
+	return &lruPolicy{order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (p *lruPolicy) RecordAccess(key string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if element, ok := p.entries[key]; ok {
+		p.order.MoveToFront(element)
+	}
+}
+
+func (p *lruPolicy) RecordInsert(key string, size int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if element, ok := p.entries[key]; ok {
+		element.Value.(*lruEntry).size = size
+		p.order.MoveToFront(element)
+		return
+	}
+	p.entries[key] = p.order.PushFront(&lruEntry{key: key, size: size})
+}
+
+func (p *lruPolicy) Remove(key string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if element, ok := p.entries[key]; ok {
+		p.order.Remove(element)
+		delete(p.entries, key)
+	}
+}
+
+func (p *lruPolicy) Evict(bytesNeeded int) []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var victims []string
+	freed := 0
+	for element := p.order.Back(); element != nil && freed < bytesNeeded; element = element.Prev() {
+		entry := element.Value.(*lruEntry)
+		victims = append(victims, entry.key)
+		freed += entry.size
+	}
+	return victims
+}
+
+// lfuEntry is a key tracked by lfuPolicy.
+type lfuEntry struct {
+	size     int
+	accesses uint64
+	last     uint64 // Sequence number of the latest access, to break ties by recency
+}
+
+// lfuPolicy evicts the least frequently used keys first, and the least
+// recently used among keys accessed equally often.
+type lfuPolicy struct {
+	mutex   sync.Mutex
+	entries map[string]*lfuEntry
+	clock   uint64
+}
+
+func newLFUPolicy() *lfuPolicy {
+	return &lfuPolicy{entries: make(map[string]*lfuEntry)}
+}
+
+func (p *lfuPolicy) RecordAccess(key string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if entry, ok := p.entries[key]; ok {
+		p.clock++
+		entry.accesses++
+		entry.last = p.clock
+	}
+}
+
+func (p *lfuPolicy) RecordInsert(key string, size int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.clock++
+	if entry, ok := p.entries[key]; ok {
+		entry.size = size
+		entry.accesses++
+		entry.last = p.clock
+		return
+	}
+	p.entries[key] = &lfuEntry{size: size, accesses: 1, last: p.clock}
+}
+
+// Redis's logarithmic frequency counter starts new keys at lfuInitVal and,
+// with an lfu-log-factor of lfuLogFactor, takes (c-lfuInitVal)*lfuLogFactor+1
+// accesses on average to climb from c to c+1, saturating at lfuMaxCounter.
+const (
+	lfuInitVal    = 5
+	lfuLogFactor  = 10
+	lfuMaxCounter = 255
+)
+
+// Freq returns the Redis-style logarithmic frequency counter of key, derived
+// from its access count, and whether key is tracked. Unlike Redis the counter
+// is deterministic and does not decay.
+func (p *lfuPolicy) Freq(key string) (int, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	entry, ok := p.entries[key]
+	if !ok {
+		return 0, false
+	}
+
+	counter := lfuInitVal
+	remaining := entry.accesses - 1 // The insert that started tracking the key
+	for counter < lfuMaxCounter {
+		needed := uint64((counter-lfuInitVal)*lfuLogFactor + 1)
+		if remaining < needed {
+			break
+		}
+		remaining -= needed
+		counter++
+	}
+	return counter, true
+}
+
+func (p *lfuPolicy) Remove(key string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	delete(p.entries, key)
+}
+
+func (p *lfuPolicy) Evict(bytesNeeded int) []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	keys := make([]string, 0, len(p.entries))
+	for key := range p.entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := p.entries[keys[i]], p.entries[keys[j]]
+		if a.accesses != b.accesses {
+			return a.accesses < b.accesses
+		}
+		return a.last < b.last
+	})
+
+	freed := 0
+	for i, key := range keys {
+		if freed >= bytesNeeded {
+			return keys[:i]
+		}
+		freed += p.entries[key].size
+	}
+	return keys
+}
+
+// randomPolicy evicts keys chosen uniformly at random, ignoring accesses.
+// Keys are kept in a slice so that removal and sampling are O(1).
+type randomPolicy struct {
+	mutex   sync.Mutex
+	rng     *rand.Rand
+	keys    []string
+	sizes   []int
+	indexes map[string]int // Position of each key in keys
+}
+
+func newRandomPolicy(rng *rand.Rand) *randomPolicy {
+	return &randomPolicy{rng: rng, indexes: make(map[string]int)}
+}
+
+func (p *randomPolicy) RecordAccess(key string) {}
+
+func (p *randomPolicy) RecordInsert(key string, size int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if i, ok := p.indexes[key]; ok {
+		p.sizes[i] = size
+		return
+	}
+	p.indexes[key] = len(p.keys)
+	p.keys = append(p.keys, key)
+	p.sizes = append(p.sizes, size)
+}
+
+func (p *randomPolicy) Remove(key string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	i, ok := p.indexes[key]
+	if !ok {
+		return
+	}
+	last := len(p.keys) - 1
+	p.keys[i], p.sizes[i] = p.keys[last], p.sizes[last]
+	p.indexes[p.keys[i]] = i
+	p.keys, p.sizes = p.keys[:last], p.sizes[:last]
+	delete(p.indexes, key)
+}
+
+func (p *randomPolicy) Evict(bytesNeeded int) []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var victims []string
+	freed := 0
+	for _, i := range p.rng.Perm(len(p.keys)) {
+		if freed >= bytesNeeded {
+			break
+		}
+		victims = append(victims, p.keys[i])
+		freed += p.sizes[i]
+	}
+	return victims
+}
+
+// noEvictionPolicy never evicts, so memory may grow past maxMemory.
+type noEvictionPolicy struct{}
+
+func (noEvictionPolicy) RecordAccess(key string)           {}
+func (noEvictionPolicy) RecordInsert(key string, size int) {}
+func (noEvictionPolicy) Remove(key string)                 {}
+func (noEvictionPolicy) Evict(bytesNeeded int) []string    { return nil }
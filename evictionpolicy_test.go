@@ -0,0 +1,113 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLRUPolicyEvictsLeastRecentlyUsed(t *testing.T) {
+	policy := newLRUPolicy()
+	for _, key := range []string{"a", "b", "c", "d"} {
+		policy.RecordInsert(key, 10)
+	}
+	policy.RecordAccess("a")
+	policy.RecordAccess("c")
+
+	if victims := policy.Evict(15); !reflect.DeepEqual(victims, []string{"b", "d"}) {
+		t.Errorf("Expected [b d], but got %v", victims)
+	}
+
+	// Evict leaves the keys tracked until they are removed
+	policy.Remove("b")
+	if victims := policy.Evict(1); !reflect.DeepEqual(victims, []string{"d"}) {
+		t.Errorf("Expected [d] after removing b, but got %v", victims)
+	}
+}
+
+func TestLFUPolicyEvictsLeastFrequentlyUsed(t *testing.T) {
+	policy := newLFUPolicy()
+	for _, key := range []string{"a", "b", "c", "d"} {
+		policy.RecordInsert(key, 10)
+	}
+	for i := 0; i < 3; i++ {
+		policy.RecordAccess("a")
+	}
+	policy.RecordAccess("b")
+	policy.RecordAccess("d")
+
+	// c was accessed least; b and d tie, and b was accessed longer ago
+	if victims := policy.Evict(25); !reflect.DeepEqual(victims, []string{"c", "b", "d"}) {
+		t.Errorf("Expected [c b d], but got %v", victims)
+	}
+	if victims := policy.Evict(1000); len(victims) != 4 || victims[3] != "a" {
+		t.Errorf("Expected every key with a last, but got %v", victims)
+	}
+}
+
+func TestRandomPolicyEvictsTrackedKeys(t *testing.T) {
+	policy := newRandomPolicy(rand.New(rand.NewSource(1)))
+	for i := 0; i < 10; i++ {
+		policy.RecordInsert("key:"+strconv.Itoa(i), 10)
+	}
+	policy.Remove("key:3")
+
+	victims := policy.Evict(35)
+	if len(victims) != 4 {
+		t.Fatalf("Expected 4 keys to cover 35 bytes, but got %v", victims)
+	}
+	seen := make(map[string]bool)
+	for _, key := range victims {
+		if key == "key:3" || seen[key] || !strings.HasPrefix(key, "key:") {
+			t.Errorf("Expected distinct tracked keys, but got %v", victims)
+		}
+		seen[key] = true
+	}
+
+	if victims := policy.Evict(1000); len(victims) != 9 {
+		t.Errorf("Expected all 9 tracked keys, but got %v", victims)
+	}
+}
+
+func TestNoEvictionPolicyKeepsKeys(t *testing.T) {
+	testStore := &KeyValueStore{Data: make(map[string]*KeyValue), maxMemory: 1 << 40, evictionPolicy: noEvictionPolicy{}}
+	for i := 0; i < 10; i++ {
+		testStore.QPush("key:"+strconv.Itoa(i), []string{"value"})
+	}
+
+	testStore.maxMemory = 1
+	testStore.evictIfNeeded()
+
+	if len(testStore.Data) != 10 {
+		t.Errorf("Expected no keys to be evicted, but %d remain", len(testStore.Data))
+	}
+}
+
+func TestStoreEvictsWithLFUPolicy(t *testing.T) {
+	testStore := &KeyValueStore{Data: make(map[string]*KeyValue), maxMemory: 1 << 40, evictionPolicy: newLFUPolicy()}
+	for i := 0; i < 10; i++ {
+		testStore.QPush("key:"+strconv.Itoa(i), []string{"value"})
+	}
+	// key:0 was written first but is read most often
+	for i := 0; i < 5; i++ {
+		testStore.QLen("key:0")
+	}
+
+	testStore.maxMemory = testStore.usedMemory() / 2
+	testStore.evictIfNeeded()
+
+	if _, ok := testStore.Data["key:0"]; !ok {
+		t.Error("Expected the most frequently used key to survive eviction")
+	}
+	if used := testStore.usedMemory(); used > testStore.maxMemory {
+		t.Errorf("Expected memory usage within %d bytes, but got %d", testStore.maxMemory, used)
+	}
+}
+
+func TestNewEvictionPolicyRejectsUnknownName(t *testing.T) {
+	if _, err := newEvictionPolicy("mru"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
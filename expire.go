@@ -0,0 +1,251 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Replies for keys without an absolute expiry time, as in Redis.
+const (
+	noExpiry   = -1 // The key exists but has no TTL
+	missingKey = -2 // The key does not exist
+)
+
+// ValueWithTTL is the reply to GET key WITHTTL. Value is null for a missing key.
+type ValueWithTTL struct {
+	Value *string `json:"value"`
+	TTL   int64   `json:"ttl"` // Seconds left, noExpiry or missingKey
+}
+
+// ttlSeconds returns the whole seconds left before expiryTime, rounded up,
+// or noExpiry when there is no expiry time.
+func ttlSeconds(expiryTime *time.Time, now time.Time) int64 {
+	if expiryTime == nil {
+		return noExpiry
+	}
+	return int64(math.Ceil(expiryTime.Sub(now).Seconds()))
+}
+
+// expirePatternBatch is the number of keys EXPIREPATTERN updates per lock acquisition.
+const expirePatternBatch = 100
+
+// refreshTTL sets kv to expire ttl from now. A ttl of 0 leaves the expiry unchanged.
+func (kv *KeyValue) refreshTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	expiry := clock.Now().Add(ttl)
+	kv.ExpiryTime = &expiry
+}
+
+// ExpireTime returns the Unix time in seconds at which key expires,
+// noExpiry if it has no TTL or missingKey if it does not exist.
+func (store *KeyValueStore) ExpireTime(key string) int64 {
+	ms := store.PExpireTime(key)
+	if ms < 0 {
+		return ms
+	}
+	return ms / 1000
+}
+
+// PExpireTime is ExpireTime in milliseconds.
+func (store *KeyValueStore) PExpireTime(key string) int64 {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	kv, ok := store.lookup(key)
+	if !ok {
+		return missingKey
+	}
+	if kv.ExpiryTime == nil {
+		return noExpiry
+	}
+	return kv.ExpiryTime.UnixMilli()
+}
+
+// ExpirePattern sets every key matching the glob pattern to expire ttl from now
+// and returns how many keys it changed. The matching keys are collected in one
+// pass and then updated a batch at a time, so other commands run between
+// batches: the update is not atomic across the match set, keys created during
+// the call are not affected and keys deleted during it are skipped.
+func (store *KeyValueStore) ExpirePattern(pattern string, ttl time.Duration) int {
+	expiry := clock.Now().Add(ttl)
+
+	var matched []string
+	store.mutex.RLock()
+	for key, kv := range store.Data {
+		if !kv.isExpired() && globMatch(pattern, key) {
+			matched = append(matched, key)
+		}
+	}
+	store.mutex.RUnlock()
+
+	changed := 0
+	for start := 0; start < len(matched); start += expirePatternBatch {
+		end := start + expirePatternBatch
+		if end > len(matched) {
+			end = len(matched)
+		}
+
+		store.mutex.Lock()
+		for _, key := range matched[start:end] {
+			if kv, ok := store.lookup(key); ok {
+				kv.ExpiryTime = &expiry
+				changed++
+			}
+		}
+		store.mutex.Unlock()
+	}
+	return changed
+}
+
+// ExpireAtMulti sets every listed key that exists to expire at the same
+// absolute time, under a single lock acquisition so they all expire together,
+// and returns how many keys it changed. A time that is not in the future
+// deletes the keys instead.
+func (store *KeyValueStore) ExpireAtMulti(at time.Time, keys []string) int {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if !at.After(clock.Now()) {
+		return store.remove(keys, store.lazyFree)
+	}
+
+	changed := 0
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if kv, ok := store.lookup(key); ok {
+			expiry := at
+			kv.ExpiryTime = &expiry
+			changed++
+		}
+	}
+	return changed
+}
+
+// handleEXPIREATMULTI handles EXPIREATMULTI unix-seconds key..., returning how many keys were changed.
+func handleEXPIREATMULTI(w http.ResponseWriter, parts []string) {
+	if len(parts) < 3 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	seconds, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || seconds < 0 {
+		sendErrorResponse(w, "invalid expiry time")
+		return
+	}
+
+	sendIntegerResponse(w, int64(store.ExpireAtMulti(time.Unix(seconds, 0), parts[2:])))
+}
+
+// handleEXPIREPATTERN handles EXPIREPATTERN pattern seconds, returning how many keys were given the TTL.
+func handleEXPIREPATTERN(w http.ResponseWriter, parts []string) {
+	if len(parts) != 3 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	seconds, err := strconv.Atoi(parts[2])
+	if err != nil || seconds <= 0 {
+		sendErrorResponse(w, "invalid expiry time")
+		return
+	}
+
+	sendIntegerResponse(w, int64(store.ExpirePattern(parts[1], time.Duration(seconds)*time.Second)))
+}
+
+// handleEXPIRETIME handles EXPIRETIME key and PEXPIRETIME key.
+func handleEXPIRETIME(w http.ResponseWriter, parts []string) {
+	if len(parts) != 2 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	if strings.ToUpper(parts[0]) == "PEXPIRETIME" {
+		sendIntegerResponse(w, store.PExpireTime(parts[1]))
+		return
+	}
+	sendIntegerResponse(w, store.ExpireTime(parts[1]))
+}
+
+// ExpiringKey is an entry in the reply to EXPIRING.
+type ExpiringKey struct {
+	Key string `json:"key"`
+	TTL int64  `json:"ttl"` // Seconds left, rounded up
+
+	expiry time.Time
+}
+
+// expiryHeap is a heap of keys with the latest expiry on top, so the nearest
+// n expiries can be kept while scanning the keyspace. It implements heap.Interface.
+type expiryHeap []ExpiringKey
+
+func (h expiryHeap) Len() int            { return len(h) }
+func (h expiryHeap) Less(i, j int) bool  { return h[i].expiry.After(h[j].expiry) }
+func (h expiryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x interface{}) { *h = append(*h, x.(ExpiringKey)) }
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// Expiring returns up to n keys matching the glob pattern that have a TTL,
+// soonest expiry first, with the seconds each has left. It scans the whole
+// keyspace under the read lock, keeping only the nearest n in a heap. The heap
+// grows as keys are found, so a huge n costs no more than the keyspace.
+func (store *KeyValueStore) Expiring(n int, pattern string) []ExpiringKey {
+	var nearest expiryHeap
+	store.ForEach(func(key string, kv *KeyValue) bool {
+		if kv.ExpiryTime == nil || (pattern != "" && !globMatch(pattern, key)) {
+			return true
+		}
+		if len(nearest) < n {
+			heap.Push(&nearest, ExpiringKey{Key: key, expiry: *kv.ExpiryTime})
+		} else if kv.ExpiryTime.Before(nearest[0].expiry) {
+			nearest[0] = ExpiringKey{Key: key, expiry: *kv.ExpiryTime}
+			heap.Fix(&nearest, 0)
+		}
+		return true
+	})
+
+	now := clock.Now()
+	keys := make([]ExpiringKey, len(nearest))
+	for i := len(keys) - 1; i >= 0; i-- {
+		key := heap.Pop(&nearest).(ExpiringKey)
+		key.TTL = ttlSeconds(&key.expiry, now)
+		keys[i] = key
+	}
+	return keys
+}
+
+// handleEXPIRING handles EXPIRING n [MATCH pattern].
+func handleEXPIRING(w http.ResponseWriter, parts []string) {
+	n, err := strconv.Atoi(parts[1])
+	if err != nil || n <= 0 {
+		sendErrorResponse(w, "invalid count")
+		return
+	}
+
+	var pattern string
+	if len(parts) > 2 {
+		if len(parts) != 4 || !strings.EqualFold(parts[2], "MATCH") {
+			sendErrorResponse(w, unexpectedToken(parts, 2, "MATCH pattern"))
+			return
+		}
+		pattern = parts[3]
+	}
+
+	sendObjectResponse(w, store.Expiring(n, pattern))
+}
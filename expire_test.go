@@ -0,0 +1,190 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestEXPIRETIME(t *testing.T) {
+	before := time.Now()
+	sendCommand(t, "SET expiretime-ttl value EX60")
+	sendCommand(t, "SET expiretime-persistent value")
+
+	var seconds, ms IntegerResponse
+	decodeResponse(t, sendCommand(t, "EXPIRETIME expiretime-ttl"), &seconds)
+	decodeResponse(t, sendCommand(t, "PEXPIRETIME expiretime-ttl"), &ms)
+
+	expected := before.Add(60 * time.Second)
+	if seconds.Value < expected.Unix() || seconds.Value > expected.Unix()+1 {
+		t.Errorf("Expected EXPIRETIME around %d, but got %d", expected.Unix(), seconds.Value)
+	}
+	if ms.Value/1000 != seconds.Value {
+		t.Errorf("Expected PEXPIRETIME %d to agree with EXPIRETIME %d", ms.Value, seconds.Value)
+	}
+
+	tests := []struct {
+		command  string
+		expected int64
+	}{
+		{"EXPIRETIME expiretime-persistent", -1},
+		{"PEXPIRETIME expiretime-persistent", -1},
+		{"EXPIRETIME expiretime-missing", -2},
+		{"PEXPIRETIME expiretime-missing", -2},
+	}
+	for _, test := range tests {
+		var response IntegerResponse
+		decodeResponse(t, sendCommand(t, test.command), &response)
+		if response.Value != test.expected {
+			t.Errorf("%s: expected %d, but got %d", test.command, test.expected, response.Value)
+		}
+	}
+}
+
+func TestGETWITHTTL(t *testing.T) {
+	sendCommand(t, "SET withttl-expiring value EX60")
+	sendCommand(t, "SET withttl-persistent value")
+
+	tests := []struct {
+		key   string
+		value *string
+		ttl   int64
+	}{
+		{"withttl-expiring", stringPtr("value"), 60},
+		{"withttl-persistent", stringPtr("value"), -1},
+		{"withttl-missing", nil, -2},
+	}
+	for _, test := range tests {
+		var response struct {
+			Value ValueWithTTL `json:"value"`
+		}
+		decodeResponse(t, sendCommand(t, "GET "+test.key+" WITHTTL"), &response)
+
+		if response.Value.TTL != test.ttl {
+			t.Errorf("%s: expected ttl %d, but got %d", test.key, test.ttl, response.Value.TTL)
+		}
+		if (response.Value.Value == nil) != (test.value == nil) ||
+			(test.value != nil && *response.Value.Value != *test.value) {
+			t.Errorf("%s: expected value %v, but got %v", test.key, test.value, response.Value.Value)
+		}
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func TestEXPIREPATTERN(t *testing.T) {
+	for i := 0; i < 250; i++ {
+		sendCommand(t, "SET cache:"+strconv.Itoa(i)+" value")
+	}
+	sendCommand(t, "SET session:1 value")
+
+	var changed IntegerResponse
+	decodeResponse(t, sendCommand(t, "EXPIREPATTERN cache:* 10"), &changed)
+	if changed.Value != 250 {
+		t.Errorf("Expected 250 keys to be given a TTL, but got %d", changed.Value)
+	}
+
+	for _, key := range []string{"cache:0", "cache:123", "cache:249"} {
+		var reply struct{ Value ValueWithTTL }
+		decodeResponse(t, sendCommand(t, "GET "+key+" WITHTTL"), &reply)
+		if reply.Value.TTL != 10 {
+			t.Errorf("Expected %s to report a TTL of 10, but got %d", key, reply.Value.TTL)
+		}
+	}
+
+	var reply struct{ Value ValueWithTTL }
+	decodeResponse(t, sendCommand(t, "GET session:1 WITHTTL"), &reply)
+	if reply.Value.TTL != noExpiry {
+		t.Errorf("Expected the unmatched key to keep no expiry, but got %d", reply.Value.TTL)
+	}
+}
+
+func TestMSETEXSetsPerKeyTTLs(t *testing.T) {
+	var ok ValueResponse
+	decodeResponse(t, sendCommand(t, "MSETEX msetex-short 10 a msetex-long 3600 b msetex-mid 300 c"), &ok)
+
+	for key, ttl := range map[string]int64{"msetex-short": 10, "msetex-long": 3600, "msetex-mid": 300} {
+		var reply struct{ Value ValueWithTTL }
+		decodeResponse(t, sendCommand(t, "GET "+key+" WITHTTL"), &reply)
+		if reply.Value.TTL != ttl {
+			t.Errorf("Expected %s to have a TTL of %d, but got %d", key, ttl, reply.Value.TTL)
+		}
+	}
+
+	// A bad triple rejects the whole batch
+	for _, command := range []string{"MSETEX msetex-bad 10 a msetex-bad-2 0 b", "MSETEX msetex-bad 10 a msetex-bad-2"} {
+		var response ErrorResponse
+		decodeResponse(t, sendCommand(t, command), &response)
+		if response.Error == "" {
+			t.Errorf("Expected %q to be rejected", command)
+		}
+		if _, err := store.Get("msetex-bad"); err != errKeyNotFound {
+			t.Errorf("Expected %q not to write any key, but got %v", command, err)
+		}
+	}
+}
+
+func TestEXPIRINGOrdersBySoonestExpiry(t *testing.T) {
+	useFakeClock(t)
+	testStore := &KeyValueStore{Data: make(map[string]*KeyValue)}
+	for key, seconds := range map[string]int{"d": 40, "b": 20, "e": 50, "a": 10, "c": 30} {
+		expiry := clock.Now().Add(time.Duration(seconds) * time.Second)
+		testStore.Set("expiring:"+key, "v", &expiry, "")
+	}
+	testStore.Set("expiring:forever", "v", nil, "")
+
+	keys := testStore.Expiring(3, "")
+	want := []ExpiringKey{{Key: "expiring:a", TTL: 10}, {Key: "expiring:b", TTL: 20}, {Key: "expiring:c", TTL: 30}}
+	if len(keys) != len(want) {
+		t.Fatalf("Expected %v, but got %v", want, keys)
+	}
+	for i := range want {
+		if keys[i].Key != want[i].Key || keys[i].TTL != want[i].TTL {
+			t.Errorf("Expected %v at position %d, but got %v", want[i], i, keys[i])
+		}
+	}
+
+	if keys := testStore.Expiring(10, ""); len(keys) != 5 {
+		t.Errorf("Expected only the 5 keys with a TTL, but got %v", keys)
+	}
+	if keys := testStore.Expiring(math.MaxInt, ""); len(keys) != 5 {
+		t.Errorf("Expected a huge count to return the 5 keys with a TTL, but got %v", keys)
+	}
+	if keys := testStore.Expiring(10, "*:e"); len(keys) != 1 || keys[0].Key != "expiring:e" {
+		t.Errorf("Expected MATCH to select expiring:e, but got %v", keys)
+	}
+}
+
+func TestEXPIREATMULTISharesOneExpiry(t *testing.T) {
+	fake := useFakeClock(t)
+	sendCommand(t, "SET release-a 1")
+	sendCommand(t, "SET release-b 2 EX100")
+	sendCommand(t, "QPUSH release-c x")
+
+	at := fake.Now().Add(time.Hour).Unix()
+	var changed IntegerResponse
+	decodeResponse(t, sendCommand(t, "EXPIREATMULTI "+strconv.FormatInt(at, 10)+" release-a release-b release-c release-missing"), &changed)
+	if changed.Value != 3 {
+		t.Errorf("Expected 3 keys to be changed, but got %d", changed.Value)
+	}
+	for _, key := range []string{"release-a", "release-b", "release-c"} {
+		var expiry IntegerResponse
+		decodeResponse(t, sendCommand(t, "EXPIRETIME "+key), &expiry)
+		if expiry.Value != at {
+			t.Errorf("Expected %s to expire at %d, but got %d", key, at, expiry.Value)
+		}
+	}
+
+	// A past time deletes the keys
+	past := fake.Now().Add(-time.Second).Unix()
+	decodeResponse(t, sendCommand(t, "EXPIREATMULTI "+strconv.FormatInt(past, 10)+" release-a release-b"), &changed)
+	if changed.Value != 2 {
+		t.Errorf("Expected 2 keys to be deleted, but got %d", changed.Value)
+	}
+	if got := store.ExpireTime("release-a"); got != missingKey {
+		t.Errorf("Expected release-a to be deleted, but EXPIRETIME is %d", got)
+	}
+}
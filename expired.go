@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Bounds on expired-key reporting. Events beyond either bound are dropped and counted.
+const (
+	expiredQueueSize = 1024  // Events waiting for the collector
+	expiredBatchSize = 10000 // Events held until the next EXPIRED DRAIN
+)
+
+// ExpiredKey records a key that expired, by TTL or by going idle.
+type ExpiredKey struct {
+	Key       string    `json:"key"`
+	ExpiredAt time.Time `json:"expired_at"`
+}
+
+// ExpiredBatch is the reply to EXPIRED DRAIN.
+type ExpiredBatch struct {
+	Keys    []ExpiredKey `json:"keys"`
+	Dropped int64        `json:"dropped"` // Events lost since the previous drain because the buffers were full
+}
+
+// expiredCollector batches expired-key events until they are drained.
+type expiredCollector struct {
+	events  chan ExpiredKey
+	once    sync.Once
+	mutex   sync.Mutex
+	batch   []ExpiredKey
+	dropped int64 // Updated atomically
+}
+
+var expiredKeys = &expiredCollector{events: make(chan ExpiredKey, expiredQueueSize)}
+
+// notifyExpired reports that key expired at expiredAt. Each value is reported
+// once, however often it is looked up before the sweeper deletes it. It never
+// blocks, so it is safe to call while holding the store lock.
+func (store *KeyValueStore) notifyExpired(key string, kv *KeyValue, expiredAt time.Time) {
+	if !atomic.CompareAndSwapInt32(&kv.expiryReported, 0, 1) {
+		return
+	}
+
+	c := expiredKeys
+	c.once.Do(func() { go c.run() })
+
+	select {
+	case c.events <- ExpiredKey{Key: key, ExpiredAt: expiredAt}:
+	default:
+		atomic.AddInt64(&c.dropped, 1)
+	}
+}
+
+// expiredAt returns when kv expired: its expiry time, or the end of its idle window.
+func (store *KeyValueStore) expiredAt(kv *KeyValue) time.Time {
+	if kv.ExpiryTime != nil && !clock.Now().Before(*kv.ExpiryTime) {
+		return *kv.ExpiryTime
+	}
+	window := kv.MaxIdle
+	if window == 0 {
+		window = store.maxIdle
+	}
+	return time.Unix(0, kv.lastAccessed()).Add(window)
+}
+
+// run moves events from the queue into the batch.
+func (c *expiredCollector) run() {
+	for event := range c.events {
+		c.mutex.Lock()
+		if len(c.batch) < expiredBatchSize {
+			c.batch = append(c.batch, event)
+		} else {
+			atomic.AddInt64(&c.dropped, 1)
+		}
+		c.mutex.Unlock()
+	}
+}
+
+// drain returns the batched events and clears them.
+func (c *expiredCollector) drain() ExpiredBatch {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	batch := ExpiredBatch{Keys: c.batch, Dropped: atomic.SwapInt64(&c.dropped, 0)}
+	if batch.Keys == nil {
+		batch.Keys = []ExpiredKey{}
+	}
+	c.batch = nil
+	return batch
+}
+
+// handleEXPIRED handles EXPIRED DRAIN, returning and clearing the keys that expired since the last drain.
+func handleEXPIRED(w http.ResponseWriter, parts []string) {
+	if len(parts) != 2 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	switch strings.ToUpper(parts[1]) {
+	case "DRAIN":
+		sendObjectResponse(w, expiredKeys.drain())
+	default:
+		sendErrorResponse(w, "invalid command")
+	}
+}
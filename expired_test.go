@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEXPIREDDRAINReportsExpiredKeys(t *testing.T) {
+	store.mutex.Lock()
+	expired := time.Now().Add(-time.Second)
+	store.insert("drain-lazy", &KeyValue{Kind: kindString, Value: []string{"v"}, ExpiryTime: &expired})
+	store.mutex.Unlock()
+
+	// A read finds the key expired before the sweeper removes it, twice
+	sendCommand(t, "GET drain-lazy")
+	sendCommand(t, "GET drain-lazy")
+
+	var seen []ExpiredKey
+	deadline := time.Now().Add(time.Second)
+	for len(seen) == 0 && time.Now().Before(deadline) {
+		var drained struct {
+			Value ExpiredBatch `json:"value"`
+		}
+		decodeResponse(t, sendCommand(t, "EXPIRED DRAIN"), &drained)
+		for _, key := range drained.Value.Keys {
+			if key.Key == "drain-lazy" {
+				seen = append(seen, key)
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if len(seen) == 0 {
+		t.Fatal("Expected drain-lazy to be reported as expired")
+	}
+	if !seen[0].ExpiredAt.Equal(expired) {
+		t.Errorf("Expected expiry time %v, but got %v", expired, seen[0].ExpiredAt)
+	}
+
+	// Each expired value is reported once, and draining clears the batch
+	time.Sleep(50 * time.Millisecond)
+	var drained struct {
+		Value ExpiredBatch `json:"value"`
+	}
+	decodeResponse(t, sendCommand(t, "EXPIRED DRAIN"), &drained)
+	for _, key := range append(seen[1:], drained.Value.Keys...) {
+		if key.Key == "drain-lazy" {
+			t.Errorf("Expected drain-lazy to be reported only once")
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"net/http"
+)
+
+// Encodings for multi-value results, chosen per request with ?format=.
+const (
+	formatArray = "array" // {"value": [...]}, the default
+	formatJSON  = "json"  // Same as formatArray
+	formatCSV   = "csv"   // A single CSV record, for spreadsheet import
+	formatLines = "lines" // One value per line, for shell pipelines
+)
+
+// Encodings for integer results, chosen per request with ?integers=.
+const (
+	integersNumber = "number" // {"value": 42}
+	integersString = "string" // {"value": "42"}, exact in clients that parse numbers as doubles
+)
+
+// integerStrings makes integer results strings when a request does not choose, set by -integer-strings.
+var integerStrings bool
+
+// formatWriter carries the requested result format and key namespace down to the response helpers.
+type formatWriter struct {
+	http.ResponseWriter
+	format    string
+	namespace string // Key prefix from X-Key-Namespace, stripped from key names in replies
+	warning   string // Added to the reply as "warning", such as under memory pressure
+	intString bool   // Encode integer results as JSON strings
+}
+
+// responseFormat returns the multi-value result format requested by r.
+func responseFormat(r *http.Request) (string, error) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "", formatArray, formatJSON:
+		return formatArray, nil
+	case formatCSV, formatLines:
+		return format, nil
+	default:
+		return "", errors.New("invalid format")
+	}
+}
+
+// integerFormat reports whether r asks for integer results encoded as strings.
+// JavaScript clients lose precision on integers beyond 2^53, such as large counters.
+func integerFormat(r *http.Request) (bool, error) {
+	switch r.URL.Query().Get("integers") {
+	case "":
+		return integerStrings, nil
+	case integersNumber:
+		return false, nil
+	case integersString:
+		return true, nil
+	default:
+		return false, errors.New("invalid integers format")
+	}
+}
+
+// listFormat returns the format multi-value results written to w should use.
+func listFormat(w http.ResponseWriter) string {
+	if fw, ok := w.(*formatWriter); ok {
+		return fw.format
+	}
+	return formatArray
+}
+
+// responseWarning returns the warning to add to the reply written to w, if any.
+func responseWarning(w http.ResponseWriter) string {
+	if fw, ok := w.(*formatWriter); ok {
+		return fw.warning
+	}
+	return ""
+}
+
+// integersAsStrings reports whether integer results written to w should be strings.
+func integersAsStrings(w http.ResponseWriter) bool {
+	if fw, ok := w.(*formatWriter); ok {
+		return fw.intString
+	}
+	return integerStrings
+}
+
+// writeCSV writes values as a single CSV record.
+func writeCSV(w http.ResponseWriter, values []string) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	writer := csv.NewWriter(w)
+	writer.Write(values)
+	writer.Flush()
+}
+
+// writeLines writes values one per line.
+func writeLines(w http.ResponseWriter, values []string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	for _, value := range values {
+		w.Write([]byte(value + "\n"))
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLRANGEFormats(t *testing.T) {
+	sendRequest(t, `{"command": "QPUSH", "key": "format-queue", "values": ["plain", "with,comma", "with \"quotes\""]}`)
+
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{"", `{"value":["plain","with,comma","with \"quotes\""]}` + "\n"},
+		{"array", `{"value":["plain","with,comma","with \"quotes\""]}` + "\n"},
+		{"json", `{"value":["plain","with,comma","with \"quotes\""]}` + "\n"},
+		{"csv", `plain,"with,comma","with ""quotes"""` + "\n"},
+		{"lines", "plain\nwith,comma\nwith \"quotes\"\n"},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest("POST", "/?format="+test.format, strings.NewReader(`{"command": "LRANGE format-queue 0 -1"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handleRequest(rr, req)
+		if rr.Code != http.StatusOK || rr.Body.String() != test.expected {
+			t.Errorf("format %q: expected %q, but got %d %q", test.format, test.expected, rr.Code, rr.Body.String())
+		}
+	}
+
+	req, err := http.NewRequest("POST", "/?format=xml", strings.NewReader(`{"command": "LRANGE format-queue 0 -1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	handleRequest(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown format to be rejected, but got %d", rr.Code)
+	}
+}
+
+func TestIntegerStringsPreserveLargeCounters(t *testing.T) {
+	sendCommand(t, "SET big-counter 9000000000000000000")
+
+	req, err := http.NewRequest("POST", "/?integers=string", strings.NewReader(`{"command": "SETMAX big-counter 9000000000000000001"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	handleRequest(rr, req)
+
+	expected := `{"value":"9000000000000000001"}` + "\n"
+	if rr.Code != http.StatusOK || rr.Body.String() != expected {
+		t.Fatalf("Expected %q, but got %d %q", expected, rr.Code, rr.Body.String())
+	}
+
+	var response IntegerStringResponse
+	decodeResponse(t, rr, &response)
+	if response.Value != 9000000000000000001 {
+		t.Errorf("Expected the counter to round-trip exactly, but got %d", response.Value)
+	}
+
+	// Numbers stay the default
+	if body := sendCommand(t, "SETMAX big-counter 9000000000000000002").Body.String(); body != `{"value":9000000000000000002}`+"\n" {
+		t.Errorf("Expected a JSON number without ?integers=string, but got %q", body)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+)
+
+// lookupHash returns the hash stored at key, or nil when the key is missing.
+// The caller must hold the store mutex.
+func (store *KeyValueStore) lookupHash(key string) (map[string]string, error) {
+	kv, ok := store.lookup(key)
+	if !ok {
+		return nil, nil
+	}
+	if kv.Hash == nil {
+		return nil, errWrongType
+	}
+	return kv.Hash, nil
+}
+
+// HSet sets field-value pairs in the hash stored at key and returns how many fields were new.
+func (store *KeyValueStore) HSet(key string, pairs []string) (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	hash, err := store.lookupHash(key)
+	if err != nil {
+		return 0, err
+	}
+	if hash == nil {
+		hash = make(map[string]string)
+		store.insert(key, &KeyValue{Kind: kindHash, Hash: hash})
+	}
+
+	added := 0
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if _, ok := hash[pairs[i]]; !ok {
+			added++
+		}
+		hash[pairs[i]] = pairs[i+1]
+	}
+	return added, nil
+}
+
+// HGet returns the value of field in the hash stored at key.
+func (store *KeyValueStore) HGet(key, field string) (string, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	hash, err := store.lookupHash(key)
+	if err != nil {
+		return "", err
+	}
+	value, ok := hash[field]
+	if !ok {
+		return "", errKeyNotFound
+	}
+	return value, nil
+}
+
+// HGetAll returns the fields and values of the hash stored at key as
+// alternating field, value entries ordered by field.
+func (store *KeyValueStore) HGetAll(key string) ([]string, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	hash, err := store.lookupHash(key)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make([]string, 0, len(hash))
+	for field := range hash {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	result := make([]string, 0, 2*len(fields))
+	for _, field := range fields {
+		result = append(result, field, hash[field])
+	}
+	return result, nil
+}
+
+// handleHSET handles HSET key field value [field value ...].
+func handleHSET(w http.ResponseWriter, parts []string) {
+	if len(parts) < 4 || len(parts)%2 != 0 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	added, err := store.HSet(parts[1], parts[2:])
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendIntegerResponse(w, int64(added))
+}
+
+// handleHGET handles HGET key field.
+func handleHGET(w http.ResponseWriter, parts []string) {
+	if len(parts) != 3 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	value, err := store.HGet(parts[1], parts[2])
+	if err != nil {
+		sendReadError(w, err)
+		return
+	}
+
+	sendValueResponse(w, value)
+}
+
+// handleHGETALL handles HGETALL key.
+func handleHGETALL(w http.ResponseWriter, parts []string) {
+	if len(parts) != 2 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	values, err := store.HGetAll(parts[1])
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendListResponse(w, values)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// idempotencyHeader is the request header clients set to make a retried command safe.
+const idempotencyHeader = "Idempotency-Key"
+
+// idempotencyTTL is how long a completed response is remembered for replays.
+var idempotencyTTL = 24 * time.Hour
+
+// idempotencyKeyPrefix namespaces the cached responses inside the key-value
+// store. A record is a string key holding the status code, a space and the
+// response body, expiring after idempotencyTTL, so it is persisted, accounted
+// and expired like any other key.
+const idempotencyKeyPrefix = "idempotency:"
+
+// idempotencyInFlight tracks the idempotency keys whose command is running, so
+// that a retry arriving meanwhile waits for it instead of running it again.
+// Each channel is closed once its command has finished.
+var idempotencyInFlight = struct {
+	mutex sync.Mutex
+	keys  map[string]chan struct{}
+}{keys: make(map[string]chan struct{})}
+
+// idempotentResponse returns the response recorded in the store under key.
+func (store *KeyValueStore) idempotentResponse(key string) (status int, body []byte, ok bool) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	kv, found := store.lookup(key)
+	if !found || kv.Kind != kindString {
+		return 0, nil, false
+	}
+	code, payload, found := strings.Cut(strings.Join(kv.Value, " "), " ")
+	if !found {
+		return 0, nil, false
+	}
+	status, err := strconv.Atoi(code)
+	if err != nil {
+		return 0, nil, false
+	}
+	return status, []byte(payload), true
+}
+
+// recordIdempotentResponse stores a response under key for idempotencyTTL.
+func (store *KeyValueStore) recordIdempotentResponse(key string, status int, body []byte) {
+	expiryTime := clock.Now().Add(idempotencyTTL)
+
+	store.mutex.Lock()
+	store.insert(key, &KeyValue{
+		Kind:       kindString,
+		Value:      []string{strconv.Itoa(status) + " " + string(body)},
+		ExpiryTime: &expiryTime,
+	})
+	store.mutex.Unlock()
+
+	store.evictIfNeeded(key)
+}
+
+// reserveIdempotencyKey marks key as in flight and returns nil, making the
+// caller responsible for calling releaseIdempotencyKey. If it is already in
+// flight it returns the channel that is closed once it is released instead.
+func reserveIdempotencyKey(key string) chan struct{} {
+	idempotencyInFlight.mutex.Lock()
+	defer idempotencyInFlight.mutex.Unlock()
+
+	if done, ok := idempotencyInFlight.keys[key]; ok {
+		return done
+	}
+	idempotencyInFlight.keys[key] = make(chan struct{})
+	return nil
+}
+
+// releaseIdempotencyKey wakes the requests waiting for key.
+func releaseIdempotencyKey(key string) {
+	idempotencyInFlight.mutex.Lock()
+	defer idempotencyInFlight.mutex.Unlock()
+
+	close(idempotencyInFlight.keys[key])
+	delete(idempotencyInFlight.keys, key)
+}
+
+// responseCapture passes a response through to the client while keeping a copy,
+// so that it can be replayed for a later request with the same idempotency key.
+type responseCapture struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rc *responseCapture) WriteHeader(status int) {
+	rc.status = status
+	rc.ResponseWriter.WriteHeader(status)
+}
+
+func (rc *responseCapture) Write(b []byte) (int, error) {
+	if rc.status == 0 {
+		rc.status = http.StatusOK
+	}
+	rc.body.Write(b)
+	return rc.ResponseWriter.Write(b)
+}
+
+// handleIdempotentRequest runs the command once per idempotency key. The key
+// is reserved before the command runs, so a request arriving while it is in
+// flight waits for it and then replays its response from the store. Failed
+// commands are not recorded, so a request waiting on one runs the command itself.
+func handleIdempotentRequest(w http.ResponseWriter, r *http.Request, key string) {
+	// Records live in the request's namespace, so namespaces cannot replay each
+	// other's responses. An invalid namespace is rejected by dispatchRequest,
+	// and failures are not recorded.
+	namespace, _ := keyNamespace(r)
+	recordKey := namespace + idempotencyKeyPrefix + key
+
+	for {
+		if status, body, ok := store.idempotentResponse(recordKey); ok {
+			w.WriteHeader(status)
+			w.Write(body)
+			return
+		}
+
+		done := reserveIdempotencyKey(recordKey)
+		if done == nil {
+			runIdempotent(w, r, recordKey)
+			return
+		}
+
+		select {
+		case <-done:
+		case <-r.Context().Done():
+			sendStatusErrorResponse(w, http.StatusServiceUnavailable, r.Context().Err().Error())
+			return
+		}
+	}
+}
+
+// runIdempotent runs the command for the reserved recordKey, recording its
+// response if it succeeded, and then releases the reservation.
+func runIdempotent(w http.ResponseWriter, r *http.Request, recordKey string) {
+	defer releaseIdempotencyKey(recordKey)
+
+	// Another request may have finished between the lookup and the reservation
+	if status, body, ok := store.idempotentResponse(recordKey); ok {
+		w.WriteHeader(status)
+		w.Write(body)
+		return
+	}
+
+	capture := &responseCapture{ResponseWriter: w}
+	dispatchRequest(capture, r)
+	if capture.status == http.StatusOK {
+		store.recordIdempotentResponse(recordKey, capture.status, capture.body.Bytes())
+	}
+}
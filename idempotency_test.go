@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestIdempotentINCR(t *testing.T) {
+	send := func() *httptest.ResponseRecorder {
+		body := strings.NewReader(`{"command": "INCR idempotent-counter"}`)
+		req, err := http.NewRequest("POST", "/", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(idempotencyHeader, "retry-1")
+
+		rr := httptest.NewRecorder()
+		handleRequest(rr, req)
+		return rr
+	}
+
+	first := send()
+	second := send()
+
+	if first.Code != http.StatusOK || second.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d and %d", http.StatusOK, first.Code, second.Code)
+	}
+
+	// The retry must replay the original response rather than increment again
+	if first.Body.String() != second.Body.String() {
+		t.Errorf("Expected replayed body %q, but got %q", first.Body.String(), second.Body.String())
+	}
+
+	store.mutex.RLock()
+	value := store.Data["idempotent-counter"].Value[0]
+	store.mutex.RUnlock()
+
+	if value != "1" {
+		t.Errorf("Expected counter to be incremented once to 1, but got %s", value)
+	}
+}
+
+func TestIdempotentConcurrentRequestsRunOnce(t *testing.T) {
+	const requests = 20
+
+	var wg sync.WaitGroup
+	bodies := make([]string, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := httptest.NewRequest("POST", "/", strings.NewReader(`{"command": "INCR idempotent-concurrent"}`))
+			req.Header.Set(idempotencyHeader, "concurrent-1")
+			rr := httptest.NewRecorder()
+			handleRequest(rr, req)
+			bodies[i] = rr.Body.String()
+		}(i)
+	}
+	wg.Wait()
+
+	for i, body := range bodies {
+		if body != bodies[0] {
+			t.Errorf("Expected request %d to replay %q, but got %q", i, bodies[0], body)
+		}
+	}
+
+	store.mutex.RLock()
+	value := store.Data["idempotent-concurrent"].Value[0]
+	record, recorded := store.Data["idempotency:concurrent-1"]
+	store.mutex.RUnlock()
+
+	if value != "1" {
+		t.Errorf("Expected counter to be incremented once to 1, but got %s", value)
+	}
+	if !recorded || record.ExpiryTime == nil {
+		t.Error("Expected the idempotency record to be kept in the store with an expiry")
+	}
+}
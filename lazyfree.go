@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// lazyFreeThreshold is the element count above which removed values are torn
+// down in the background. Smaller values are cheaper to drop inline.
+const lazyFreeThreshold = 64
+
+var (
+	lazyFreeQueue = make(chan *KeyValue, 1024)
+	lazyFreeOnce  sync.Once
+	lazyFreed     int64 // Values torn down in the background, updated atomically
+)
+
+// elements returns the number of elements held by kv.
+func (kv *KeyValue) elements() int {
+	count := len(kv.Value) + len(kv.Delayed) + len(kv.Set) + len(kv.Hash)
+	if kv.Priority != nil {
+		count += kv.Priority.Len()
+	}
+	return count
+}
+
+// free drops every element held by kv so the garbage collector can reclaim them.
+// kv must no longer be reachable from the store.
+func (kv *KeyValue) free() {
+	for member := range kv.Set {
+		delete(kv.Set, member)
+	}
+	for field := range kv.Hash {
+		delete(kv.Hash, field)
+	}
+	kv.Value, kv.Delayed, kv.Priority, kv.Set, kv.Hash = nil, nil, nil, nil, nil
+}
+
+// lazyFree hands a value removed from the store to the single background
+// freeing goroutine if it is large, so the caller can release the store lock
+// quickly. At most cap(lazyFreeQueue) values wait for it.
+func lazyFree(kv *KeyValue) {
+	if kv.elements() < lazyFreeThreshold {
+		return
+	}
+
+	lazyFreeOnce.Do(func() { go runLazyFree() })
+
+	select {
+	case lazyFreeQueue <- kv:
+	default:
+		// The worker is backed up. Never block the caller holding the lock, nor
+		// start more goroutines: the value is left to the garbage collector.
+	}
+}
+
+// runLazyFree tears down values queued by lazyFree.
+func runLazyFree() {
+	for kv := range lazyFreeQueue {
+		kv.free()
+		atomic.AddInt64(&lazyFreed, 1)
+	}
+}
+
+// Unlink removes keys like Del, but frees large values in the background.
+func (store *KeyValueStore) Unlink(ctx context.Context, keys ...string) (int, error) {
+	if err := store.lockContext(ctx); err != nil {
+		return 0, err
+	}
+	defer store.mutex.Unlock()
+
+	return store.remove(keys, true), nil
+}
+
+// remove deletes keys and returns how many of them existed. With lazy set,
+// large values are freed in the background. The caller must hold the store write lock.
+func (store *KeyValueStore) remove(keys []string, lazy bool) int {
+	deleted := 0
+	for _, key := range keys {
+		kv, ok := store.Data[key]
+		if !ok {
+			continue
+		}
+		if !kv.isExpired() {
+			deleted++
+		}
+		store.drop(key)
+		if lazy {
+			lazyFree(kv)
+		}
+	}
+	return deleted
+}
+
+// handleUNLINK handles UNLINK key..., returning how many keys existed.
+func handleUNLINK(ctx context.Context, w http.ResponseWriter, parts []string) {
+	if len(parts) < 2 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	deleted, err := store.Unlink(ctx, parts[1:]...)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendIntegerResponse(w, int64(deleted))
+}
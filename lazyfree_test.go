@@ -0,0 +1,52 @@
+package main
+
+import (
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUNLINKFreesLargeValueInBackground(t *testing.T) {
+	values := make([]string, 10000)
+	for i := range values {
+		values[i] = "job-" + strconv.Itoa(i)
+	}
+	store.QPush("unlink-queue", values)
+
+	reclaimed := make(chan struct{})
+	store.mutex.Lock()
+	runtime.SetFinalizer(store.Data["unlink-queue"], func(*KeyValue) { close(reclaimed) })
+	store.mutex.Unlock()
+
+	freedBefore := atomic.LoadInt64(&lazyFreed)
+
+	var deleted IntegerResponse
+	decodeResponse(t, sendCommand(t, "UNLINK unlink-queue unlink-missing"), &deleted)
+	if deleted.Value != 1 {
+		t.Errorf("Expected 1 key to be unlinked, but got %d", deleted.Value)
+	}
+
+	var length IntegerResponse
+	decodeResponse(t, sendCommand(t, "QLEN unlink-queue"), &length)
+	if length.Value != 0 {
+		t.Errorf("Expected the queue to be gone, but it has %d values", length.Value)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		runtime.GC()
+		select {
+		case <-reclaimed:
+			if atomic.LoadInt64(&lazyFreed) == freedBefore {
+				t.Errorf("Expected the value to be freed by the background goroutine")
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the unlinked value to be reclaimed")
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LockExtend adds extra to the TTL of the lock at key only if its value is
+// token, proving the caller still holds it, and reports whether it did. A lock
+// without a TTL never expires, so it is left as it is but still counts as held.
+func (store *KeyValueStore) LockExtend(key, token string, extra time.Duration) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	kv, ok := store.lookup(key)
+	if !ok {
+		return false, nil
+	}
+	if kv.Kind != kindString {
+		return false, errWrongType
+	}
+	if strings.Join(kv.Value, " ") != token {
+		return false, nil
+	}
+
+	if kv.ExpiryTime != nil {
+		expiry := kv.ExpiryTime.Add(extra)
+		kv.ExpiryTime = &expiry
+	}
+	return true, nil
+}
+
+// handleLOCKEXTEND handles LOCKEXTEND key token seconds, returning 1 if the
+// lock was extended and 0 if it is missing or held under another token.
+func handleLOCKEXTEND(w http.ResponseWriter, parts []string) {
+	if len(parts) != 4 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	seconds, err := strconv.Atoi(parts[3])
+	if err != nil || seconds <= 0 {
+		sendErrorResponse(w, "invalid expiry time")
+		return
+	}
+
+	extended, err := store.LockExtend(parts[1], parts[2], time.Duration(seconds)*time.Second)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+	if extended {
+		sendIntegerResponse(w, 1)
+		return
+	}
+	sendIntegerResponse(w, 0)
+}
+
+// GETORLOCK statuses.
+const (
+	lockHit     = "hit"     // The value is cached
+	lockCompute = "compute" // The caller holds the lock and should compute the value
+	lockLocked  = "locked"  // Another caller is computing the value
+)
+
+// maxComputeLocks is the number of GETORLOCK locks above which expired ones
+// are pruned, as locks for values never written only go away when replaced.
+const maxComputeLocks = 1024
+
+// computeLock is a GETORLOCK lock on a missing key, released by the next
+// write to the key or when it expires.
+type computeLock struct {
+	token  string
+	expiry time.Time
+}
+
+// GetOrLockResult is the reply to GETORLOCK.
+type GetOrLockResult struct {
+	Status  string `json:"status"`
+	Value   string `json:"value,omitempty"`
+	LockTTL int64  `json:"lock_ttl_ms,omitempty"` // Milliseconds until a lock held by another caller expires
+}
+
+// GetOrLock returns the string stored at key. When the key is missing it locks
+// the key for ttl under token instead, so that only one caller computes the
+// value while the others back off until a write to the key releases the lock.
+// The lock holder asking again with the same token is told to compute again.
+func (store *KeyValueStore) GetOrLock(key, token string, ttl time.Duration) (GetOrLockResult, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if kv, ok := store.lookup(key); ok {
+		if kv.Kind != kindString {
+			return GetOrLockResult{}, errWrongType
+		}
+		return GetOrLockResult{Status: lockHit, Value: strings.Join(kv.Value, " ")}, nil
+	}
+
+	now := clock.Now()
+	if lock, ok := store.computeLocks[key]; ok && lock.expiry.After(now) && lock.token != token {
+		return GetOrLockResult{Status: lockLocked, LockTTL: lock.expiry.Sub(now).Milliseconds()}, nil
+	}
+
+	if store.computeLocks == nil {
+		store.computeLocks = make(map[string]computeLock)
+	}
+	if len(store.computeLocks) >= maxComputeLocks {
+		for k, lock := range store.computeLocks {
+			if !lock.expiry.After(now) {
+				delete(store.computeLocks, k)
+			}
+		}
+	}
+	store.computeLocks[key] = computeLock{token: token, expiry: now.Add(ttl)}
+	return GetOrLockResult{Status: lockCompute}, nil
+}
+
+// handleGETORLOCK handles GETORLOCK key token seconds, returning the value, or
+// whether the caller should compute it or back off while another caller does.
+func handleGETORLOCK(w http.ResponseWriter, parts []string) {
+	if len(parts) != 4 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	seconds, err := strconv.ParseFloat(parts[3], 64)
+	if err != nil || seconds <= 0 {
+		sendErrorResponse(w, "invalid expiry time")
+		return
+	}
+	if parts[2] == "" {
+		sendErrorResponse(w, "invalid lock token")
+		return
+	}
+
+	result, err := store.GetOrLock(parts[1], parts[2], time.Duration(seconds*float64(time.Second)))
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendObjectResponse(w, result)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLOCKEXTENDRequiresTheOwnersToken(t *testing.T) {
+	fake := useFakeClock(t)
+	sendCommand(t, "SET job-lock owner-a EX10")
+
+	var response IntegerResponse
+	decodeResponse(t, sendCommand(t, "LOCKEXTEND job-lock owner-b 30"), &response)
+	if response.Value != 0 {
+		t.Fatalf("Expected a wrong token not to extend the lock, but got %d", response.Value)
+	}
+	if got := store.ExpireTime("job-lock"); got != fake.Now().Add(10*time.Second).Unix() {
+		t.Errorf("Expected the TTL to stay 10s after a failed extend, but expiry is %d", got)
+	}
+
+	decodeResponse(t, sendCommand(t, "LOCKEXTEND job-lock owner-a 30"), &response)
+	if response.Value != 1 {
+		t.Fatalf("Expected the owner's token to extend the lock, but got %d", response.Value)
+	}
+	if got := store.ExpireTime("job-lock"); got != fake.Now().Add(40*time.Second).Unix() {
+		t.Errorf("Expected the TTL to grow to 40s, but expiry is %d", got)
+	}
+
+	// Once the lock has expired there is nothing left to extend
+	fake.Advance(41 * time.Second)
+	decodeResponse(t, sendCommand(t, "LOCKEXTEND job-lock owner-a 30"), &response)
+	if response.Value != 0 {
+		t.Errorf("Expected an expired lock not to be extended, but got %d", response.Value)
+	}
+}
+
+func TestGETORLOCKLetsOneCallerCompute(t *testing.T) {
+	const callers = 20
+
+	statuses := make(chan GetOrLockResult, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var response struct {
+				Value GetOrLockResult `json:"value"`
+			}
+			decodeResponse(t, sendCommand(t, "GETORLOCK stampede-key token-"+strconv.Itoa(i)+" 10"), &response)
+			statuses <- response.Value
+		}(i)
+	}
+	wg.Wait()
+	close(statuses)
+
+	compute := 0
+	for result := range statuses {
+		switch result.Status {
+		case lockCompute:
+			compute++
+		case lockLocked:
+			if result.LockTTL <= 0 {
+				t.Errorf("Expected a locked reply to say when the lock expires, but got %+v", result)
+			}
+		default:
+			t.Errorf("Expected compute or locked on a miss, but got %+v", result)
+		}
+	}
+	if compute != 1 {
+		t.Fatalf("Expected exactly one caller to be told to compute, but %d were", compute)
+	}
+
+	// Writing the value releases the lock
+	sendCommand(t, "SET stampede-key computed")
+	var response struct {
+		Value GetOrLockResult `json:"value"`
+	}
+	decodeResponse(t, sendCommand(t, "GETORLOCK stampede-key token-x 10"), &response)
+	if response.Value.Status != lockHit || response.Value.Value != "computed" {
+		t.Errorf("Expected a hit after the value was written, but got %+v", response.Value)
+	}
+	sendCommand(t, "DEL stampede-key")
+	decodeResponse(t, sendCommand(t, "GETORLOCK stampede-key token-x 10"), &response)
+	if response.Value.Status != lockCompute {
+		t.Errorf("Expected the SET to have released the lock, but got %+v", response.Value)
+	}
+}
@@ -1,19 +1,46 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 // KeyValue represents a key-value pair in the datastore.
 // It stores the value and an optional expiry time for the key.
 type KeyValue struct {
+	Kind       string     // The type of value held: kindString, kindList, kindSet, kindHash or kindTimeSeries
 	Value      []string   // The value associated with the key
 	ExpiryTime *time.Time // The expiry time for the key (optional)
+
+	Priority *priorityQueue // Set when the key holds a priority queue instead of a plain one
+	Delayed  []delayedItem  // Values pushed with a delay, sorted by the time they become visible
+
+	Set  map[string]struct{} // Set when the key holds a set
+	Hash map[string]string   // Set when the key holds a hash
+
+	Series *timeSeries // Set when the key holds a time series
+
+	Pinned     bool          // Pinned keys are never evicted
+	closed     bool          // Set by QCLOSE on a queue: pushes fail while pops drain what is left
+	MaxIdle    time.Duration // Expire the key once it goes unaccessed this long; 0 uses the store's maxIdle
+	lastAccess int64         // Unix nanoseconds of the last access, updated atomically
+
+	expiryReported int32  // Set once the key's expiry has been reported to EXPIRED DRAIN, updated atomically
+	version        uint64 // Changes on every write to a string value, for GETVER and SETVER
+	etag           string // Set by SETIF, and cleared by any other write
 }
 
 // KeyValueStore represents an in-memory key-value data store.
@@ -21,13 +48,81 @@ type KeyValue struct {
 type KeyValueStore struct {
 	Data  map[string]*KeyValue // The underlying data store
 	mutex sync.RWMutex         // Mutex for thread-safe access to the data store
+
+	waiters         map[string][]*waiter   // Clients blocked on each queue, longest-waiting first
+	lastWaiterID    uint64                 // ID given to the most recently blocked client
+	serving         bool                   // Set while serveWaiters runs, so nested calls queue their keys instead
+	pendingServe    []string               // Queues written while serving, to be served once the current pass ends
+	watchers        map[string]*keyWatch   // WATCHGET clients waiting for each key to change
+	computeLocks    map[string]computeLock // GETORLOCK locks on missing keys being computed
+	maxMemory       int64                  // Approximate memory limit in bytes; 0 disables eviction
+	lazyFree        bool                   // Free large values removed by DEL in the background, as UNLINK does
+	maxIdle         time.Duration          // Expire keys unaccessed for this long; 0 disables idle expiry
+	lastVersion     uint64                 // Version given to the most recently written value
+	maxPushGetReply int                    // Longest list LPUSHGET may return; 0 means no limit
+	usedBytes       int64                  // Estimated memory usage, kept up to date after every command while maxMemory is set
+	entrySizes      map[string]int64       // Estimated size of each key when it was last accounted, while maxMemory is set
+	unaccounted     map[string]struct{}    // Keys written since usedBytes was last brought up to date
+	memoryWarn      int                    // Percentage of maxMemory above which writes are answered with a warning; 0 disables it
+	maxQueueLength  int                    // Most visible values a push may leave in a queue; 0 means no limit
+	queueOverflow   string                 // What a push past maxQueueLength does: overflowReject (the default), overflowDropHead or overflowDropNew
+	queueStats      map[string]*queueRates // Recent pushes and pops per queue, for QSTATS
+	claims          map[string]*claimTable // Values taken by QCLAIM and not yet acknowledged, per queue
+
+	evictionPolicy EvictionPolicy // Chooses keys to evict beyond maxMemory; LRU when nil
+	policyOnce     sync.Once      // Guards defaulting evictionPolicy
+}
+
+// Type tags stored in KeyValue.Kind.
+const (
+	kindString = "string"
+	kindList   = "list"
+	kindSet    = "set"
+	kindHash   = "hash"
+
+	kindTimeSeries = "timeseries"
+)
+
+// isExpired reports whether the key has an expiry time that has already passed.
+func (kv *KeyValue) isExpired() bool {
+	return kv.ExpiryTime != nil && clock.Now().After(*kv.ExpiryTime)
+}
+
+// lookup returns the live entry for key, treating expired and idle keys as missing.
+// The caller must hold the store mutex.
+func (store *KeyValueStore) lookup(key string) (*KeyValue, bool) {
+	kv, ok := store.Data[key]
+	if !ok {
+		return nil, false
+	}
+	now := clock.Now()
+	if kv.isExpired() || store.isIdle(kv, now) {
+		store.notifyExpired(key, kv, store.expiredAt(kv))
+		return nil, false
+	}
+	kv.touch(now)
+	store.recordAccess(key)
+	return kv, true
+}
+
+// insert stores kv under key, recording the write as an access.
+// The caller must hold the store write lock.
+func (store *KeyValueStore) insert(key string, kv *KeyValue) {
+	kv.touch(clock.Now())
+	store.stamp(key, kv)
+	store.Data[key] = kv
+	store.recordInsert(key, kv)
 }
 
 // Mutex : Primitive used in concurrent programming to protect shared resources
 // from being accessed simultaneously by multiple threads or goroutines
 
 type Command struct {
-	Command string `json:"command"` // Represents a JSON command received via the REST API.
+	Command string   `json:"command"`          // Represents a JSON command received via the REST API.
+	Key     string   `json:"key,omitempty"`    // Target key for structured command forms.
+	Values  []string `json:"values,omitempty"` // Values for structured command forms, used verbatim without tokenization.
+
+	ValueB64 *string `json:"value_b64,omitempty"` // Base64-encoded value for binary-safe structured commands.
 }
 
 type ErrorResponse struct {
@@ -35,87 +130,496 @@ type ErrorResponse struct {
 }
 
 type ValueResponse struct {
-	Value string `json:"value"` // Represents a JSON response containing a value.
+	Value   string `json:"value"`             // Represents a JSON response containing a value.
+	Warning string `json:"warning,omitempty"` // Set on writes made under memory pressure.
+}
+
+type BinaryValueResponse struct {
+	ValueB64 string `json:"value_b64"`         // Represents a JSON response containing a base64-encoded binary value.
+	Warning  string `json:"warning,omitempty"` // Set on writes made under memory pressure.
+}
+
+type IntegerResponse struct {
+	Value   int64  `json:"value"`             // Represents a JSON response containing an integer.
+	Warning string `json:"warning,omitempty"` // Set on writes made under memory pressure.
 }
 
-type QueueOperation struct {
-	Operation      string
-	Key            string
-	Values         []string
-	Response       chan string
-	ResponseWriter http.ResponseWriter
+type IntegerStringResponse struct {
+	Value   int64  `json:"value,string"`      // An integer encoded as a JSON string, for ?integers=string.
+	Warning string `json:"warning,omitempty"` // Set on writes made under memory pressure.
+}
+
+type ListResponse struct {
+	Value   []string `json:"value"`             // Represents a JSON response containing a list of values.
+	Warning string   `json:"warning,omitempty"` // Set on writes made under memory pressure.
 }
 
 var store = &KeyValueStore{
 	Data: make(map[string]*KeyValue), // Initializes the key-value data store.
 }
 
-var queueChannel = make(chan QueueOperation)
-var queueListeners sync.WaitGroup
+var errQueueEmpty = errors.New("queue is empty")
+var errKeyNotFound = errors.New("key not found")
+var errKeyExists = errors.New("key already exists")
+var errKeyMissing = errors.New("key does not exist")
+var errInvalidKind = errors.New("invalid type; expected string, list, set, or hash")
 
 func main() {
-	http.HandleFunc("/", handleRequest) // Sets up the request handler
-	http.ListenAndServe(":8080", nil)   // Starts the HTTP server and listens on port 8080.
+	flag.IntVar(&sweeperConfig.SampleSize, "sweep-sample", sweeperConfig.SampleSize, "maximum keys examined per expiry sweep round")
+	flag.Float64Var(&sweeperConfig.ExpiredThreshold, "sweep-threshold", sweeperConfig.ExpiredThreshold, "expired fraction above which the sweeper runs another round")
+	flag.Int64Var(&store.maxMemory, "maxmemory", 0, "approximate memory limit in bytes before keys are evicted (0 disables eviction)")
+	flag.IntVar(&store.memoryWarn, "maxmemory-warn", 90, "percentage of -maxmemory above which write replies carry a memory pressure warning (0 disables it)")
+	evictionPolicy := flag.String("eviction-policy", policyLRU, "keys evicted beyond -maxmemory: lru, lfu, random or noeviction")
+	flag.BoolVar(&integerStrings, "integer-strings", false, "encode integer results as JSON strings unless a request asks for ?integers=number, for clients that lose precision beyond 2^53")
+	flag.IntVar(&broker.historySize, "pubsub-history", 0, "messages kept per pub/sub channel for subscribers that ask for a replay (0 disables replay)")
+	flag.BoolVar(&store.lazyFree, "lazyfree", false, "free large values removed by DEL in the background, as UNLINK does")
+	flag.DurationVar(&store.maxIdle, "maxidle", 0, "expire keys that have not been accessed for this long, such as 1h (0 disables idle expiry)")
+	flag.IntVar(&store.maxPushGetReply, "lpushget-max", 10000, "longest list LPUSHGET may grow and return, in elements (0 means no limit)")
+	flag.IntVar(&store.maxQueueLength, "max-queue-length", 0, "most values a push may leave in a queue (0 means no limit)")
+	flag.StringVar(&store.queueOverflow, "queue-overflow", overflowReject, "what a push beyond -max-queue-length does: reject, drop-head (drop the oldest values) or drop-new (drop the values that do not fit)")
+	debugClock := flag.Bool("debug-clock", false, "start a fake clock at the current time that DEBUG SET-TIME and DEBUG ADVANCE-TIME can move, for testing expiry")
+	loadPath := flag.String("load", "", "RDB file to load into the store at startup")
+	flag.Var(renamedCommands, "rename-command", "make a command available only under a new name, as \"COMMAND NEWNAME\", or disable it with just \"COMMAND\"; may be repeated")
+	var tlsOptions TLSOptions
+	flag.StringVar(&tlsOptions.CertFile, "tls-cert", "", "TLS certificate file; serves HTTPS when set")
+	flag.StringVar(&tlsOptions.KeyFile, "tls-key", "", "TLS private key file")
+	flag.StringVar(&tlsOptions.ClientCAFile, "tls-client-ca", "", "CA bundle used to verify client certificates")
+	flag.BoolVar(&tlsOptions.RequireClientCert, "tls-require-client-cert", false, "reject clients without a certificate signed by -tls-client-ca")
+	flag.Parse()
+
+	policy, err := newEvictionPolicy(*evictionPolicy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	store.evictionPolicy = policy
+
+	switch store.queueOverflow {
+	case overflowReject, overflowDropHead, overflowDropNew:
+	default:
+		log.Fatalf("unknown queue overflow policy %q (want %s, %s or %s)", store.queueOverflow, overflowReject, overflowDropHead, overflowDropNew)
+	}
+
+	if *debugClock {
+		clock = NewFakeClock(time.Now())
+	}
+
+	if *loadPath != "" {
+		file, err := os.Open(*loadPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = store.LoadRDB(file)
+		file.Close()
+		if err != nil {
+			log.Fatalf("loading %s: %v", *loadPath, err)
+		}
+	}
+
+	go store.runSweeper(sweeperConfig, nil) // Actively removes expired keys in the background
+
+	http.HandleFunc("/", handleRequest)            // Sets up the request handler
+	http.HandleFunc("/kv/", handleKeyRequest)      // RESTful routes for single keys
+	http.HandleFunc("/dump.rdb", handleDumpRDB)    // Keyspace export in RDB format
+	http.HandleFunc("/metrics", handleMetrics)     // Memory gauges for Prometheus
+	http.HandleFunc("/report.csv", handleReport)   // Per-key capacity report
+	http.HandleFunc("/subscribe", handleSubscribe) // Pub/sub message streams
+	http.HandleFunc("/bulk", handleBulk)           // Newline-delimited command loads
+	http.HandleFunc("/events", handleEvents)       // Server-Sent Events for browsers
+
+	if tlsOptions.CertFile == "" {
+		http.ListenAndServe(":8080", nil) // Starts the HTTP server and listens on port 8080.
+		return
+	}
+
+	config, err := tlsConfig(tlsOptions)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server := &http.Server{Addr: ":8080", TLSConfig: config}
+	log.Fatal(server.ListenAndServeTLS("", ""))
 }
 
 // Sends error response to the client.
 func sendErrorResponse(w http.ResponseWriter, errorMessage string) {
+	sendStatusErrorResponse(w, http.StatusBadRequest, errorMessage)
+}
+
+// Sends an error response with the given HTTP status.
+func sendStatusErrorResponse(w http.ResponseWriter, status int, errorMessage string) {
 	// Create ErrorResponse object as JSON with the specified error message.
-	w.WriteHeader(http.StatusBadRequest)
+	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(ErrorResponse{Error: errorMessage})
 }
 
-// Sends a value response.
+// Sends the error of a read command. A missing key is a normal cache miss
+// rather than a bad request, so it is answered with 404 Not Found.
+func sendReadError(w http.ResponseWriter, err error) {
+	if err == errKeyNotFound {
+		sendStatusErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	sendErrorResponse(w, err.Error())
+}
+
+// Sends a value response. Values that are not valid UTF-8 cannot be carried in
+// a JSON string intact, so they are sent base64-encoded as "value_b64" instead.
 func sendValueResponse(w http.ResponseWriter, value string) {
+	if !utf8.ValidString(value) {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(BinaryValueResponse{ValueB64: base64.StdEncoding.EncodeToString([]byte(value)), Warning: responseWarning(w)})
+		return
+	}
+
 	// CreateValueResponse object as JSON with the specified value.
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(ValueResponse{Value: value})
+	json.NewEncoder(w).Encode(ValueResponse{Value: value, Warning: responseWarning(w)})
+}
+
+// Sends an integer response.
+func sendIntegerResponse(w http.ResponseWriter, value int64) {
+	w.WriteHeader(http.StatusOK)
+	if integersAsStrings(w) {
+		json.NewEncoder(w).Encode(IntegerStringResponse{Value: value, Warning: responseWarning(w)})
+		return
+	}
+	json.NewEncoder(w).Encode(IntegerResponse{Value: value, Warning: responseWarning(w)})
+}
+
+// Sends a list response, in the format the client asked for with ?format=.
+func sendListResponse(w http.ResponseWriter, values []string) {
+	switch listFormat(w) {
+	case formatCSV:
+		writeCSV(w, values)
+		return
+	case formatLines:
+		writeLines(w, values)
+		return
+	}
+
+	if values == nil {
+		values = []string{} // Encode an empty list as [] rather than null.
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ListResponse{Value: values, Warning: responseWarning(w)})
+}
+
+// Sends a structured value response, encoding value as the JSON "value" field.
+func sendObjectResponse(w http.ResponseWriter, value interface{}) {
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(struct {
+		Value   interface{} `json:"value"`
+		Warning string      `json:"warning,omitempty"`
+	}{stripNamespace(w, value), responseWarning(w)})
 }
 
 // Sends a simple OK response to the client.
 func sendOKResponse(w http.ResponseWriter) {
 	// Send an empty response as JSON to indicate a successful response.
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(struct{}{})
+	json.NewEncoder(w).Encode(struct {
+		Warning string `json:"warning,omitempty"`
+	}{responseWarning(w)})
 }
 
 // ResponseWrites helps to onstruct and send response back to client
 // Request represents incoming HTTP requests recieved from client
 
 func handleRequest(w http.ResponseWriter, r *http.Request) {
+	// Retried writes carrying an Idempotency-Key are answered from the cached response.
+	if key := r.Header.Get(idempotencyHeader); key != "" {
+		handleIdempotentRequest(w, r, key)
+		return
+	}
+
+	dispatchRequest(w, r)
+}
+
+// dispatchRequest decodes the command from the request body and runs the matching handler.
+func dispatchRequest(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body) //Decoder to decode request body into "Command" struct
 	defer r.Body.Close()               //Request body is closed after request is processed
 
+	// Keep memory under the configured limit once the command has run, counting
+	// the growth of the keys it wrote
+	var written []string
+	defer func() { store.evictIfNeeded(written...) }()
+
+	// Commands that wait on the store lock give up once the client's budget is spent
+	ctx, cancel, err := commandContext(r)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+	defer cancel()
+
+	format, err := responseFormat(r)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+	intString, err := integerFormat(r)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+	namespace, err := keyNamespace(r)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+	w = &formatWriter{ResponseWriter: w, format: format, namespace: namespace, intString: intString}
+
 	var cmd Command
-	err := decoder.Decode(&cmd)
+	err = decoder.Decode(&cmd)
 	if err != nil {
 		sendErrorResponse(w, "invalid request")
 		return
 	}
 
+	// Structured form: values arrive as a JSON array (or base64) and bypass whitespace tokenization.
+	if cmd.Values != nil || cmd.ValueB64 != nil {
+		if cmd.Command, err = resolveCommand(cmd.Command); err != nil {
+			sendErrorResponse(w, err.Error())
+			return
+		}
+		if cmd.Key != "" {
+			cmd.Key = namespace + cmd.Key
+		}
+		if !admitWrite(w, strings.ToUpper(cmd.Command)) {
+			return
+		}
+		written = []string{cmd.Key}
+		handleStructuredCommand(w, cmd)
+		return
+	}
+
 	parts := strings.Split(cmd.Command, " ") //Splits the command string into parts
-	if len(parts) == 0 {
-		sendErrorResponse(w, "invalid command")
+	if parts[0], err = resolveCommand(parts[0]); err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+	if err := checkCommand(parts); err != nil {
+		sendErrorResponse(w, err.Error())
 		return
 	}
+	if !admitWrite(w, strings.ToUpper(parts[0])) {
+		return
+	}
+	if namespace != "" {
+		parts = namespaced(parts, namespace)
+	}
+	if denyOOMCommands[strings.ToUpper(parts[0])] {
+		for _, i := range keyIndexes(parts) {
+			written = append(written, parts[i])
+		}
+	}
 	//First index is converted to uppercase and performed a switch statement to trigger appropriate function.
 	switch strings.ToUpper(parts[0]) {
+	case "PING":
+		sendValueResponse(w, "PONG")
 	case "SET":
-		handleSET(w, parts)
+		handleSET(ctx, w, parts)
+	case "SETNX":
+		handleSETNX(ctx, w, parts)
+	case "ENSURE":
+		handleENSURE(w, parts)
+	case "MSETEX":
+		handleMSETEX(w, parts)
 	case "GET":
-		handleGET(w, parts)
+		handleGET(ctx, w, parts)
+	case "UNLINK":
+		handleUNLINK(ctx, w, parts)
+	case "MGET":
+		handleMGET(w, parts)
+	case "MGETMAP":
+		handleMGETMAP(w, parts)
+	case "GETDEFAULT":
+		handleGETDEFAULT(w, parts)
+	case "EXPIREATMULTI":
+		handleEXPIREATMULTI(w, parts)
+	case "EXPIREPATTERN":
+		handleEXPIREPATTERN(w, parts)
+	case "EXPIRING":
+		handleEXPIRING(w, parts)
+	case "EXPIRETIME", "PEXPIRETIME":
+		handleEXPIRETIME(w, parts)
+	case "GETVER":
+		handleGETVER(w, parts)
+	case "WATCHGET":
+		handleWATCHGET(ctx, w, parts)
+	case "SETVER":
+		handleSETVER(w, parts)
+	case "GETORLOCK":
+		handleGETORLOCK(w, parts)
+	case "LOCKEXTEND":
+		handleLOCKEXTEND(w, parts)
+	case "SETIF":
+		handleSETIF(w, parts)
+	case "DEL":
+		handleDEL(ctx, w, parts)
+	case "GETCHUNK":
+		handleGETCHUNK(w, parts)
+	case "STRLEN":
+		handleSTRLEN(w, parts)
+	case "INCR":
+		handleINCR(w, parts)
+	case "GETRESET":
+		handleGETRESET(w, parts)
+	case "APPLY":
+		handleAPPLY(w, parts)
+	case "DECRDEL":
+		handleDECRDEL(w, parts)
+	case "INCREX":
+		handleINCREX(w, parts)
+	case "SETMAX", "SETMIN":
+		handleSETMAX(w, parts)
 	case "QPUSH":
-		handleQPUSH(w, parts)
+		handleQPUSH(ctx, w, parts)
+	case "QPUSHMULTI":
+		handleQPUSHMULTI(w, parts)
 	case "QPOP":
-		handleQPOP(w, parts)
+		handleQPOP(ctx, w, parts)
+	case "QPUSHDELAYED":
+		handleQPUSHDELAYED(w, parts)
+	case "QCLAIM":
+		handleQCLAIM(w, parts)
+	case "QACK":
+		handleQACK(w, parts)
+	case "QCLAIMED":
+		handleQCLAIMED(w, parts)
+	case "TSADD":
+		handleTSADD(w, parts)
+	case "TSRANGE":
+		handleTSRANGE(w, parts)
+	case "QSTATS":
+		handleQSTATS(w, parts)
+	case "QLEN":
+		handleQLEN(w, parts)
+	case "LRANGE":
+		handleLRANGE(w, parts)
+	case "LDEDUP":
+		handleLDEDUP(w, parts)
+	case "LREMPREFIX":
+		handleLREMPREFIX(w, parts)
+	case "SWAP":
+		handleSWAP(w, parts)
+	case "QSWAP":
+		handleQSWAP(w, parts)
+	case "ROTATE":
+		handleROTATE(w, parts)
+	case "QCLOSE":
+		handleQCLOSE(w, parts)
+	case "QREPLACE":
+		handleQREPLACE(w, parts)
+	case "LPUSHGET":
+		handleLPUSHGET(w, parts)
+	case "QPEEK":
+		handleQPEEK(w, parts)
 	case "BQPOP":
-		handleBQPOP(w, parts) //Optional
+		handleBQPOP(ctx, w, parts, r.RemoteAddr) //Optional
+	case "LMOVE":
+		handleLMOVE(w, parts)
+	case "BLMOVE":
+		handleBLMOVE(ctx, w, parts, r.RemoteAddr)
+	case "BQDRAIN":
+		handleBQDRAIN(ctx, w, parts, r.RemoteAddr)
+	case "BLOCKED":
+		handleBLOCKED(w, parts)
+	case "EVICT":
+		handleEVICT(w, parts)
+	case "PIN":
+		handlePIN(w, parts, true)
+	case "UNPIN":
+		handlePIN(w, parts, false)
+	case "DUMP":
+		handleDUMP(w, parts)
+	case "RESTORE":
+		handleRESTORE(w, parts)
+	case "MIGRATE":
+		handleMIGRATE(w, parts)
+	case "SCAN":
+		handleSCAN(w, parts)
+	case "PUBLISH":
+		handlePUBLISH(w, parts)
+	case "PUBACK":
+		handlePUBACK(w, parts)
+	case "PUBSUB":
+		handlePUBSUB(w, parts)
+	case "SCHEDULE":
+		handleSCHEDULE(w, parts)
+	case "EXPIRED":
+		handleEXPIRED(w, parts)
+	case "MEMORY":
+		handleMEMORY(w, parts)
+	case "DEBUG":
+		handleDEBUG(w, parts)
+	case "OBJECT":
+		handleOBJECT(w, parts)
+	case "SORT":
+		handleSORT(w, parts)
+	case "SADD":
+		handleSADD(w, parts)
+	case "SMEMBERS":
+		handleSMEMBERS(w, parts)
+	case "SMOVE":
+		handleSMOVE(w, parts)
+	case "SRANDMEMBER":
+		handleSRANDMEMBER(w, parts)
+	case "HSET":
+		handleHSET(w, parts)
+	case "HGET":
+		handleHGET(w, parts)
+	case "HGETALL":
+		handleHGETALL(w, parts)
+	case "HRANDFIELD":
+		handleHRANDFIELD(w, parts)
+	case "SINTERSTORE", "SUNIONSTORE", "SDIFFSTORE":
+		handleSetOpStore(w, parts)
 	default:
 		sendErrorResponse(w, "invalid command")
 	}
 }
 
-func handleSET(w http.ResponseWriter, parts []string) {
+// handleStructuredCommand runs commands sent with an explicit key and JSON array of values.
+func handleStructuredCommand(w http.ResponseWriter, cmd Command) {
+	if cmd.Key == "" {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	switch strings.ToUpper(cmd.Command) {
+	case "QPUSH":
+		if len(cmd.Values) == 0 {
+			sendErrorResponse(w, "invalid command format")
+			return
+		}
+		if _, err := store.QPushContext(context.Background(), cmd.Key, cmd.Values); err != nil {
+			sendErrorResponse(w, err.Error())
+			return
+		}
+		sendOKResponse(w)
+	case "SET":
+		// SET with value_b64 stores arbitrary bytes
+		if cmd.ValueB64 == nil {
+			sendErrorResponse(w, "invalid command format")
+			return
+		}
+		value, err := base64.StdEncoding.DecodeString(*cmd.ValueB64)
+		if err != nil {
+			sendErrorResponse(w, "invalid base64 value")
+			return
+		}
+		if err := store.Set(cmd.Key, string(value), nil, ""); err != nil {
+			sendErrorResponse(w, err.Error())
+			return
+		}
+		sendOKResponse(w)
+	default:
+		sendErrorResponse(w, "invalid command")
+	}
+}
+
+func handleSET(ctx context.Context, w http.ResponseWriter, parts []string) {
 	if len(parts) < 3 {
 		sendErrorResponse(w, "invalid command format")
 		return
@@ -125,90 +629,499 @@ func handleSET(w http.ResponseWriter, parts []string) {
 	value := parts[2] // sets value
 
 	//Currently - empty initialization
-	var expiryTime time.Time
+	kv := &KeyValue{Kind: kindString, Value: []string{value}}
 	var condition string
 
-	if len(parts) >= 4 && strings.HasPrefix(parts[3], "EX") {
-		// extracts the number of seconds for the expiry time, converts it to an integer
-		// sets the expiryTime variable to the current time plus the specified duration.
-		seconds, err := strconv.Atoi(parts[3][2:])
-		if err != nil {
-			sendErrorResponse(w, "invalid expiry time")
+	// Options follow the value: EX<seconds>, NX or XX, and IDLE <seconds>
+	for i := 3; i < len(parts); i++ {
+		option := strings.ToUpper(parts[i])
+		switch {
+		case option == "NX" || option == "XX":
+			condition = option
+		case option == "IDLE":
+			if i+1 == len(parts) {
+				sendErrorResponse(w, "invalid command format")
+				return
+			}
+			i++
+			seconds, err := strconv.Atoi(parts[i])
+			if err != nil || seconds <= 0 {
+				sendErrorResponse(w, "invalid idle time")
+				return
+			}
+			kv.MaxIdle = time.Duration(seconds) * time.Second
+		case strings.HasPrefix(option, "EX"):
+			// extracts the number of seconds for the expiry time, converts it to an integer
+			// sets the expiryTime variable to the current time plus the specified duration.
+			seconds, err := strconv.Atoi(option[2:])
+			if err != nil {
+				sendErrorResponse(w, "invalid expiry time")
+				return
+			}
+			expiry := clock.Now().Add(time.Duration(seconds) * time.Second)
+			kv.ExpiryTime = &expiry
+		default:
+			sendErrorResponse(w, unexpectedToken(parts, i, "EX<seconds>, NX, XX, or IDLE"))
 			return
 		}
-		expiryTime = time.Now().Add(time.Duration(seconds) * time.Second)
 	}
-
-	if len(parts) == 5 {
-		condition = strings.ToUpper(parts[4])
-		if condition != "NX" && condition != "XX" {
-			sendErrorResponse(w, "invalid condition")
-			return
-		}
+	if err := store.set(ctx, key, kv, condition); err != nil {
+		sendErrorResponse(w, err.Error())
+		return
 	}
+
+	sendOKResponse(w)
+}
+
+// Set stores value at key with an optional expiry time. A condition of "NX" only
+// sets a missing key and "XX" only sets an existing one; "" always sets.
+func (store *KeyValueStore) Set(key, value string, expiryTime *time.Time, condition string) error {
+	return store.SetContext(context.Background(), key, value, expiryTime, condition)
+}
+
+// SetContext is Set, giving up if ctx is done before the store lock is free.
+func (store *KeyValueStore) SetContext(ctx context.Context, key, value string, expiryTime *time.Time, condition string) error {
+	return store.set(ctx, key, &KeyValue{
+		Kind:       kindString,
+		Value:      []string{value},
+		ExpiryTime: expiryTime,
+	}, condition)
+}
+
+// set stores kv at key subject to condition, as described for Set.
+func (store *KeyValueStore) set(ctx context.Context, key string, kv *KeyValue, condition string) error {
 	//Makes sure only one process can use the store at one time
 	// To Support COncurrent Operations
-	store.mutex.Lock() //write lock
+	if err := store.lockContext(ctx); err != nil {
+		return err
+	}
 
 	defer store.mutex.Unlock()
 
-	if condition == "NX" {
-		if _, ok := store.Data[key]; ok {
-			sendErrorResponse(w, "key already exists")
-			return
+	existing, exists := store.lookup(key)
+	if condition == "NX" && exists {
+		return errKeyExists
+	} else if condition == "XX" && !exists {
+		return errKeyMissing
+	}
+
+	if exists {
+		kv.Pinned = existing.Pinned // Overwriting a key keeps it pinned
+	}
+	store.insert(key, kv)
+	return nil
+}
+
+// MSetEx sets every key in values to its value, expiring ttls[key] from now,
+// under a single lock acquisition so no reader sees part of the batch.
+// As with SET, any existing value is overwritten and pinned keys stay pinned.
+func (store *KeyValueStore) MSetEx(keys, values []string, ttls []time.Duration) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := clock.Now()
+	for i, key := range keys {
+		expiry := now.Add(ttls[i])
+		kv := &KeyValue{Kind: kindString, Value: []string{values[i]}, ExpiryTime: &expiry}
+		if existing, ok := store.lookup(key); ok {
+			kv.Pinned = existing.Pinned
 		}
-	} else if condition == "XX" {
-		if _, ok := store.Data[key]; !ok {
-			sendErrorResponse(w, "key does not exist")
+		store.insert(key, kv)
+	}
+}
+
+// handleMSETEX handles MSETEX key seconds value [key seconds value ...]. Every
+// triple is validated before any key is written.
+func handleMSETEX(w http.ResponseWriter, parts []string) {
+	args := parts[1:]
+	if len(args)%3 != 0 {
+		sendErrorResponse(w, fmt.Sprintf("MSETEX requires key seconds value triples, got %s", plural(len(args), "argument")))
+		return
+	}
+
+	n := len(args) / 3
+	keys, values, ttls := make([]string, n), make([]string, n), make([]time.Duration, n)
+	for i := 0; i < n; i++ {
+		seconds, err := strconv.Atoi(args[3*i+1])
+		if err != nil || seconds <= 0 {
+			sendErrorResponse(w, "invalid expiry time")
 			return
 		}
+		keys[i], values[i], ttls[i] = args[3*i], args[3*i+2], time.Duration(seconds)*time.Second
 	}
 
-	store.Data[key] = &KeyValue{
-		Value:      []string{value},
-		ExpiryTime: &expiryTime,
+	store.MSetEx(keys, values, ttls)
+	sendOKResponse(w)
+}
+
+// handleSETNX handles SETNX key value, returning 1 if the key was set and 0 if it already existed.
+func handleSETNX(ctx context.Context, w http.ResponseWriter, parts []string) {
+	if len(parts) != 3 {
+		sendErrorResponse(w, "invalid command format")
+		return
 	}
 
-	sendOKResponse(w)
+	err := store.SetContext(ctx, parts[1], parts[2], nil, "NX")
+	switch err {
+	case nil:
+		sendIntegerResponse(w, 1)
+	case errKeyExists:
+		sendIntegerResponse(w, 0)
+	default:
+		sendErrorResponse(w, err.Error())
+	}
+}
+
+// Ensure creates an empty value of the given kind at key unless the key exists,
+// reporting whether it created one. An existing key of another kind is an error.
+func (store *KeyValueStore) Ensure(key, kind string) (bool, error) {
+	kv := &KeyValue{Kind: kind}
+	switch kind {
+	case kindString:
+		kv.Value = []string{""}
+	case kindList:
+	case kindSet:
+		kv.Set = make(map[string]struct{})
+	case kindHash:
+		kv.Hash = make(map[string]string)
+	default:
+		return false, errInvalidKind
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if existing, ok := store.lookup(key); ok {
+		if existing.Kind != kind {
+			return false, errWrongType
+		}
+		return false, nil
+	}
+	store.insert(key, kv)
+	return true, nil
+}
+
+// handleENSURE handles ENSURE key type, returning 1 if it created the key and 0 if it already existed.
+func handleENSURE(w http.ResponseWriter, parts []string) {
+	if len(parts) != 3 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	created, err := store.Ensure(parts[1], strings.ToLower(parts[2]))
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+	if created {
+		sendIntegerResponse(w, 1)
+		return
+	}
+	sendIntegerResponse(w, 0)
 }
 
 // retrieves the value associated with a given key from the data store, ensuring concurrent access using a mutex lock.
-func handleGET(w http.ResponseWriter, parts []string) {
-	if len(parts) != 2 {
+func handleGET(ctx context.Context, w http.ResponseWriter, parts []string) {
+	if len(parts) != 2 && len(parts) != 3 {
 		sendErrorResponse(w, "invalid command format")
 		return
 	}
 
 	key := parts[1]
 
+	// GET key WITHTTL returns the remaining lifetime alongside the value
+	if len(parts) == 3 {
+		if strings.ToUpper(parts[2]) != "WITHTTL" {
+			sendErrorResponse(w, unexpectedToken(parts, 2, "WITHTTL"))
+			return
+		}
+
+		value, expiryTime, err := store.GetWithExpiry(ctx, key)
+		switch err {
+		case nil:
+			sendObjectResponse(w, ValueWithTTL{Value: &value, TTL: ttlSeconds(expiryTime, clock.Now())})
+		case errKeyNotFound:
+			sendObjectResponse(w, ValueWithTTL{TTL: missingKey})
+		default:
+			sendErrorResponse(w, err.Error())
+		}
+		return
+	}
+
+	value, err := store.GetContext(ctx, key)
+	if err != nil {
+		sendReadError(w, err)
+		return
+	}
+
+	sendValueResponse(w, value)
+}
+
+// Get returns the value stored at key.
+func (store *KeyValueStore) Get(key string) (string, error) {
+	return store.GetContext(context.Background(), key)
+}
+
+// GetContext is Get, giving up if ctx is done before the store lock is free.
+func (store *KeyValueStore) GetContext(ctx context.Context, key string) (string, error) {
+	value, _, err := store.GetWithExpiry(ctx, key)
+	return value, err
+}
+
+// GetWithExpiry is GetContext that also returns the key's expiry time, or nil if it has none.
+func (store *KeyValueStore) GetWithExpiry(ctx context.Context, key string) (string, *time.Time, error) {
 	//Makes sure only one process can use the store at one time
 	// To Support Concurrent Operations
+	if err := store.rLockContext(ctx); err != nil {
+		return "", nil, err
+	}
+	defer store.mutex.RUnlock()
+
+	if kv, ok := store.lookup(key); ok {
+		// Queues read as their values joined, as they always have; sets and hashes do not
+		if kv.Kind != kindString && kv.Kind != kindList {
+			return "", nil, errWrongType
+		}
+		return strings.Join(kv.Value, " "), kv.ExpiryTime, nil // Convert the []string to a string
+	}
+
+	return "", nil, errKeyNotFound
+}
+
+// handleGETDEFAULT returns the value stored at key, or the given default when the key is missing.
+func handleGETDEFAULT(w http.ResponseWriter, parts []string) {
+	if len(parts) != 3 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	sendValueResponse(w, store.GetDefault(parts[1], parts[2]))
+}
+
+// GetDefault returns the value stored at key, or fallback when the key is missing or expired.
+func (store *KeyValueStore) GetDefault(key, fallback string) string {
+	value, err := store.Get(key)
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// handleMGET returns the values of the given keys in order, with null for missing keys.
+func handleMGET(w http.ResponseWriter, parts []string) {
+	if len(parts) < 2 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	sendObjectResponse(w, store.MGet(parts[1:]))
+}
+
+// handleMGETMAP returns an object mapping each of the given keys to its value, or null when missing.
+func handleMGETMAP(w http.ResponseWriter, parts []string) {
+	if len(parts) < 2 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	keys := parts[1:]
+	values := store.MGet(keys)
+
+	result := make(map[string]*string, len(keys))
+	for i, key := range keys {
+		result[key] = values[i]
+	}
+	sendObjectResponse(w, result)
+}
+
+// MGet returns the string values stored at keys, in order. Missing keys and
+// keys holding other kinds of value are returned as nil.
+func (store *KeyValueStore) MGet(keys []string) []*string {
 	store.mutex.RLock()
 	defer store.mutex.RUnlock()
 
-	if kv, ok := store.Data[key]; ok {
-		value := strings.Join(kv.Value, " ") // Convert the []string to a string
-		sendValueResponse(w, value)
+	values := make([]*string, len(keys))
+	for i, key := range keys {
+		if kv, ok := store.lookup(key); ok && kv.Kind == kindString {
+			value := strings.Join(kv.Value, " ")
+			values[i] = &value
+		}
+	}
+	return values
+}
+
+// handleDEL removes the given keys and returns how many existed.
+func handleDEL(ctx context.Context, w http.ResponseWriter, parts []string) {
+	if len(parts) < 2 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	deleted, err := store.DelContext(ctx, parts[1:]...)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendIntegerResponse(w, int64(deleted))
+}
+
+// Del removes keys and returns how many of them existed.
+func (store *KeyValueStore) Del(keys ...string) int {
+	deleted, _ := store.DelContext(context.Background(), keys...)
+	return deleted
+}
+
+// DelContext is Del, giving up if ctx is done before the store lock is free.
+func (store *KeyValueStore) DelContext(ctx context.Context, keys ...string) (int, error) {
+	if err := store.lockContext(ctx); err != nil {
+		return 0, err
+	}
+	defer store.mutex.Unlock()
+
+	return store.remove(keys, store.lazyFree), nil
+}
+
+// handleSWAP handles SWAP key1 key2.
+func handleSWAP(w http.ResponseWriter, parts []string) {
+	if len(parts) != 3 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	store.Swap(parts[1], parts[2])
+	sendOKResponse(w)
+}
+
+// Swap atomically exchanges the values stored at key1 and key2, along with
+// their types, TTLs and pins. If only one exists it is moved to the other key,
+// so neither key is ever seen missing part way through, as with three renames.
+// Clients blocked on either key are served from the queue it now holds.
+func (store *KeyValueStore) Swap(key1, key2 string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if key1 == key2 {
+		return
+	}
+	kv1, ok1 := store.lookup(key1)
+	kv2, ok2 := store.lookup(key2)
+
+	for _, move := range []struct {
+		key string
+		kv  *KeyValue
+		ok  bool
+	}{{key1, kv2, ok2}, {key2, kv1, ok1}} {
+		if !move.ok {
+			store.drop(move.key)
+			continue
+		}
+		store.insert(move.key, move.kv)
+		if move.kv.Kind == kindList {
+			store.serveWaiters(move.key, move.kv)
+		}
+	}
+}
+
+// handleSTRLEN returns the length of the string stored at key, or 0 when the key is missing.
+func handleSTRLEN(w http.ResponseWriter, parts []string) {
+	if len(parts) != 2 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	if kv, ok := store.lookup(parts[1]); ok {
+		sendIntegerResponse(w, int64(len(strings.Join(kv.Value, " "))))
+		return
+	}
+
+	sendIntegerResponse(w, 0)
+}
+
+// handleINCR increments the integer stored at key by one, starting from 0 when the key is missing,
+// and replies with the new value as a string, the form GET reads it in.
+func handleINCR(w http.ResponseWriter, parts []string) {
+	if len(parts) != 2 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	key := parts[1]
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	var current int64
+	kv, ok := store.lookup(key)
+	if ok {
+		n, err := strconv.ParseInt(strings.Join(kv.Value, " "), 10, 64)
+		if err != nil {
+			sendErrorResponse(w, "value is not an integer")
+			return
+		}
+		current = n
+	}
+
+	if current == math.MaxInt64 {
+		sendErrorResponse(w, "increment would overflow")
 		return
 	}
+	current++
+
+	if ok {
+		kv.Value = []string{strconv.FormatInt(current, 10)}
+		store.stamp(key, kv)
+	} else {
+		store.insert(key, &KeyValue{Kind: kindString, Value: []string{strconv.FormatInt(current, 10)}})
+	}
 
-	sendErrorResponse(w, "key not found")
+	sendValueResponse(w, strconv.FormatInt(current, 10))
 }
 
-func handleQPUSH(w http.ResponseWriter, parts []string) {
+func handleQPUSH(ctx context.Context, w http.ResponseWriter, parts []string) {
 	if len(parts) < 3 {
 		sendErrorResponse(w, "invalid command format")
 		return
 	}
 
 	key := parts[1]
+
+	// QPUSH key value... EX n expires the queue n seconds after the latest push
+	var ttl time.Duration
+	if len(parts) >= 5 && strings.ToUpper(parts[len(parts)-2]) == "EX" {
+		seconds, err := strconv.Atoi(parts[len(parts)-1])
+		if err != nil || seconds <= 0 {
+			sendErrorResponse(w, "invalid expiry time")
+			return
+		}
+		ttl = time.Duration(seconds) * time.Second
+		parts = parts[:len(parts)-2]
+	}
 	values := parts[2:]
 
-	queueChannel <- QueueOperation{
-		Operation: "QPUSH",
-		Key:       key,
-		Values:    values,
-		Response:  make(chan string),
+	// QPUSH key value... PRIORITY n pushes onto a priority queue
+	if len(parts) >= 5 && strings.ToUpper(parts[len(parts)-2]) == "PRIORITY" {
+		priority, err := strconv.Atoi(parts[len(parts)-1])
+		if err != nil {
+			sendErrorResponse(w, "invalid priority")
+			return
+		}
+
+		if _, err := store.qpushPriority(key, parts[2:len(parts)-2], priority, ttl); err != nil {
+			sendErrorResponse(w, err.Error())
+			return
+		}
+
+		sendOKResponse(w)
+		return
+	}
+
+	if _, err := store.qpush(ctx, key, values, ttl); err != nil {
+		sendErrorResponse(w, err.Error())
+		return
 	}
 
 	sendOKResponse(w)
@@ -216,7 +1129,7 @@ func handleQPUSH(w http.ResponseWriter, parts []string) {
 
 // OPTIONAL
 
-func handleQPOP(w http.ResponseWriter, parts []string) {
+func handleQPOP(ctx context.Context, w http.ResponseWriter, parts []string) {
 	if len(parts) != 2 {
 		sendErrorResponse(w, "invalid command format")
 		return
@@ -224,20 +1137,35 @@ func handleQPOP(w http.ResponseWriter, parts []string) {
 
 	key := parts[1]
 
-	responseChan := make(chan string)
+	value, err := store.QPopContext(ctx, key)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendValueResponse(w, value)
+}
 
-	queueChannel <- QueueOperation{
-		Operation: "QPOP",
-		Key:       key,
-		Response:  make(chan string),
+// handleLRANGE returns the queue elements between start and stop (inclusive) in insertion order.
+// Negative indexes count from the end of the queue, as in Redis.
+func handleLRANGE(w http.ResponseWriter, parts []string) {
+	if len(parts) != 4 {
+		sendErrorResponse(w, "invalid command format")
+		return
 	}
 
-	response := <-responseChan
-	if response != "" {
-		sendValueResponse(w, response)
-	} else {
-		sendErrorResponse(w, "queue is empty")
+	start, err := strconv.Atoi(parts[2])
+	if err != nil {
+		sendErrorResponse(w, "invalid index")
+		return
 	}
+	stop, err := strconv.Atoi(parts[3])
+	if err != nil {
+		sendErrorResponse(w, "invalid index")
+		return
+	}
+
+	sendListResponse(w, store.LRange(parts[1], start, stop))
 }
 
 // OPTIONAL HANDLER FUNCTION
@@ -245,114 +1173,183 @@ func handleQPOP(w http.ResponseWriter, parts []string) {
 // handleBQPOP handles the blocking queue behavior by allowing
 // the caller to wait for a certain period for a value to be available in the queue
 // or to immediately retrieve a value if the queue is non-empty.
-
-func handleBQPOP(w http.ResponseWriter, parts []string) {
-	if len(parts) != 2 {
+func handleBQPOP(ctx context.Context, w http.ResponseWriter, parts []string, addr string) {
+	if len(parts) != 2 && len(parts) != 3 {
 		sendErrorResponse(w, "invalid command format")
 		return
 	}
 
 	key := parts[1]
 
-	responseChan := make(chan string)
-	queueChannel <- QueueOperation{
-		Operation: "BQPOP",
-		Key:       key,
-		Response:  responseChan,
+	// Wait for 5 seconds by default; a timeout of 0 returns immediately like QPOP
+	timeout := 5 * time.Second
+	if len(parts) == 3 {
+		seconds, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil || seconds < 0 {
+			sendErrorResponse(w, "invalid timeout")
+			return
+		}
+		timeout = blockTimeout(seconds)
 	}
 
-	select {
-	case response := <-responseChan:
-		sendValueResponse(w, response)
-	case <-time.After(5 * time.Second): // Wait for 5 seconds and return if no response is received
-		sendErrorResponse(w, "timeout")
+	value, err := store.blockingPop(ctx, key, timeout, addr)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
 	}
+
+	sendValueResponse(w, value)
 }
 
-func handleQueueOperations() {
-	for {
-		op := <-queueChannel
+// QPush appends values to the queue stored at key, creating it if needed,
+// and returns the resulting queue length. Pushing onto a priority queue uses priority 0.
+func (store *KeyValueStore) QPush(key string, values []string) int {
+	length, _ := store.QPushContext(context.Background(), key, values)
+	return length
+}
 
-		switch op.Operation {
-		case "QPUSH":
-			handleQueuePush(op.Key, op.Values, op.Response)
-		case "QPOP":
-			handleQueuePop(op.Key, op.Response)
-		case "BQPOP":
-			handleBlockingQueuePop(op.Key, op.Response, op.ResponseWriter)
-		}
-	}
+// QPushContext is QPush, giving up if ctx is done before the store lock is free.
+func (store *KeyValueStore) QPushContext(ctx context.Context, key string, values []string) (int, error) {
+	return store.qpush(ctx, key, values, 0)
 }
 
-func handleQueuePush(key string, values []string, response chan string) {
-	store.mutex.Lock()
+// qpush is QPushContext that also sets the queue to expire ttl after the push
+// when ttl is positive, so a queue nobody pushes to disappears on its own.
+func (store *KeyValueStore) qpush(ctx context.Context, key string, values []string, ttl time.Duration) (int, error) {
+	if err := store.lockContext(ctx); err != nil {
+		return 0, err
+	}
 	defer store.mutex.Unlock()
 
-	if kv, ok := store.Data[key]; ok {
-		kv.Value = append(kv.Value, values...)
-	} else {
-		store.Data[key] = &KeyValue{
-			Value: values,
+	kv, ok := store.lookup(key)
+	if ok && kv.Kind != kindList {
+		return 0, errWrongType
+	}
+	admitted, err := store.admit(kv, len(values))
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		if admitted == 0 {
+			return 0, nil
 		}
+		kv = &KeyValue{Kind: kindList}
+		store.insert(key, kv)
 	}
 
-	response <- ""
-}
-
-func handleQueuePop(key string, response chan string) {
-	store.mutex.Lock()
-	defer store.mutex.Unlock()
+	if kv.Priority != nil {
+		for _, value := range values[:admitted] {
+			kv.Priority.push(value, 0)
+		}
+	} else {
+		kv.Value = append(kv.Value, values[:admitted]...)
+	}
+	store.trimQueue(kv)
+	kv.refreshTTL(ttl)
+	store.recordQueue(key, admitted, 0)
 
-	if kv, ok := store.Data[key]; ok {
-		values := kv.Value
+	length := kv.queueLen()
+	store.serveWaiters(key, kv)
+	return length, nil
+}
 
-		if len(values) > 0 {
-			value := values[len(values)-1]
-			values = values[:len(values)-1]
-			store.Data[key].Value = values
+// QPop removes and returns the last inserted value from the queue stored at key.
+// For priority queues it returns the highest-priority value, oldest first.
+// A missing or expired key gives errKeyNotFound and a queue with no visible
+// values errQueueEmpty, or errQueueClosed once a closed queue is drained.
+// Popping the last value deletes the key, unless the queue is closed.
+func (store *KeyValueStore) QPop(key string) (string, error) {
+	return store.QPopContext(context.Background(), key)
+}
 
-			response <- value
-			return
-		}
+// QPopContext is QPop, giving up if ctx is done before the store lock is free.
+func (store *KeyValueStore) QPopContext(ctx context.Context, key string) (string, error) {
+	if err := store.lockContext(ctx); err != nil {
+		return "", err
 	}
+	defer store.mutex.Unlock()
 
-	response <- "queue is empty"
+	kv, ok := store.lookup(key)
+	if !ok {
+		return "", errKeyNotFound
+	}
+	if kv.Kind != kindList {
+		return "", errWrongType
+	}
+	value, ok := kv.pop(clock.Now())
+	if !ok && kv.exhausted() {
+		return "", errQueueClosed
+	}
+	if !ok {
+		return "", errQueueEmpty
+	}
+	store.recordQueue(key, 0, 1)
+	store.dropIfDrained(key, kv)
+	return value, nil
 }
 
-func handleBlockingQueuePop(key string, response chan string, w http.ResponseWriter) {
-	store.mutex.Lock()
+// dropIfDrained deletes the queue at key once its last value has been taken,
+// including values still delayed, so that it is not left behind empty. Queues
+// made empty some other way, such as by QSWAP, are kept, as are closed queues,
+// so they stay closed. The caller must hold the store write lock.
+func (store *KeyValueStore) dropIfDrained(key string, kv *KeyValue) {
+	if kv.Kind == kindList && kv.queueLen() == 0 && len(kv.Delayed) == 0 && !kv.closed {
+		store.drop(key)
+	}
+}
 
-	if kv, ok := store.Data[key]; ok {
-		values := kv.Value
+// pop removes the next value from the queue: the last inserted value,
+// or for priority queues the highest-priority value. The caller must hold the store write lock.
+func (kv *KeyValue) pop(now time.Time) (string, bool) {
+	kv.promoteDelayed(now)
 
-		if len(values) > 0 {
-			value := values[len(values)-1]
-			values = values[:len(values)-1]
-			store.Data[key].Value = values
+	if kv.Priority != nil {
+		return kv.Priority.pop()
+	}
 
-			response <- value
-			store.mutex.Unlock()
-			return
-		}
+	if len(kv.Value) == 0 {
+		return "", false
 	}
 
-	store.mutex.Unlock()
+	value := kv.Value[len(kv.Value)-1]
+	kv.Value = kv.Value[:len(kv.Value)-1]
+	return value, true
+}
+
+// queueLen returns the number of visible values in the queue.
+func (kv *KeyValue) queueLen() int {
+	if kv.Priority != nil {
+		return kv.Priority.Len()
+	}
+	return len(kv.Value)
+}
 
-	// If the queue is empty, wait for a new value to be pushed
-	queueListeners.Add(1)
-	go func() {
-		defer queueListeners.Done()
+// LRange returns a copy of the queue elements between start and stop (inclusive).
+func (store *KeyValueStore) LRange(key string, start, stop int) []string {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
 
-		// Wait for a signal on the response channel
-		value := <-response
-		response <- value
-	}()
+	kv, ok := store.lookup(key)
+	if !ok {
+		return nil
+	}
 
-	// Wait for a response or timeout after 5 seconds
-	select {
-	case value := <-response:
-		sendValueResponse(w, value)
-	case <-time.After(5 * time.Second):
-		sendErrorResponse(w, "timeout")
+	length := len(kv.Value)
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
 	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop {
+		return nil
+	}
+
+	return append([]string(nil), kv.Value[start:stop+1]...)
 }
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestHandleSET(t *testing.T) {
@@ -52,3 +58,248 @@ func TestHandleGET(t *testing.T) {
 	// TODO: Add more assertions to test the behavior of the handleGET function
 	// For example, you can check if the correct value is returned for the specified key.
 }
+
+func TestReadStatusCodes(t *testing.T) {
+	fake := useFakeClock(t)
+	sendCommand(t, "SET status-present value")
+	sendCommand(t, "SET status-expiring value EX1")
+	fake.Advance(2 * time.Second)
+	sendCommand(t, "HSET status-hash field value")
+
+	tests := []struct {
+		command string
+		status  int
+	}{
+		{"GET status-present", http.StatusOK},
+		{"GET status-missing", http.StatusNotFound},
+		{"GET status-expiring", http.StatusNotFound},
+		{"HGET status-hash other", http.StatusNotFound},
+		{"GETCHUNK status-missing 0 10", http.StatusNotFound},
+		{"DUMP status-missing", http.StatusNotFound},
+		{"GET", http.StatusBadRequest},
+		{"GET status-present EXTRA", http.StatusBadRequest},
+		{"NOSUCHCOMMAND status-present", http.StatusBadRequest},
+		{"HGET status-present field", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		rr := sendCommand(t, test.command)
+		if rr.Code != test.status {
+			t.Errorf("%s: expected status code %d, but got %d", test.command, test.status, rr.Code)
+		}
+		if test.status != http.StatusOK {
+			var response ErrorResponse
+			decodeResponse(t, rr, &response)
+			if response.Error == "" {
+				t.Errorf("%s: expected a JSON error body", test.command)
+			}
+		}
+	}
+}
+
+// sendRequest posts the raw JSON body to handleRequest and returns the recorded response.
+func sendRequest(t *testing.T, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req, err := http.NewRequest("POST", "/", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handleRequest(rr, req)
+	return rr
+}
+
+// sendCommand wraps a plain command string in a JSON body and sends it.
+func sendCommand(t *testing.T, command string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	body, err := json.Marshal(Command{Command: command})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sendRequest(t, string(body))
+}
+
+// decodeResponse decodes the recorded JSON response body into v.
+func decodeResponse(t *testing.T, rr *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+
+	if err := json.NewDecoder(rr.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQPUSHValuesArray(t *testing.T) {
+	// Values containing spaces and commas are sent as a JSON array
+	rr := sendRequest(t, `{"command": "QPUSH", "key": "batch-queue", "values": ["a b", "c,d", " e "]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+
+	// Read the queue back with LRANGE and check every value survived intact
+	rr = sendCommand(t, "LRANGE batch-queue 0 -1")
+
+	var response ListResponse
+	decodeResponse(t, rr, &response)
+
+	expected := []string{"a b", "c,d", " e "}
+	if !reflect.DeepEqual(response.Value, expected) {
+		t.Errorf("Expected %q, but got %q", expected, response.Value)
+	}
+}
+
+func TestGETDEFAULT(t *testing.T) {
+	sendCommand(t, "SET getdefault-present cached")
+
+	store.mutex.Lock()
+	expired := time.Now().Add(-time.Second)
+	store.Data["getdefault-expired"] = &KeyValue{Kind: kindString, Value: []string{"stale"}, ExpiryTime: &expired}
+	store.mutex.Unlock()
+
+	tests := []struct {
+		key      string
+		expected string
+	}{
+		{"getdefault-present", "cached"},
+		{"getdefault-absent", "fallback"},
+		{"getdefault-expired", "fallback"},
+	}
+	for _, test := range tests {
+		rr := sendCommand(t, "GETDEFAULT "+test.key+" fallback")
+
+		var response ValueResponse
+		decodeResponse(t, rr, &response)
+		if rr.Code != http.StatusOK || response.Value != test.expected {
+			t.Errorf("%s: expected %q, but got %d %q", test.key, test.expected, rr.Code, response.Value)
+		}
+	}
+}
+
+func TestSETNX(t *testing.T) {
+	var first, second IntegerResponse
+	decodeResponse(t, sendCommand(t, "SETNX setnx-key original"), &first)
+	decodeResponse(t, sendCommand(t, "SETNX setnx-key replacement"), &second)
+
+	if first.Value != 1 || second.Value != 0 {
+		t.Errorf("Expected replies 1 then 0, but got %d then %d", first.Value, second.Value)
+	}
+
+	var value ValueResponse
+	decodeResponse(t, sendCommand(t, "GET setnx-key"), &value)
+	if value.Value != "original" {
+		t.Errorf("Expected the original value to be kept, but got %q", value.Value)
+	}
+}
+
+func TestMGETMAP(t *testing.T) {
+	sendCommand(t, "SET mget-a alpha")
+	sendCommand(t, "SET mget-b beta")
+
+	var keyed struct {
+		Value map[string]*string `json:"value"`
+	}
+	decodeResponse(t, sendCommand(t, "MGETMAP mget-a mget-missing mget-b"), &keyed)
+
+	if len(keyed.Value) != 3 {
+		t.Fatalf("Expected 3 keys, but got %v", keyed.Value)
+	}
+	if value := keyed.Value["mget-a"]; value == nil || *value != "alpha" {
+		t.Errorf("Expected mget-a to map to %q, but got %v", "alpha", value)
+	}
+	if value := keyed.Value["mget-b"]; value == nil || *value != "beta" {
+		t.Errorf("Expected mget-b to map to %q, but got %v", "beta", value)
+	}
+	if value, ok := keyed.Value["mget-missing"]; !ok || value != nil {
+		t.Errorf("Expected mget-missing to be present as null, but got %v", value)
+	}
+
+	// The positional form agrees
+	var positional struct {
+		Value []*string `json:"value"`
+	}
+	decodeResponse(t, sendCommand(t, "MGET mget-a mget-missing mget-b"), &positional)
+	if len(positional.Value) != 3 || positional.Value[1] != nil || *positional.Value[2] != "beta" {
+		t.Errorf("Unexpected MGET result %v", positional.Value)
+	}
+}
+
+func TestBinaryValueRoundTrip(t *testing.T) {
+	value := []byte{'b', 'i', 'n', 0x00, 0xFF, 0xFE, '\n', ' ', 0x00}
+	encoded := base64.StdEncoding.EncodeToString(value)
+
+	rr := sendRequest(t, `{"command": "SET", "key": "binary-key", "value_b64": "`+encoded+`"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var response BinaryValueResponse
+	decodeResponse(t, sendCommand(t, "GET binary-key"), &response)
+
+	decoded, err := base64.StdEncoding.DecodeString(response.ValueB64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, value) {
+		t.Errorf("Expected %v, but got %v", value, decoded)
+	}
+}
+
+func TestENSURECreatesOnce(t *testing.T) {
+	var created IntegerResponse
+	decodeResponse(t, sendCommand(t, "ENSURE ensure-hash hash"), &created)
+	if created.Value != 1 {
+		t.Errorf("Expected the first ENSURE to create the key, but got %d", created.Value)
+	}
+	sendCommand(t, "HSET ensure-hash field value")
+
+	decodeResponse(t, sendCommand(t, "ENSURE ensure-hash HASH"), &created)
+	if created.Value != 0 {
+		t.Errorf("Expected the second ENSURE to be a no-op, but got %d", created.Value)
+	}
+	var field ValueResponse
+	decodeResponse(t, sendCommand(t, "HGET ensure-hash field"), &field)
+	if field.Value != "value" {
+		t.Errorf("Expected the second ENSURE to leave the hash intact, but got %q", field.Value)
+	}
+
+	for command, expected := range map[string]string{
+		"ENSURE ensure-hash list":  errWrongType.Error(),
+		"ENSURE ensure-other zset": errInvalidKind.Error(),
+	} {
+		var response ErrorResponse
+		decodeResponse(t, sendCommand(t, command), &response)
+		if response.Error != expected {
+			t.Errorf("%s: expected %q, but got %q", command, expected, response.Error)
+		}
+	}
+}
+
+func TestSWAPExchangesValuesAndTTLs(t *testing.T) {
+	useFakeClock(t)
+	testStore := &KeyValueStore{Data: make(map[string]*KeyValue)}
+	expiry := clock.Now().Add(time.Minute)
+	testStore.Set("front", "a", &expiry, "")
+	testStore.QPush("back", []string{"b"})
+
+	testStore.Swap("front", "back")
+
+	if values := testStore.LRange("front", 0, -1); !reflect.DeepEqual(values, []string{"b"}) {
+		t.Errorf("Expected front to hold the queue [b], but got %v", values)
+	}
+	if value, expiryTime, err := testStore.GetWithExpiry(context.Background(), "back"); err != nil || value != "a" || expiryTime == nil || !expiryTime.Equal(expiry) {
+		t.Errorf("Expected back to hold a expiring at %v, but got %q, %v, %v", expiry, value, expiryTime, err)
+	}
+	if ttl := testStore.PExpireTime("front"); ttl != noExpiry {
+		t.Errorf("Expected front to take back's lack of a TTL, but got %d", ttl)
+	}
+
+	// Swapping with a missing key moves the value over
+	testStore.Swap("back", "spare")
+	if _, err := testStore.Get("back"); err != errKeyNotFound {
+		t.Errorf("Expected back to be deleted, but got %v", err)
+	}
+	if value, _ := testStore.Get("spare"); value != "a" {
+		t.Errorf("Expected spare to hold a, but got %q", value)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MemoryStats holds aggregate memory estimates for the whole keyspace.
+type MemoryStats struct {
+	TotalBytes     int64          `json:"total_bytes"`      // Estimated bytes used by all keys and values
+	OverheadBytes  int64          `json:"overhead_bytes"`   // Part of TotalBytes spent on per-key bookkeeping
+	OverheadPerKey int64          `json:"overhead_per_key"` // Fixed estimate charged for every key
+	Keys           int            `json:"keys"`
+	KeysByKind     map[string]int `json:"keys_by_kind"`
+	MaxMemory      int64          `json:"maxmemory"`    // 0 when eviction is disabled
+	UsedPercent    float64        `json:"used_percent"` // TotalBytes as a percentage of MaxMemory, 0 without a limit
+}
+
+// ForEach calls fn for every key that has not expired or gone idle, stopping early if fn
+// returns false. It holds the read lock, so fn must not call back into the store.
+func (store *KeyValueStore) ForEach(fn func(key string, kv *KeyValue) bool) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	now := clock.Now()
+	for key, kv := range store.Data {
+		if kv.isExpired() || store.isIdle(kv, now) {
+			continue
+		}
+		if !fn(key, kv) {
+			return
+		}
+	}
+}
+
+// MemoryUsage estimates the bytes used by key and its value.
+func (store *KeyValueStore) MemoryUsage(key string) (int64, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	kv, ok := store.lookup(key)
+	if !ok {
+		return 0, errKeyNotFound
+	}
+	return entrySize(key, kv), nil
+}
+
+// MemoryStats estimates the memory used by the whole keyspace.
+func (store *KeyValueStore) MemoryStats() MemoryStats {
+	stats := MemoryStats{
+		OverheadPerKey: entryOverhead,
+		KeysByKind:     make(map[string]int),
+		MaxMemory:      store.maxMemory,
+	}
+
+	store.ForEach(func(key string, kv *KeyValue) bool {
+		stats.TotalBytes += entrySize(key, kv)
+		stats.Keys++
+		stats.KeysByKind[kv.Kind]++
+		return true
+	})
+
+	stats.OverheadBytes = int64(stats.Keys) * entryOverhead
+	if stats.MaxMemory > 0 {
+		stats.UsedPercent = float64(stats.TotalBytes) / float64(stats.MaxMemory) * 100
+	}
+	return stats
+}
+
+// KeySize is an entry in the reply to MEMORY TOPKEYS.
+type KeySize struct {
+	Key   string `json:"key"`
+	Kind  string `json:"type"`
+	Bytes int64  `json:"bytes"` // Estimated as by MEMORY USAGE
+}
+
+// sizeHeap is a heap of keys with the smallest on top, so the largest n keys
+// can be kept while scanning the keyspace. It implements heap.Interface.
+type sizeHeap []KeySize
+
+func (h sizeHeap) Len() int            { return len(h) }
+func (h sizeHeap) Less(i, j int) bool  { return h[i].Bytes < h[j].Bytes }
+func (h sizeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *sizeHeap) Push(x interface{}) { *h = append(*h, x.(KeySize)) }
+
+func (h *sizeHeap) Pop() interface{} {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// TopKeys returns up to n keys matching the glob pattern with the largest
+// estimated memory footprint, largest first. It scans the whole keyspace under
+// the read lock, keeping only the largest n in a heap. The heap grows as keys
+// are found, so a huge n costs no more than the keyspace.
+func (store *KeyValueStore) TopKeys(n int, pattern string) []KeySize {
+	var largest sizeHeap
+	store.ForEach(func(key string, kv *KeyValue) bool {
+		if pattern != "" && !globMatch(pattern, key) {
+			return true
+		}
+		size := entrySize(key, kv)
+		if len(largest) < n {
+			heap.Push(&largest, KeySize{Key: key, Kind: kv.Kind, Bytes: size})
+		} else if size > largest[0].Bytes {
+			largest[0] = KeySize{Key: key, Kind: kv.Kind, Bytes: size}
+			heap.Fix(&largest, 0)
+		}
+		return true
+	})
+
+	keys := make([]KeySize, len(largest))
+	for i := len(keys) - 1; i >= 0; i-- {
+		keys[i] = heap.Pop(&largest).(KeySize)
+	}
+	return keys
+}
+
+// handleMEMORY handles MEMORY USAGE key, MEMORY STATS and MEMORY TOPKEYS n [MATCH pattern].
+func handleMEMORY(w http.ResponseWriter, parts []string) {
+	if len(parts) < 2 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	switch strings.ToUpper(parts[1]) {
+	case "USAGE":
+		if len(parts) != 3 {
+			sendErrorResponse(w, "invalid command format")
+			return
+		}
+
+		size, err := store.MemoryUsage(parts[2])
+		if err != nil {
+			sendReadError(w, err)
+			return
+		}
+
+		sendIntegerResponse(w, size)
+	case "STATS":
+		if len(parts) != 2 {
+			sendErrorResponse(w, "invalid command format")
+			return
+		}
+
+		sendObjectResponse(w, store.MemoryStats())
+	case "TOPKEYS":
+		if len(parts) != 3 && len(parts) != 5 {
+			sendErrorResponse(w, "invalid command format")
+			return
+		}
+
+		n, err := strconv.Atoi(parts[2])
+		if err != nil || n <= 0 {
+			sendErrorResponse(w, "invalid count")
+			return
+		}
+
+		var pattern string
+		if len(parts) == 5 {
+			if !strings.EqualFold(parts[3], "MATCH") {
+				sendErrorResponse(w, unexpectedToken(parts, 3, "MATCH pattern"))
+				return
+			}
+			pattern = parts[4]
+		}
+
+		sendObjectResponse(w, store.TopKeys(n, pattern))
+	default:
+		sendErrorResponse(w, "invalid command")
+	}
+}
+
+// handleMetrics serves the memory estimates as gauges in the Prometheus text format.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if !allowRoute(w, "MEMORY") {
+		return
+	}
+	stats := store.MemoryStats()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP greedy_memory_used_bytes Estimated bytes used by all keys and values.")
+	fmt.Fprintln(w, "# TYPE greedy_memory_used_bytes gauge")
+	fmt.Fprintf(w, "greedy_memory_used_bytes %d\n", stats.TotalBytes)
+
+	fmt.Fprintln(w, "# HELP greedy_memory_overhead_bytes Estimated bytes spent on per-key bookkeeping.")
+	fmt.Fprintln(w, "# TYPE greedy_memory_overhead_bytes gauge")
+	fmt.Fprintf(w, "greedy_memory_overhead_bytes %d\n", stats.OverheadBytes)
+
+	fmt.Fprintln(w, "# HELP greedy_maxmemory_bytes Configured memory limit, 0 when eviction is disabled.")
+	fmt.Fprintln(w, "# TYPE greedy_maxmemory_bytes gauge")
+	fmt.Fprintf(w, "greedy_maxmemory_bytes %d\n", stats.MaxMemory)
+
+	fmt.Fprintln(w, "# HELP greedy_memory_used_percent Estimated usage as a percentage of maxmemory.")
+	fmt.Fprintln(w, "# TYPE greedy_memory_used_percent gauge")
+	fmt.Fprintf(w, "greedy_memory_used_percent %g\n", stats.UsedPercent)
+
+	fmt.Fprintln(w, "# HELP greedy_keys Number of keys by kind.")
+	fmt.Fprintln(w, "# TYPE greedy_keys gauge")
+	kinds := make([]string, 0, len(stats.KeysByKind))
+	for kind := range stats.KeysByKind {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Fprintf(w, "greedy_keys{kind=%q} %d\n", kind, stats.KeysByKind[kind])
+	}
+}
+
+// handleReport streams a CSV capacity report with one row per live key:
+//
+//	GET /report.csv[?limit=n]
+//
+// Columns are key, type, approximate bytes and remaining TTL in seconds (-1
+// without a TTL). Rows are collected under the read lock and written once it
+// is released, so a slow client does not hold up writers; only the rows, not
+// the values, are kept in memory. Rows are in no particular order; limit caps
+// how many are sent.
+func handleReport(w http.ResponseWriter, r *http.Request) {
+	if !allowRoute(w, "MEMORY") {
+		return
+	}
+	limit := -1
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			sendErrorResponse(w, "invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	now := clock.Now()
+	rows := [][]string{}
+	store.ForEach(func(key string, kv *KeyValue) bool {
+		if len(rows) == limit {
+			return false
+		}
+		rows = append(rows, []string{
+			key,
+			kv.Kind,
+			strconv.FormatInt(entrySize(key, kv), 10),
+			strconv.FormatInt(ttlSeconds(kv.ExpiryTime, now), 10),
+		})
+		return true
+	})
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="report.csv"`)
+
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"key", "type", "bytes", "ttl"})
+	writer.WriteAll(rows)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/csv"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMEMORYSTATSGrowsWithLargeValue(t *testing.T) {
+	var before, after struct {
+		Value MemoryStats `json:"value"`
+	}
+	decodeResponse(t, sendCommand(t, "MEMORY STATS"), &before)
+
+	sendCommand(t, "SET memory-large "+strings.Repeat("x", 64*1024))
+
+	decodeResponse(t, sendCommand(t, "MEMORY STATS"), &after)
+	if after.Value.TotalBytes < before.Value.TotalBytes+64*1024 {
+		t.Errorf("Expected total to grow by at least 64KiB from %d, but got %d", before.Value.TotalBytes, after.Value.TotalBytes)
+	}
+	if after.Value.KeysByKind[kindString] == 0 {
+		t.Errorf("Expected string keys to be counted, but got %v", after.Value.KeysByKind)
+	}
+
+	var usage IntegerResponse
+	decodeResponse(t, sendCommand(t, "MEMORY USAGE memory-large"), &usage)
+	if usage.Value < 64*1024 {
+		t.Errorf("Expected usage of at least 64KiB, but got %d", usage.Value)
+	}
+
+	rr := httptest.NewRecorder()
+	handleMetrics(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "greedy_memory_used_bytes ") {
+		t.Errorf("Expected metrics to include the used bytes gauge, but got %q", rr.Body.String())
+	}
+}
+
+func TestReportCSVListsKeys(t *testing.T) {
+	sendCommand(t, "SET report-key hello EX100")
+
+	rr := httptest.NewRecorder()
+	handleReport(rr, httptest.NewRequest("GET", "/report.csv", nil))
+
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"key", "type", "bytes", "ttl"}; !reflect.DeepEqual(records[0], expected) {
+		t.Errorf("Expected header %v, but got %v", expected, records[0])
+	}
+
+	var row []string
+	for _, record := range records[1:] {
+		if record[0] == "report-key" {
+			row = record
+		}
+	}
+	size, _ := store.MemoryUsage("report-key")
+	if expected := []string{"report-key", kindString, strconv.FormatInt(size, 10), "100"}; !reflect.DeepEqual(row, expected) {
+		t.Errorf("Expected row %v, but got %v", expected, row)
+	}
+
+	rr = httptest.NewRecorder()
+	handleReport(rr, httptest.NewRequest("GET", "/report.csv?limit=1", nil))
+	if records, _ := csv.NewReader(rr.Body).ReadAll(); len(records) != 2 {
+		t.Errorf("Expected the header and one row with limit=1, but got %d records", len(records))
+	}
+}
+
+// stalledWriter is a ResponseWriter whose writes wait until release is closed,
+// like a client that stops reading.
+type stalledWriter struct {
+	*httptest.ResponseRecorder
+	release chan struct{}
+}
+
+func (w stalledWriter) Write(b []byte) (int, error) {
+	<-w.release
+	return w.ResponseRecorder.Write(b)
+}
+
+func TestReportCSVDoesNotHoldLockWhileWriting(t *testing.T) {
+	// Enough rows to overflow the CSV writer's buffer
+	for i := 0; i < 200; i++ {
+		store.Set("report-stalled-"+strconv.Itoa(i)+strings.Repeat("x", 40), "value", nil, "")
+	}
+
+	w := stalledWriter{httptest.NewRecorder(), make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		handleReport(w, httptest.NewRequest("GET", "/report.csv", nil))
+		close(done)
+	}()
+
+	// Give the report time to collect its rows and stall writing them
+	time.Sleep(20 * time.Millisecond)
+	locked := make(chan struct{})
+	go func() {
+		store.mutex.Lock()
+		store.mutex.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Error("Expected writers to run while the report is being sent")
+	}
+
+	close(w.release)
+	<-done
+}
+
+func TestMEMORYTOPKEYSReportsLargestFirst(t *testing.T) {
+	for i, size := range []int{100, 5000, 20, 900, 30000} {
+		sendCommand(t, "SET topkeys-"+strconv.Itoa(i)+" "+strings.Repeat("x", size))
+	}
+	sendCommand(t, "SADD topkeys-set a b c")
+
+	var response struct {
+		Value []KeySize `json:"value"`
+	}
+	decodeResponse(t, sendCommand(t, "MEMORY TOPKEYS 3 MATCH topkeys-*"), &response)
+
+	var keys []string
+	for i, key := range response.Value {
+		keys = append(keys, key.Key)
+		if key.Kind != kindString {
+			t.Errorf("Expected %s to be reported as a string, but got %q", key.Key, key.Kind)
+		}
+		if i > 0 && key.Bytes > response.Value[i-1].Bytes {
+			t.Errorf("Expected descending sizes, but got %+v", response.Value)
+		}
+	}
+	if expected := []string{"topkeys-4", "topkeys-1", "topkeys-3"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected %v, but got %v", expected, keys)
+	}
+	if response.Value[0].Bytes < 30000 {
+		t.Errorf("Expected the largest key to be at least 30000 bytes, but got %d", response.Value[0].Bytes)
+	}
+
+	decodeResponse(t, sendCommand(t, "MEMORY TOPKEYS "+strconv.Itoa(math.MaxInt)+" MATCH topkeys-*"), &response)
+	if len(response.Value) != 6 {
+		t.Errorf("Expected a huge count to return all 6 matching keys, but got %+v", response.Value)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// namespaceHeader isolates a request's keys: every key it names is stored under
+// "<namespace>:" and the prefix is stripped from key names in its replies.
+const namespaceHeader = "X-Key-Namespace"
+
+var errInvalidNamespace = errors.New("invalid namespace")
+
+// keySpec locates the key arguments of a command, as in Redis: the keys are
+// parts[first], parts[first+step], ... up to parts[last], where a last of -1
+// means the final argument. When sub is set the spec only applies to that
+// subcommand, such as MEMORY USAGE key.
+type keySpec struct {
+	first, last, step int
+	sub               string
+}
+
+// commandKeys lists the key arguments of every command that takes keys.
+// Commands missing from it, such as PUBLISH or PING, are passed through unchanged.
+var commandKeys = map[string]keySpec{
+	"SET":           {1, 1, 1, ""},
+	"SETNX":         {1, 1, 1, ""},
+	"MSETEX":        {1, -1, 3, ""},
+	"ENSURE":        {1, 1, 1, ""},
+	"GET":           {1, 1, 1, ""},
+	"GETCHUNK":      {1, 1, 1, ""},
+	"UNLINK":        {1, -1, 1, ""},
+	"MGET":          {1, -1, 1, ""},
+	"MGETMAP":       {1, -1, 1, ""},
+	"GETDEFAULT":    {1, 1, 1, ""},
+	"EXPIRETIME":    {1, 1, 1, ""},
+	"EXPIREATMULTI": {2, -1, 1, ""},
+	"PEXPIRETIME":   {1, 1, 1, ""},
+	"GETVER":        {1, 1, 1, ""},
+	"WATCHGET":      {1, 1, 1, ""},
+	"SETVER":        {1, 1, 1, ""},
+	"SETIF":         {1, 1, 1, ""},
+	"GETORLOCK":     {1, 1, 1, ""},
+	"LOCKEXTEND":    {1, 1, 1, ""},
+	"DEL":           {1, -1, 1, ""},
+	"STRLEN":        {1, 1, 1, ""},
+	"INCR":          {1, 1, 1, ""},
+	"INCREX":        {1, 1, 1, ""},
+	"GETRESET":      {1, 1, 1, ""},
+	"APPLY":         {1, 1, 1, ""},
+	"DECRDEL":       {1, 1, 1, ""},
+	"SETMAX":        {1, 1, 1, ""},
+	"SETMIN":        {1, 1, 1, ""},
+	"QPUSH":         {1, 1, 1, ""},
+	"QPUSHMULTI":    {2, -1, 1, ""},
+	"QPOP":          {1, 1, 1, ""},
+	"QPUSHDELAYED":  {1, 1, 1, ""},
+	"QLEN":          {1, 1, 1, ""},
+	"QSTATS":        {1, 1, 1, ""},
+	"QCLAIM":        {1, 1, 1, ""},
+	"QACK":          {1, 1, 1, ""},
+	"QCLAIMED":      {1, 1, 1, ""},
+	"LRANGE":        {1, 1, 1, ""},
+	"LREMPREFIX":    {1, 1, 1, ""},
+	"LDEDUP":        {1, 1, 1, ""},
+	"QSWAP":         {1, 2, 1, ""},
+	"ROTATE":        {1, 1, 1, ""},
+	"QCLOSE":        {1, 1, 1, ""},
+	"SWAP":          {1, 2, 1, ""},
+	"QREPLACE":      {1, 1, 1, ""},
+	"QPEEK":         {1, 1, 1, ""},
+	"LPUSHGET":      {1, 1, 1, ""},
+	"BQPOP":         {1, 1, 1, ""},
+	"LMOVE":         {1, 2, 1, ""},
+	"BLMOVE":        {1, 2, 1, ""},
+	"BQDRAIN":       {1, 1, 1, ""},
+	"PIN":           {1, 1, 1, ""},
+	"UNPIN":         {1, 1, 1, ""},
+	"DUMP":          {1, 1, 1, ""},
+	"RESTORE":       {1, 1, 1, ""},
+	"MIGRATE":       {3, 3, 1, ""},
+	"MEMORY":        {2, 2, 1, "USAGE"},
+	"DEBUG":         {2, 2, 1, "OBJECT"},
+	"OBJECT":        {2, 2, 1, ""},
+	"SORT":          {1, 1, 1, ""},
+	"SADD":          {1, 1, 1, ""},
+	"SMEMBERS":      {1, 1, 1, ""},
+	"SMOVE":         {1, 2, 1, ""},
+	"SRANDMEMBER":   {1, 1, 1, ""},
+	"TSADD":         {1, 1, 1, ""},
+	"TSRANGE":       {1, 1, 1, ""},
+	"HSET":          {1, 1, 1, ""},
+	"HGET":          {1, 1, 1, ""},
+	"HGETALL":       {1, 1, 1, ""},
+	"HRANDFIELD":    {1, 1, 1, ""},
+	"SINTERSTORE":   {1, -1, 1, ""},
+	"SUNIONSTORE":   {1, -1, 1, ""},
+	"SDIFFSTORE":    {1, -1, 1, ""},
+}
+
+// keyNamespace returns the key prefix requested by r, or "" without a namespace.
+// Namespaces may not contain ':', so one namespace cannot reach into another.
+func keyNamespace(r *http.Request) (string, error) {
+	namespace := r.Header.Get(namespaceHeader)
+	if namespace == "" {
+		return "", nil
+	}
+	if strings.ContainsAny(namespace, ": ") {
+		return "", errInvalidNamespace
+	}
+	return namespace + ":", nil
+}
+
+// namespaced returns a copy of parts with prefix applied to every key argument.
+// Glob patterns given to SCAN, EXPIREPATTERN, EXPIRING and MEMORY TOPKEYS are
+// confined to the prefix, and the EX option of SINTERSTORE and friends is left alone.
+func namespaced(parts []string, prefix string) []string {
+	parts = append([]string(nil), parts...)
+	name := strings.ToUpper(parts[0])
+
+	switch name {
+	case "SCAN":
+		for i := 2; i+1 < len(parts); i += 2 {
+			if strings.EqualFold(parts[i], "MATCH") {
+				parts[i+1] = globEscape(prefix) + parts[i+1]
+				return parts
+			}
+		}
+		return append(parts, "MATCH", globEscape(prefix)+"*")
+	case "EXPIREPATTERN":
+		parts[1] = globEscape(prefix) + parts[1]
+		return parts
+	case "SINTERSTORE", "SUNIONSTORE", "SDIFFSTORE":
+		parts[1] = prefix + parts[1]
+		for i := setOpStoreKeys(parts); i < len(parts); i++ {
+			parts[i] = prefix + parts[i]
+		}
+		return parts
+	case "EXPIRING":
+		if len(parts) == 4 {
+			parts[3] = globEscape(prefix) + parts[3]
+			return parts
+		}
+		return append(parts, "MATCH", globEscape(prefix)+"*")
+	case "MEMORY":
+		if len(parts) > 1 && strings.EqualFold(parts[1], "TOPKEYS") {
+			if len(parts) == 5 {
+				parts[4] = globEscape(prefix) + parts[4]
+				return parts
+			}
+			return append(parts, "MATCH", globEscape(prefix)+"*")
+		}
+	}
+
+	for _, i := range keyIndexes(parts) {
+		parts[i] = prefix + parts[i]
+	}
+	return parts
+}
+
+// keyIndexes returns the positions of the key arguments in parts, as listed in commandKeys.
+func keyIndexes(parts []string) []int {
+	spec, ok := commandKeys[strings.ToUpper(parts[0])]
+	if !ok || (spec.sub != "" && (len(parts) < 2 || !strings.EqualFold(parts[1], spec.sub))) {
+		return nil
+	}
+	last := spec.last
+	if last < 0 || last >= len(parts) {
+		last = len(parts) - 1
+	}
+	var indexes []int
+	for i := spec.first; i <= last; i += spec.step {
+		indexes = append(indexes, i)
+	}
+	return indexes
+}
+
+// globEscape escapes the characters globMatch treats specially.
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`*?[]\`, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// stripNamespace removes the request's key prefix from the key names in a reply
+// written to w. Only SCAN, MGETMAP, EXPIRING and MEMORY TOPKEYS reply with key names.
+func stripNamespace(w http.ResponseWriter, value interface{}) interface{} {
+	fw, ok := w.(*formatWriter)
+	if !ok || fw.namespace == "" {
+		return value
+	}
+
+	switch v := value.(type) {
+	case ScanResult:
+		keys := make([]string, len(v.Keys))
+		for i, key := range v.Keys {
+			keys[i] = strings.TrimPrefix(key, fw.namespace)
+		}
+		v.Keys = keys
+		return v
+	case []ExpiringKey:
+		keys := make([]ExpiringKey, len(v))
+		for i, key := range v {
+			key.Key = strings.TrimPrefix(key.Key, fw.namespace)
+			keys[i] = key
+		}
+		return keys
+	case []KeySize:
+		keys := make([]KeySize, len(v))
+		for i, key := range v {
+			key.Key = strings.TrimPrefix(key.Key, fw.namespace)
+			keys[i] = key
+		}
+		return keys
+	case map[string]*string:
+		stripped := make(map[string]*string, len(v))
+		for key, value := range v {
+			stripped[strings.TrimPrefix(key, fw.namespace)] = value
+		}
+		return stripped
+	}
+	return value
+}
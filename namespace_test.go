@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// sendNamespacedCommand sends command with the X-Key-Namespace header set to namespace.
+func sendNamespacedCommand(t *testing.T, namespace, command string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	body, err := json.Marshal(Command{Command: command})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, "/", strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(namespaceHeader, namespace)
+
+	rr := httptest.NewRecorder()
+	handleRequest(rr, req)
+	return rr
+}
+
+func TestNamespacesAreIsolated(t *testing.T) {
+	sendNamespacedCommand(t, "a", "SET ns-shared from-a")
+	sendNamespacedCommand(t, "a", "QPUSH ns-jobs job-a")
+
+	var value ValueResponse
+	decodeResponse(t, sendNamespacedCommand(t, "a", "GET ns-shared"), &value)
+	if value.Value != "from-a" {
+		t.Errorf("Expected namespace a to read its own key, but got %q", value.Value)
+	}
+
+	var response ErrorResponse
+	decodeResponse(t, sendNamespacedCommand(t, "b", "GET ns-shared"), &response)
+	if response.Error != errKeyNotFound.Error() {
+		t.Errorf("Expected the key to be invisible to namespace b, but got %q", response.Error)
+	}
+	var length IntegerResponse
+	decodeResponse(t, sendNamespacedCommand(t, "b", "QLEN ns-jobs"), &length)
+	if length.Value != 0 {
+		t.Errorf("Expected namespace b to see an empty queue, but got length %d", length.Value)
+	}
+
+	// Namespace b gets its own key of the same name, leaving a's untouched
+	sendNamespacedCommand(t, "b", "SET ns-shared from-b")
+	decodeResponse(t, sendNamespacedCommand(t, "a", "GET ns-shared"), &value)
+	if value.Value != "from-a" {
+		t.Errorf("Expected namespace a's key to be unchanged, but got %q", value.Value)
+	}
+	if got, _ := store.Get("a:ns-shared"); got != "from-a" {
+		t.Errorf("Expected the key to be stored as a:ns-shared, but got %q", got)
+	}
+
+	var deleted IntegerResponse
+	decodeResponse(t, sendNamespacedCommand(t, "b", "DEL ns-shared ns-jobs"), &deleted)
+	if deleted.Value != 1 {
+		t.Errorf("Expected namespace b to delete only its own key, but deleted %d", deleted.Value)
+	}
+}
+
+func TestNamespaceStripsReturnedKeys(t *testing.T) {
+	sendNamespacedCommand(t, "scan-ns", "SET one 1")
+	sendNamespacedCommand(t, "scan-ns", "SET two 2")
+	sendCommand(t, "SET outside 3")
+
+	var keys []string
+	for cursor := "0"; ; {
+		var response struct{ Value ScanResult }
+		decodeResponse(t, sendNamespacedCommand(t, "scan-ns", "SCAN "+cursor+" COUNT 100"), &response)
+		keys = append(keys, response.Value.Keys...)
+		if response.Value.Cursor == 0 {
+			break
+		}
+		cursor = strconv.FormatUint(response.Value.Cursor, 10)
+	}
+	sort.Strings(keys)
+	if expected := []string{"one", "two"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected SCAN to return only the namespace's keys %v, but got %v", expected, keys)
+	}
+
+	var values struct{ Value map[string]*string }
+	decodeResponse(t, sendNamespacedCommand(t, "scan-ns", "MGETMAP one two"), &values)
+	if _, ok := values.Value["one"]; !ok || len(values.Value) != 2 {
+		t.Errorf("Expected MGETMAP keyed by unprefixed names, but got %v", values.Value)
+	}
+
+	var response ErrorResponse
+	decodeResponse(t, sendNamespacedCommand(t, "a:b", "GET one"), &response)
+	if response.Error != errInvalidNamespace.Error() {
+		t.Errorf("Expected a namespace containing ':' to be rejected, but got %q", response.Error)
+	}
+}
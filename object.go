@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var errNoLFU = errors.New("an LFU eviction policy is not selected, access frequencies are not tracked")
+
+// Thresholds mirroring the Redis defaults used to pick a compact encoding.
+const (
+	embstrMaxLength      = 44  // Strings up to this length are reported as "embstr"
+	listpackMaxEntries   = 128 // Lists and sets up to this size may use a listpack
+	listpackMaxValueSize = 64  // Largest element allowed in a listpack
+	intsetMaxEntries     = 512 // Integer-only sets up to this size use an intset
+)
+
+// encoding reports the Redis-style internal representation of the value.
+// The store keeps a single representation per type, so this is a heuristic
+// based on the value's size and contents that monitoring tools can rely on.
+func (kv *KeyValue) encoding() string {
+	switch kv.Kind {
+	case kindSet:
+		allInts := len(kv.Set) <= intsetMaxEntries
+		for member := range kv.Set {
+			if !allInts {
+				break
+			}
+			allInts = isInteger(member)
+		}
+		if allInts {
+			return "intset"
+		}
+		if fitsListpack(len(kv.Set), mapKeys(kv.Set)) {
+			return "listpack"
+		}
+		return "hashtable"
+	case kindHash:
+		values := make([]string, 0, 2*len(kv.Hash))
+		for field, value := range kv.Hash {
+			values = append(values, field, value)
+		}
+		if fitsListpack(len(kv.Hash), values) {
+			return "listpack"
+		}
+		return "hashtable"
+	case kindTimeSeries:
+		return "uncompressed" // Samples are stored as plain arrays, not compressed chunks
+	case kindList:
+		if kv.Priority == nil && fitsListpack(len(kv.Value), kv.Value) {
+			return "listpack"
+		}
+		return "quicklist"
+	default:
+		value := strings.Join(kv.Value, " ")
+		if isInteger(value) {
+			return "int"
+		}
+		if len(value) <= embstrMaxLength {
+			return "embstr"
+		}
+		return "raw"
+	}
+}
+
+// isInteger reports whether s is the canonical form of a 64-bit integer.
+func isInteger(s string) bool {
+	n, err := strconv.ParseInt(s, 10, 64)
+	return err == nil && strconv.FormatInt(n, 10) == s
+}
+
+// fitsListpack reports whether a collection is small enough to be stored as a listpack.
+func fitsListpack(count int, values []string) bool {
+	if count > listpackMaxEntries {
+		return false
+	}
+	for _, value := range values {
+		if len(value) > listpackMaxValueSize {
+			return false
+		}
+	}
+	return true
+}
+
+// mapKeys returns the keys of set in no particular order.
+func mapKeys(set map[string]struct{}) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// ObjectEncoding returns the encoding of the value stored at key.
+func (store *KeyValueStore) ObjectEncoding(key string) (string, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	kv, ok := store.lookup(key)
+	if !ok {
+		return "", errKeyNotFound
+	}
+	return kv.encoding(), nil
+}
+
+// ObjectFreq returns the logarithmic access-frequency counter the LFU eviction
+// policy keeps for key. Frequencies are only tracked while a memory limit is set
+// with the LFU policy, and not for pinned keys, which report 0.
+func (store *KeyValueStore) ObjectFreq(key string) (int, error) {
+	policy, ok := store.eviction().(*lfuPolicy)
+	if !ok || store.maxMemory <= 0 {
+		return 0, errNoLFU
+	}
+
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	// Not lookup, as reading the counter must not count as an access
+	kv, ok := store.Data[key]
+	if !ok || kv.isExpired() || store.isIdle(kv, clock.Now()) {
+		return 0, errKeyNotFound
+	}
+	freq, _ := policy.Freq(key)
+	return freq, nil
+}
+
+// handleOBJECT handles the OBJECT family of introspection commands.
+func handleOBJECT(w http.ResponseWriter, parts []string) {
+	if len(parts) != 3 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	switch strings.ToUpper(parts[1]) {
+	case "ENCODING":
+		encoding, err := store.ObjectEncoding(parts[2])
+		if err != nil {
+			sendReadError(w, err)
+			return
+		}
+		sendValueResponse(w, encoding)
+	case "FREQ":
+		freq, err := store.ObjectFreq(parts[2])
+		if err != nil {
+			sendReadError(w, err)
+			return
+		}
+		sendIntegerResponse(w, int64(freq))
+	default:
+		sendErrorResponse(w, "invalid command")
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestObjectEncoding(t *testing.T) {
+	sendCommand(t, "SET encoding-int 12345")
+	sendCommand(t, "SET encoding-padded 0123")
+	sendCommand(t, "SET encoding-short hello")
+	sendCommand(t, "SET encoding-long "+strings.Repeat("x", 100))
+	sendCommand(t, "QPUSH encoding-list a b c")
+	sendCommand(t, "SADD encoding-set 1 2 3")
+
+	cases := map[string]string{
+		"encoding-int":    "int",
+		"encoding-padded": "embstr", // Not the canonical form of an integer
+		"encoding-short":  "embstr",
+		"encoding-long":   "raw",
+		"encoding-list":   "listpack",
+		"encoding-set":    "intset",
+	}
+
+	for key, expected := range cases {
+		var response ValueResponse
+		decodeResponse(t, sendCommand(t, "OBJECT ENCODING "+key), &response)
+
+		if response.Value != expected {
+			t.Errorf("%s: expected encoding %q, but got %q", key, expected, response.Value)
+		}
+	}
+
+	if rr := sendCommand(t, "OBJECT ENCODING encoding-missing"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d for a missing key, but got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestOBJECTFREQUnderLFU(t *testing.T) {
+	testStore := &KeyValueStore{Data: make(map[string]*KeyValue), maxMemory: 1 << 40, evictionPolicy: newLFUPolicy()}
+	testStore.Set("hot", "v", nil, "")
+	testStore.Set("cold", "v", nil, "")
+	for i := 0; i < 100; i++ {
+		testStore.Get("hot")
+	}
+	testStore.Get("cold")
+
+	hot, err := testStore.ObjectFreq("hot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cold, _ := testStore.ObjectFreq("cold")
+	if hot <= cold || cold < lfuInitVal {
+		t.Errorf("Expected the hot key's freq to exceed the cold key's, but got %d and %d", hot, cold)
+	}
+	if again, _ := testStore.ObjectFreq("hot"); again != hot {
+		t.Errorf("Expected reading the freq not to count as an access, but it went from %d to %d", hot, again)
+	}
+
+	lru := &KeyValueStore{Data: make(map[string]*KeyValue), maxMemory: 1 << 40}
+	lru.Set("key", "v", nil, "")
+	if _, err := lru.ObjectFreq("key"); err != errNoLFU {
+		t.Errorf("Expected %v under LRU, but got %v", errNoLFU, err)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"container/heap"
+	"errors"
+	"time"
+)
+
+var errNotPriorityQueue = errors.New("key holds a plain queue, not a priority queue")
+
+// priorityItem is a single queued value along with its priority and insertion sequence.
+type priorityItem struct {
+	value    string
+	priority int
+	seq      uint64 // Insertion order, used to keep FIFO order within a priority
+}
+
+// priorityQueue is a heap of items ordered by highest priority first,
+// then by insertion order. It implements heap.Interface.
+type priorityQueue struct {
+	items   []priorityItem
+	nextSeq uint64
+}
+
+func (pq *priorityQueue) Len() int { return len(pq.items) }
+
+func (pq *priorityQueue) Less(i, j int) bool {
+	if pq.items[i].priority != pq.items[j].priority {
+		return pq.items[i].priority > pq.items[j].priority
+	}
+	return pq.items[i].seq < pq.items[j].seq
+}
+
+func (pq *priorityQueue) Swap(i, j int) { pq.items[i], pq.items[j] = pq.items[j], pq.items[i] }
+
+func (pq *priorityQueue) Push(x interface{}) { pq.items = append(pq.items, x.(priorityItem)) }
+
+func (pq *priorityQueue) Pop() interface{} {
+	last := pq.items[len(pq.items)-1]
+	pq.items = pq.items[:len(pq.items)-1]
+	return last
+}
+
+// push adds value with the given priority in O(log n).
+func (pq *priorityQueue) push(value string, priority int) {
+	heap.Push(pq, priorityItem{value: value, priority: priority, seq: pq.nextSeq})
+	pq.nextSeq++
+}
+
+// pop removes the highest-priority, earliest-inserted value in O(log n).
+func (pq *priorityQueue) pop() (string, bool) {
+	if pq.Len() == 0 {
+		return "", false
+	}
+	return heap.Pop(pq).(priorityItem).value, true
+}
+
+// dropLast removes the value pop would return last: the lowest-priority,
+// latest-inserted one. It takes O(n).
+func (pq *priorityQueue) dropLast() {
+	last := 0
+	for i := 1; i < pq.Len(); i++ {
+		if pq.Less(last, i) {
+			last = i
+		}
+	}
+	heap.Remove(pq, last)
+}
+
+// QPushPriority pushes values onto the priority queue stored at key, creating it if needed,
+// and returns the resulting queue length. A key already holding a non-empty plain queue
+// cannot be turned into a priority queue.
+func (store *KeyValueStore) QPushPriority(key string, values []string, priority int) (int, error) {
+	return store.qpushPriority(key, values, priority, 0)
+}
+
+// qpushPriority is QPushPriority that also sets the queue to expire ttl after
+// the push when ttl is positive.
+func (store *KeyValueStore) qpushPriority(key string, values []string, priority int, ttl time.Duration) (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	kv, ok := store.lookup(key)
+	if ok && kv.Priority == nil && len(kv.Value) > 0 {
+		return 0, errNotPriorityQueue
+	}
+	admitted, err := store.admit(kv, len(values))
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		if admitted == 0 {
+			return 0, nil
+		}
+		kv = &KeyValue{Kind: kindList}
+		store.insert(key, kv)
+	}
+	if kv.Priority == nil {
+		kv.Priority = &priorityQueue{}
+	}
+
+	for _, value := range values[:admitted] {
+		kv.Priority.push(value, priority)
+	}
+	store.trimQueue(kv)
+	kv.refreshTTL(ttl)
+	store.recordQueue(key, admitted, 0)
+
+	length := kv.Priority.Len()
+	store.serveWaiters(key, kv)
+	return length, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPriorityQueuePopOrder(t *testing.T) {
+	pushes := []string{
+		"QPUSH jobs low-1 PRIORITY 1",
+		"QPUSH jobs high-1 PRIORITY 10",
+		"QPUSH jobs mid-1 PRIORITY 5",
+		"QPUSH jobs high-2 PRIORITY 10",
+		"QPUSH jobs low-2 PRIORITY 1",
+		"QPUSH jobs mid-2 mid-3 PRIORITY 5",
+	}
+	for _, command := range pushes {
+		if rr := sendCommand(t, command); rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected status code %d, but got %d", command, http.StatusOK, rr.Code)
+		}
+	}
+
+	// Highest priority first, then insertion order within a priority
+	expected := []string{"high-1", "high-2", "mid-1", "mid-2", "mid-3", "low-1", "low-2"}
+	for _, want := range expected {
+		var response ValueResponse
+		decodeResponse(t, sendCommand(t, "QPOP jobs"), &response)
+
+		if response.Value != want {
+			t.Errorf("Expected %q, but got %q", want, response.Value)
+		}
+	}
+
+	if rr := sendCommand(t, "QPOP jobs"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for an empty queue, but got %d", http.StatusBadRequest, rr.Code)
+	}
+}
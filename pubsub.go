@@ -0,0 +1,427 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// subscriberBuffer is how many live messages may queue up for a subscriber
+// before further messages to it are dropped.
+const subscriberBuffer = 64
+
+// Message is a published message as delivered to subscribers. Offsets are
+// assigned from a single counter across all channels, so a reconnecting
+// subscriber can resume every channel from the last offset it saw.
+type Message struct {
+	Offset  uint64 `json:"offset"`
+	Channel string `json:"channel"`
+	Message string `json:"message"`
+	Ack     bool   `json:"ack,omitempty"` // The publisher is waiting for PUBACK offset subscriber from each subscriber
+
+	// Subscriber identifies the receiving subscriber to PUBACK. It is only set
+	// on messages delivered live with Ack, as only those are waited for.
+	Subscriber uint64 `json:"subscriber,omitempty"`
+}
+
+// subscriber is a client streaming messages from one or more channels.
+type subscriber struct {
+	id       uint64 // Unique per subscriber, for PUBACK
+	ch       chan Message
+	channels []string
+}
+
+// ringBuffer keeps the most recent messages published to a channel.
+type ringBuffer struct {
+	items []Message
+	next  int // Index the next message is written to once the buffer is full
+}
+
+func (rb *ringBuffer) add(m Message, size int) {
+	if len(rb.items) < size {
+		rb.items = append(rb.items, m)
+		return
+	}
+	rb.items[rb.next] = m
+	rb.next = (rb.next + 1) % size
+}
+
+// since returns the buffered messages with an offset greater than offset, oldest first.
+func (rb *ringBuffer) since(offset uint64) []Message {
+	var messages []Message
+	for i := range rb.items {
+		m := rb.items[(rb.next+i)%len(rb.items)]
+		if m.Offset > offset {
+			messages = append(messages, m)
+		}
+	}
+	return messages
+}
+
+// pendingAck tracks the acknowledgments of a message published with PublishAck.
+type pendingAck struct {
+	delivered map[uint64]bool // IDs of the subscribers the message was delivered to, true once acked
+	quorum    int             // Acks after which the publisher stops waiting
+	acked     int             // Acks received so far, one at most per subscriber delivered to
+	done      chan struct{}   // Closed once acked reaches quorum
+}
+
+// Broker routes published messages to subscribers. It is independent of the
+// key-value store and has its own lock.
+type Broker struct {
+	mutex       sync.Mutex
+	subscribers map[string]map[*subscriber]struct{} // Subscribers by channel
+	history     map[string]*ringBuffer              // Recent messages by channel, when historySize > 0
+	historySize int                                 // Messages kept per channel for replay; 0 disables replay
+	offset      uint64                              // Offset of the last published message
+	pending     map[uint64]*pendingAck              // Messages whose publisher is waiting for acks, by offset
+	lastSubID   uint64                              // ID given to the most recent subscriber
+}
+
+var broker = &Broker{}
+
+// Publish sends message to every subscriber of channel and returns how many received it.
+func (b *Broker) Publish(channel, message string) int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return len(b.publish(Message{Channel: channel, Message: message}))
+}
+
+// PublishAck publishes message like Publish, then waits for up to timeout
+// until quorum of the subscribers that received it acknowledge it with Ack,
+// and returns how many did. A quorum larger than the number of subscribers
+// reached waits for all of them.
+func (b *Broker) PublishAck(channel, message string, quorum int, timeout time.Duration) int {
+	b.mutex.Lock()
+	m := Message{Channel: channel, Message: message, Ack: true}
+	received := b.publish(m)
+	if quorum > len(received) {
+		quorum = len(received)
+	}
+	if quorum == 0 {
+		b.mutex.Unlock()
+		return 0
+	}
+
+	// Register before unlocking so that no ack arrives before it is tracked
+	p := &pendingAck{delivered: make(map[uint64]bool, len(received)), quorum: quorum, done: make(chan struct{})}
+	for _, id := range received {
+		p.delivered[id] = false
+	}
+	if b.pending == nil {
+		b.pending = make(map[uint64]*pendingAck)
+	}
+	offset := b.offset
+	b.pending[offset] = p
+	b.mutex.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-p.done:
+	case <-timer.C:
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.pending, offset)
+	return p.acked
+}
+
+// Ack records the acknowledgment of the message at offset by the subscriber
+// with ID subscriberID, reporting whether its publisher was still waiting for
+// it. A subscriber the message was not delivered to, or one that has already
+// acknowledged it, is not counted.
+func (b *Broker) Ack(offset, subscriberID uint64) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	p, ok := b.pending[offset]
+	if !ok {
+		return false
+	}
+	if acked, delivered := p.delivered[subscriberID]; !delivered || acked {
+		return false
+	}
+	p.delivered[subscriberID] = true
+	p.acked++
+	if p.acked == p.quorum {
+		close(p.done)
+	}
+	return true
+}
+
+// publish assigns m the next offset, records it for replay and delivers it to
+// the subscribers of its channel, returning the IDs of those that received it.
+// The caller must hold the broker lock.
+func (b *Broker) publish(m Message) []uint64 {
+	b.offset++
+	m.Offset = b.offset
+	channel := m.Channel
+
+	if b.historySize > 0 {
+		if b.history == nil {
+			b.history = make(map[string]*ringBuffer)
+		}
+		rb, ok := b.history[channel]
+		if !ok {
+			rb = &ringBuffer{}
+			b.history[channel] = rb
+		}
+		rb.add(m, b.historySize)
+	}
+
+	var received []uint64
+	for sub := range b.subscribers[channel] {
+		delivery := m
+		if m.Ack {
+			delivery.Subscriber = sub.id
+		}
+		// Never block a publisher on a slow subscriber
+		select {
+		case sub.ch <- delivery:
+			received = append(received, sub.id)
+		default:
+		}
+	}
+	return received
+}
+
+// Subscribe registers a subscriber for channels. When replay is set it also
+// returns the buffered messages published after offset, oldest first. Both
+// happen under the broker lock, so no message is missed or delivered twice
+// between the replay and the live stream.
+func (b *Broker) Subscribe(channels []string, replay bool, offset uint64) (*subscriber, []Message) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.lastSubID++
+	sub := &subscriber{id: b.lastSubID, ch: make(chan Message, subscriberBuffer), channels: channels}
+	if b.subscribers == nil {
+		b.subscribers = make(map[string]map[*subscriber]struct{})
+	}
+	for _, channel := range channels {
+		if b.subscribers[channel] == nil {
+			b.subscribers[channel] = make(map[*subscriber]struct{})
+		}
+		b.subscribers[channel][sub] = struct{}{}
+	}
+
+	var backlog []Message
+	if replay {
+		for _, channel := range channels {
+			if rb, ok := b.history[channel]; ok {
+				backlog = append(backlog, rb.since(offset)...)
+			}
+		}
+		sort.Slice(backlog, func(i, j int) bool { return backlog[i].Offset < backlog[j].Offset })
+	}
+
+	return sub, backlog
+}
+
+// Unsubscribe removes sub from all of its channels.
+func (b *Broker) Unsubscribe(sub *subscriber) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for _, channel := range sub.channels {
+		delete(b.subscribers[channel], sub)
+		if len(b.subscribers[channel]) == 0 {
+			delete(b.subscribers, channel)
+		}
+	}
+}
+
+// Channels returns the channels with at least one subscriber that match the
+// glob pattern, sorted. An empty pattern matches every channel.
+func (b *Broker) Channels(pattern string) []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	channels := []string{}
+	for channel := range b.subscribers {
+		if pattern == "" || globMatch(pattern, channel) {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// NumSub returns the number of subscribers of each channel.
+func (b *Broker) NumSub(channels []string) map[string]int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	counts := make(map[string]int, len(channels))
+	for _, channel := range channels {
+		counts[channel] = len(b.subscribers[channel])
+	}
+	return counts
+}
+
+// handlePUBSUB handles PUBSUB CHANNELS [pattern], PUBSUB NUMSUB channel... and PUBSUB NUMPAT.
+func handlePUBSUB(w http.ResponseWriter, parts []string) {
+	switch strings.ToUpper(parts[1]) {
+	case "CHANNELS":
+		if len(parts) > 3 {
+			sendErrorResponse(w, "invalid command format")
+			return
+		}
+		pattern := ""
+		if len(parts) == 3 {
+			pattern = parts[2]
+		}
+		sendListResponse(w, broker.Channels(pattern))
+	case "NUMSUB":
+		sendObjectResponse(w, broker.NumSub(parts[2:]))
+	case "NUMPAT":
+		if len(parts) != 2 {
+			sendErrorResponse(w, "invalid command format")
+			return
+		}
+		// Subscribers name exact channels; there are no pattern subscriptions yet
+		sendIntegerResponse(w, 0)
+	default:
+		sendErrorResponse(w, unexpectedToken(parts, 1, "CHANNELS, NUMSUB, or NUMPAT"))
+	}
+}
+
+// handlePUBLISH handles PUBLISH channel message, returning the number of subscribers
+// that received it, and PUBLISH channel message ACK quorum timeout, returning
+// the number that acknowledged it within timeout seconds.
+func handlePUBLISH(w http.ResponseWriter, parts []string) {
+	if len(parts) == 3 {
+		sendIntegerResponse(w, int64(broker.Publish(parts[1], parts[2])))
+		return
+	}
+	if len(parts) != 6 || !strings.EqualFold(parts[3], "ACK") {
+		sendErrorResponse(w, unexpectedToken(parts, 3, "ACK quorum timeout"))
+		return
+	}
+
+	quorum, err := strconv.Atoi(parts[4])
+	if err != nil || quorum <= 0 {
+		sendErrorResponse(w, "invalid quorum")
+		return
+	}
+	seconds, err := strconv.ParseFloat(parts[5], 64)
+	if err != nil || seconds <= 0 {
+		sendErrorResponse(w, "invalid timeout")
+		return
+	}
+
+	acked := broker.PublishAck(parts[1], parts[2], quorum, time.Duration(seconds*float64(time.Second)))
+	sendIntegerResponse(w, int64(acked))
+}
+
+// handlePUBACK handles PUBACK offset subscriber, sent by a subscriber for each
+// message delivered with "ack": true, giving the message's offset and
+// subscriber fields. It returns 1 if the publisher was still waiting for it.
+func handlePUBACK(w http.ResponseWriter, parts []string) {
+	offset, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		sendErrorResponse(w, "invalid offset")
+		return
+	}
+	subscriberID, err := strconv.ParseUint(parts[2], 10, 64)
+	if err != nil {
+		sendErrorResponse(w, "invalid subscriber")
+		return
+	}
+
+	if broker.Ack(offset, subscriberID) {
+		sendIntegerResponse(w, 1)
+		return
+	}
+	sendIntegerResponse(w, 0)
+}
+
+// replayOffset returns the offset a subscriber asked to replay messages after
+// with ?replay=offset, or with the Last-Event-ID header an EventSource sends
+// when it reconnects, and whether it asked at all.
+func replayOffset(r *http.Request) (uint64, bool, error) {
+	value, replay := r.URL.Query()["replay"]
+	offset := ""
+	if replay {
+		offset = value[0]
+	} else if offset = r.Header.Get("Last-Event-ID"); offset != "" {
+		replay = true
+	}
+	if !replay {
+		return 0, false, nil
+	}
+
+	n, err := strconv.ParseUint(offset, 10, 64)
+	if err != nil {
+		return 0, false, errors.New("invalid offset")
+	}
+	return n, true, nil
+}
+
+// handleSubscribe streams messages from the channels named in the query as
+// newline-delimited JSON until the client disconnects:
+//
+//	GET /subscribe?channel=a&channel=b[&replay=offset]
+//
+// With replay set, buffered messages published after offset are sent before
+// live ones; replay=0 sends everything still buffered.
+func handleSubscribe(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	channels := query["channel"]
+	if len(channels) == 0 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	offset, replay, err := replayOffset(r)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sub, backlog := broker.Subscribe(channels, replay, offset)
+	defer broker.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	send := func(m Message) bool {
+		if err := encoder.Encode(m); err != nil {
+			return false
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return true
+	}
+
+	for _, m := range backlog {
+		if !send(m) {
+			return
+		}
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	for {
+		select {
+		case m := <-sub.ch:
+			if !send(m) {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
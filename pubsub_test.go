@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSubscribeReplaysBufferedMessages(t *testing.T) {
+	broker.mutex.Lock()
+	broker.historySize = 2
+	broker.mutex.Unlock()
+	defer func() {
+		broker.mutex.Lock()
+		broker.historySize = 0
+		broker.mutex.Unlock()
+	}()
+
+	// Published before anyone subscribes; only the last two are kept
+	for _, message := range []string{"one", "two", "three"} {
+		var received IntegerResponse
+		decodeResponse(t, sendCommand(t, "PUBLISH replay-news "+message), &received)
+		if received.Value != 0 {
+			t.Errorf("Expected no subscribers, but got %d", received.Value)
+		}
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(handleSubscribe))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/subscribe?channel=replay-news&replay=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	messages := make(chan Message)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			var m Message
+			if json.Unmarshal(scanner.Bytes(), &m) == nil {
+				messages <- m
+			}
+		}
+		close(messages)
+	}()
+
+	next := func() Message {
+		select {
+		case m := <-messages:
+			return m
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for a message")
+			return Message{}
+		}
+	}
+
+	for _, expected := range []string{"two", "three"} {
+		if m := next(); m.Message != expected || m.Channel != "replay-news" {
+			t.Errorf("Expected replayed message %q, but got %+v", expected, m)
+		}
+	}
+
+	// Live messages follow the replay once the subscriber is registered
+	var received IntegerResponse
+	decodeResponse(t, sendCommand(t, "PUBLISH replay-news four"), &received)
+	if received.Value != 1 {
+		t.Errorf("Expected 1 subscriber to receive the message, but got %d", received.Value)
+	}
+	if m := next(); m.Message != "four" {
+		t.Errorf("Expected live message %q, but got %+v", "four", m)
+	}
+}
+
+func TestPUBSUBIntrospection(t *testing.T) {
+	first, _ := broker.Subscribe([]string{"introspect:orders", "introspect:payments"}, false, 0)
+	defer broker.Unsubscribe(first)
+	second, _ := broker.Subscribe([]string{"introspect:orders"}, false, 0)
+	defer broker.Unsubscribe(second)
+
+	var channels ListResponse
+	decodeResponse(t, sendCommand(t, "PUBSUB CHANNELS introspect:*"), &channels)
+	if expected := []string{"introspect:orders", "introspect:payments"}; !reflect.DeepEqual(channels.Value, expected) {
+		t.Errorf("Expected channels %v, but got %v", expected, channels.Value)
+	}
+
+	var counts struct{ Value map[string]int }
+	decodeResponse(t, sendCommand(t, "PUBSUB NUMSUB introspect:orders introspect:payments introspect:none"), &counts)
+	if expected := map[string]int{"introspect:orders": 2, "introspect:payments": 1, "introspect:none": 0}; !reflect.DeepEqual(counts.Value, expected) {
+		t.Errorf("Expected subscriber counts %v, but got %v", expected, counts.Value)
+	}
+
+	var numpat IntegerResponse
+	decodeResponse(t, sendCommand(t, "PUBSUB NUMPAT"), &numpat)
+	if numpat.Value != 0 {
+		t.Errorf("Expected no pattern subscriptions, but got %d", numpat.Value)
+	}
+
+	// Channels disappear once their last subscriber leaves
+	broker.Unsubscribe(first)
+	decodeResponse(t, sendCommand(t, "PUBSUB CHANNELS introspect:*"), &channels)
+	if expected := []string{"introspect:orders"}; !reflect.DeepEqual(channels.Value, expected) {
+		t.Errorf("Expected channels %v after unsubscribing, but got %v", expected, channels.Value)
+	}
+}
+
+func TestPUBLISHWaitsForAcks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(handleSubscribe))
+	defer server.Close()
+
+	// Each subscriber acknowledges every message it is asked to, the first one twice
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(server.URL + "/subscribe?channel=ack-jobs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		repeats := 2 - i
+		go func() {
+			scanner := bufio.NewScanner(resp.Body)
+			for scanner.Scan() {
+				var m Message
+				if json.Unmarshal(scanner.Bytes(), &m) == nil && m.Ack {
+					for j := 0; j < repeats; j++ {
+						sendCommand(t, "PUBACK "+strconv.FormatUint(m.Offset, 10)+" "+strconv.FormatUint(m.Subscriber, 10))
+					}
+				}
+			}
+		}()
+	}
+	for broker.NumSub([]string{"ack-jobs"})["ack-jobs"] != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	var acked IntegerResponse
+	decodeResponse(t, sendCommand(t, "PUBLISH ack-jobs job-1 ACK 2 5"), &acked)
+	if acked.Value != 2 {
+		t.Errorf("Expected both subscribers to acknowledge, but got %d", acked.Value)
+	}
+
+	// A third subscriber that never acks: repeated acks from the first must not stand in for it
+	lagging, _ := broker.Subscribe([]string{"ack-jobs"}, false, 0)
+	defer broker.Unsubscribe(lagging)
+
+	decodeResponse(t, sendCommand(t, "PUBLISH ack-jobs job-2 ACK 3 0.2"), &acked)
+	if acked.Value != 2 {
+		t.Errorf("Expected 2 distinct subscribers to acknowledge, but got %d", acked.Value)
+	}
+
+	// Nobody subscribes to this channel, so there is nothing to wait for
+	start := time.Now()
+	decodeResponse(t, sendCommand(t, "PUBLISH ack-nobody job-1 ACK 1 5"), &acked)
+	if acked.Value != 0 || time.Since(start) > time.Second {
+		t.Errorf("Expected 0 acks at once without subscribers, but got %d after %v", acked.Value, time.Since(start))
+	}
+
+	// Late acks are refused once the publisher has stopped waiting
+	decodeResponse(t, sendCommand(t, "PUBACK 1 1"), &acked)
+	if acked.Value != 0 {
+		t.Errorf("Expected a late ack to be ignored, but got %d", acked.Value)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+var errQueueClosed = errors.New("queue closed")
+
+// exhausted reports whether kv is a closed queue with nothing left to pop,
+// not even delayed values.
+func (kv *KeyValue) exhausted() bool {
+	return kv.closed && kv.queueLen() == 0 && len(kv.Delayed) == 0
+}
+
+// QClose closes the queue at key for decommissioning and returns how many
+// blocked clients it released. Pushes then fail with errQueueClosed while
+// pops drain the values left, after which they fail with errQueueClosed too,
+// as do clients blocked on the queue. A missing queue, which consumers may
+// still be blocked on, is created empty and closed. The closed queue is kept,
+// even empty, until it is deleted.
+func (store *KeyValueStore) QClose(key string) (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	kv, ok := store.lookup(key)
+	if !ok {
+		kv = &KeyValue{Kind: kindList}
+		store.insert(key, kv)
+	}
+	if kv.Kind != kindList {
+		return 0, errWrongType
+	}
+
+	kv.closed = true
+	blocked := len(store.waiters[key])
+	store.serveWaiters(key, kv)
+	return blocked - len(store.waiters[key]), nil
+}
+
+// handleQCLOSE handles QCLOSE key, returning how many blocked clients were released.
+func handleQCLOSE(w http.ResponseWriter, parts []string) {
+	if len(parts) != 2 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	released, err := store.QClose(parts[1])
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendIntegerResponse(w, int64(released))
+}
@@ -0,0 +1,654 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errListTooLong = errors.New("list is too long to return; use LRANGE")
+var errQueueFull = errors.New("queue full")
+var errSameKey = errors.New("source and archive keys are the same")
+
+// What a push does when it would grow a queue past the store's maxQueueLength,
+// as chosen with -queue-overflow.
+const (
+	overflowReject   = "reject"    // Fail the push with errQueueFull, pushing nothing
+	overflowDropHead = "drop-head" // Push, then drop the oldest values (for priority queues, those QPOP would return last)
+	overflowDropNew  = "drop-new"  // Push only the values that fit and drop the rest
+)
+
+// admit applies the queue length limit to a push of n values onto kv, which
+// is nil for a queue that does not exist yet, and returns how many of the
+// values to push. Closed queues admit nothing. The caller must hold the store write lock.
+func (store *KeyValueStore) admit(kv *KeyValue, n int) (int, error) {
+	if kv != nil && kv.closed {
+		return 0, errQueueClosed
+	}
+	if store.maxQueueLength <= 0 || store.queueOverflow == overflowDropHead {
+		return n, nil
+	}
+	length := 0
+	if kv != nil {
+		length = kv.queueLen()
+	}
+	if length+n <= store.maxQueueLength {
+		return n, nil
+	}
+	if store.queueOverflow == overflowDropNew {
+		if length >= store.maxQueueLength {
+			return 0, nil
+		}
+		return store.maxQueueLength - length, nil
+	}
+	return 0, errQueueFull
+}
+
+// trimQueue drops the values a drop-head overflow policy pushes out of kv.
+// The caller must hold the store write lock.
+func (store *KeyValueStore) trimQueue(kv *KeyValue) {
+	if store.maxQueueLength <= 0 || store.queueOverflow != overflowDropHead {
+		return
+	}
+	if kv.Priority != nil {
+		for kv.Priority.Len() > store.maxQueueLength {
+			kv.Priority.dropLast()
+		}
+		return
+	}
+	if excess := len(kv.Value) - store.maxQueueLength; excess > 0 {
+		kv.Value = kv.Value[excess:]
+	}
+}
+
+// QSwap atomically moves the queue at key to archiveKey, replacing anything
+// stored there, and leaves a fresh empty queue of the same kind at key, so
+// producers never observe the queue missing. It returns the archived length.
+// The archive must be a different key, or the fresh queue would replace it.
+func (store *KeyValueStore) QSwap(key, archiveKey string) (int, error) {
+	if key == archiveKey {
+		return 0, errSameKey
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	kv, ok := store.lookup(key)
+	if !ok {
+		return 0, errKeyNotFound
+	}
+	if kv.Kind != kindList {
+		return 0, errWrongType
+	}
+
+	fresh := &KeyValue{Kind: kindList, Pinned: kv.Pinned}
+	if kv.Priority != nil {
+		fresh.Priority = &priorityQueue{}
+	}
+
+	store.insert(archiveKey, kv)
+	store.insert(key, fresh)
+
+	return kv.queueLen() + len(kv.Delayed), nil
+}
+
+// QReplace atomically replaces the contents of the queue at key with values,
+// creating the queue if needed, and returns the old length. Consumers see
+// either the old or the new contents, never an empty queue in between. Delayed
+// values are dropped; a priority queue stays one, with the values at priority 0.
+// The new contents are bounded by the queue length limit as a push would be.
+func (store *KeyValueStore) QReplace(key string, values []string) (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	kv, ok := store.lookup(key)
+	if ok && kv.Kind != kindList {
+		return 0, errWrongType
+	}
+	if ok && kv.closed {
+		return 0, errQueueClosed
+	}
+	// The new contents are checked against the queue length limit as a push onto an empty queue
+	admitted, err := store.admit(nil, len(values))
+	if err != nil {
+		return 0, err
+	}
+	values = values[:admitted]
+	if !ok {
+		kv = &KeyValue{Kind: kindList}
+		store.insert(key, kv)
+	}
+
+	length := kv.queueLen() + len(kv.Delayed)
+	kv.Delayed = nil
+	if kv.Priority != nil {
+		kv.Priority = &priorityQueue{}
+		for _, value := range values {
+			kv.Priority.push(value, 0)
+		}
+	} else {
+		kv.Value = append([]string(nil), values...)
+	}
+	store.trimQueue(kv)
+
+	store.serveWaiters(key, kv)
+	return length, nil
+}
+
+// LPushGet appends values to the queue at key, creating it if needed, and
+// returns a copy of the whole resulting queue in LRANGE order. Nothing is
+// pushed if the queue would grow past the store's maxPushGetReply elements.
+// Any clients blocked on the queue are served first, so the reply shows what
+// they left. Priority queues have no list order to return.
+func (store *KeyValueStore) LPushGet(key string, values []string) ([]string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	kv, ok := store.lookup(key)
+	if ok && (kv.Kind != kindList || kv.Priority != nil) {
+		return nil, errWrongType
+	}
+	length := len(values)
+	if ok {
+		length += len(kv.Value)
+	}
+	if store.maxPushGetReply > 0 && length > store.maxPushGetReply {
+		return nil, errListTooLong
+	}
+	admitted, err := store.admit(kv, len(values))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if admitted == 0 {
+			return []string{}, nil
+		}
+		kv = &KeyValue{Kind: kindList}
+		store.insert(key, kv)
+	}
+
+	kv.Value = append(kv.Value, values[:admitted]...)
+	store.trimQueue(kv)
+	store.recordQueue(key, admitted, 0)
+	store.serveWaiters(key, kv)
+
+	return append([]string{}, kv.Value...), nil
+}
+
+// LMove atomically moves an element from one end of the queue at src to one
+// end of the queue at dst and returns it. LEFT is the first element in LRANGE
+// order and RIGHT the end QPOP takes from. dst is created if needed, and may be
+// the same queue as src to rotate it, and src is deleted once its last element
+// is moved. Priority queues have no ends to move between.
+func (store *KeyValueStore) LMove(src, dst string, fromLeft, toLeft bool) (string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	kv, ok := store.lookup(src)
+	if !ok {
+		return "", errKeyNotFound
+	}
+	value, err := store.lmove(kv, dst, fromLeft, toLeft, clock.Now())
+	if err == nil {
+		store.recordQueue(src, 0, 1)
+		store.dropIfDrained(src, kv)
+	}
+	return value, err
+}
+
+// lmove is LMove with the source queue already looked up, serving any clients
+// blocked on dst. The caller must hold the store write lock.
+func (store *KeyValueStore) lmove(kv *KeyValue, dst string, fromLeft, toLeft bool, now time.Time) (string, error) {
+	if kv.Kind != kindList || kv.Priority != nil {
+		return "", errWrongType
+	}
+	target, ok := store.lookup(dst)
+	if ok && (target.Kind != kindList || target.Priority != nil) {
+		return "", errWrongType
+	}
+	if ok && target.closed {
+		return "", errQueueClosed
+	}
+
+	kv.promoteDelayed(now)
+	if len(kv.Value) == 0 {
+		return "", errQueueEmpty
+	}
+
+	// Rotating a queue leaves its length unchanged; moving onto another is a
+	// push of one value. A value that drop-new would drop stays where it is.
+	if !ok || target != kv {
+		var existing *KeyValue
+		if ok {
+			existing = target
+		}
+		admitted, err := store.admit(existing, 1)
+		if err == nil && admitted == 0 {
+			err = errQueueFull
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var value string
+	if fromLeft {
+		value, kv.Value = kv.Value[0], kv.Value[1:]
+	} else {
+		value, kv.Value = kv.Value[len(kv.Value)-1], kv.Value[:len(kv.Value)-1]
+	}
+
+	if !ok {
+		target = &KeyValue{Kind: kindList}
+		store.insert(dst, target)
+	}
+	if toLeft {
+		target.Value = append([]string{value}, target.Value...)
+	} else {
+		target.Value = append(target.Value, value)
+	}
+
+	store.trimQueue(target)
+	store.recordQueue(dst, 1, 0)
+	store.serveWaiters(dst, target)
+	return value, nil
+}
+
+// drain removes and returns up to max of the oldest values in the queue, oldest
+// first, or errQueueEmpty when there are none. Priority queues have no age
+// order to drain in. The caller must hold the store write lock.
+func (kv *KeyValue) drain(max int, now time.Time) ([]string, error) {
+	if kv.Kind != kindList || kv.Priority != nil {
+		return nil, errWrongType
+	}
+
+	kv.promoteDelayed(now)
+	if len(kv.Value) == 0 {
+		return nil, errQueueEmpty
+	}
+	if max > len(kv.Value) {
+		max = len(kv.Value)
+	}
+
+	values := append([]string(nil), kv.Value[:max]...)
+	kv.Value = kv.Value[max:]
+	return values, nil
+}
+
+// fanOutHook lets tests fail QPushMulti part way through checking its queues.
+var fanOutHook func(key string) error
+
+// QPushMulti pushes values onto every queue in keys under a single lock
+// acquisition and returns each queue's resulting length. All queues are
+// checked before any is changed, so either every queue receives the values or
+// none does.
+func (store *KeyValueStore) QPushMulti(keys []string, values []string) ([]int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, key := range keys {
+		if fanOutHook != nil {
+			if err := fanOutHook(key); err != nil {
+				return nil, err
+			}
+		}
+		kv, ok := store.lookup(key)
+		if ok && kv.Kind != kindList {
+			return nil, errWrongType
+		}
+		if _, err := store.admit(kv, len(values)); err != nil {
+			return nil, err
+		}
+	}
+
+	lengths := make([]int, len(keys))
+	for i, key := range keys {
+		kv, ok := store.lookup(key)
+		admitted, _ := store.admit(kv, len(values))
+		if !ok {
+			if admitted == 0 {
+				continue
+			}
+			kv = &KeyValue{Kind: kindList}
+			store.insert(key, kv)
+		}
+
+		if kv.Priority != nil {
+			for _, value := range values[:admitted] {
+				kv.Priority.push(value, 0)
+			}
+		} else {
+			kv.Value = append(kv.Value, values[:admitted]...)
+		}
+		store.trimQueue(kv)
+		store.recordQueue(key, admitted, 0)
+
+		lengths[i] = kv.queueLen()
+		store.serveWaiters(key, kv)
+	}
+	return lengths, nil
+}
+
+// LDedup removes repeated elements from the list at key, keeping the first
+// occurrence of each in LRANGE order, and returns how many were removed.
+// Delayed values are left alone until they become visible.
+func (store *KeyValueStore) LDedup(key string) (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	kv, ok := store.lookup(key)
+	if !ok {
+		return 0, nil
+	}
+	if kv.Kind != kindList || kv.Priority != nil {
+		return 0, errWrongType
+	}
+
+	seen := make(map[string]struct{}, len(kv.Value))
+	kept := kv.Value[:0]
+	for _, value := range kv.Value {
+		if _, dup := seen[value]; dup {
+			continue
+		}
+		seen[value] = struct{}{}
+		kept = append(kept, value)
+	}
+	removed := len(kv.Value) - len(kept)
+	kv.Value = kept
+
+	return removed, nil
+}
+
+// LRemPrefix removes elements starting with prefix from the list at key and
+// returns how many were removed. As with LREM, a positive count removes up to
+// count elements from the head, a negative count up to -count from the tail,
+// and 0 removes every match.
+func (store *KeyValueStore) LRemPrefix(key string, count int, prefix string) (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	kv, ok := store.lookup(key)
+	if !ok {
+		return 0, nil
+	}
+	if kv.Kind != kindList || kv.Priority != nil {
+		return 0, errWrongType
+	}
+
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
+
+	remove := make([]bool, len(kv.Value))
+	removed := 0
+	for n := 0; n < len(kv.Value) && (limit == 0 || removed < limit); n++ {
+		i := n
+		if count < 0 {
+			i = len(kv.Value) - 1 - n
+		}
+		if strings.HasPrefix(kv.Value[i], prefix) {
+			remove[i] = true
+			removed++
+		}
+	}
+
+	kept := kv.Value[:0]
+	for i, value := range kv.Value {
+		if !remove[i] {
+			kept = append(kept, value)
+		}
+	}
+	kv.Value = kept
+
+	return removed, nil
+}
+
+// QPeek returns the values between start and stop (inclusive) of the queue at
+// key without removing them, in the order QPOP would return them: index 0 is
+// the next value to be popped. Negative indexes count from the end, as in
+// LRANGE. Delayed values that have become visible are included.
+func (store *KeyValueStore) QPeek(key string, start, stop int) ([]string, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	kv, ok := store.lookup(key)
+	if !ok {
+		return nil, nil
+	}
+	if kv.Kind != kindList {
+		return nil, errWrongType
+	}
+
+	// Delayed values can only be promoted under the write lock, so peek at them in place
+	now := clock.Now()
+	due := 0
+	for due < len(kv.Delayed) && !kv.Delayed[due].visibleAt.After(now) {
+		due++
+	}
+
+	var at func(i int) string
+	length := kv.queueLen() + due
+	if kv.Priority != nil {
+		items := append([]priorityItem(nil), kv.Priority.items...)
+		for i, item := range kv.Delayed[:due] {
+			items = append(items, priorityItem{value: item.value, seq: kv.Priority.nextSeq + uint64(i)})
+		}
+		ordered := &priorityQueue{items: items}
+		sort.Slice(items, ordered.Less)
+		at = func(i int) string { return items[i].value }
+	} else {
+		// Promoted values are appended, and QPOP takes from the end
+		at = func(i int) string {
+			if i < due {
+				return kv.Delayed[due-1-i].value
+			}
+			return kv.Value[len(kv.Value)-1-(i-due)]
+		}
+	}
+
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+
+	var values []string
+	for i := start; i <= stop; i++ {
+		values = append(values, at(i))
+	}
+	return values, nil
+}
+
+// handleQSWAP handles QSWAP key archivekey.
+func handleQSWAP(w http.ResponseWriter, parts []string) {
+	if len(parts) != 3 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	length, err := store.QSwap(parts[1], parts[2])
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendIntegerResponse(w, int64(length))
+}
+
+// handleQREPLACE handles QREPLACE key value..., returning the old length.
+func handleQREPLACE(w http.ResponseWriter, parts []string) {
+	if len(parts) < 3 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	length, err := store.QReplace(parts[1], parts[2:])
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendIntegerResponse(w, int64(length))
+}
+
+// handleLDEDUP handles LDEDUP key, returning how many duplicates were removed.
+func handleLDEDUP(w http.ResponseWriter, parts []string) {
+	if len(parts) != 2 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	removed, err := store.LDedup(parts[1])
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendIntegerResponse(w, int64(removed))
+}
+
+// handleLPUSHGET handles LPUSHGET key value..., replying with the whole resulting list.
+func handleLPUSHGET(w http.ResponseWriter, parts []string) {
+	if len(parts) < 3 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	values, err := store.LPushGet(parts[1], parts[2:])
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendListResponse(w, values)
+}
+
+// handleLREMPREFIX handles LREMPREFIX key count prefix.
+func handleLREMPREFIX(w http.ResponseWriter, parts []string) {
+	if len(parts) != 4 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	count, err := strconv.Atoi(parts[2])
+	if err != nil {
+		sendErrorResponse(w, "invalid count")
+		return
+	}
+
+	removed, err := store.LRemPrefix(parts[1], count, parts[3])
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendIntegerResponse(w, int64(removed))
+}
+
+// handleQPUSHMULTI handles QPUSHMULTI value key..., returning the resulting length of each queue.
+func handleQPUSHMULTI(w http.ResponseWriter, parts []string) {
+	if len(parts) < 3 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	lengths, err := store.QPushMulti(parts[2:], parts[1:2])
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendObjectResponse(w, lengths)
+}
+
+// handleQPEEK handles QPEEK key [index], returning the value at index (default 0,
+// the next to be popped), and QPEEK key start stop, returning a range.
+func handleQPEEK(w http.ResponseWriter, parts []string) {
+	indexes := make([]int, len(parts)-2)
+	for i, part := range parts[2:] {
+		index, err := strconv.Atoi(part)
+		if err != nil {
+			sendErrorResponse(w, "invalid index")
+			return
+		}
+		indexes[i] = index
+	}
+
+	if len(indexes) == 2 {
+		values, err := store.QPeek(parts[1], indexes[0], indexes[1])
+		if err != nil {
+			sendErrorResponse(w, err.Error())
+			return
+		}
+		sendListResponse(w, values)
+		return
+	}
+
+	index := 0
+	if len(indexes) == 1 {
+		index = indexes[0]
+	}
+	values, err := store.QPeek(parts[1], index, index)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+	if len(values) == 0 && index == 0 {
+		sendErrorResponse(w, errQueueEmpty.Error())
+		return
+	}
+	if len(values) == 0 {
+		sendErrorResponse(w, "index out of range")
+		return
+	}
+	sendValueResponse(w, values[0])
+}
+
+// parseEnds parses the LEFT|RIGHT source and destination ends of LMOVE and
+// BLMOVE from parts[3] and parts[4], replying with an error when either is invalid.
+func parseEnds(w http.ResponseWriter, parts []string) (fromLeft, toLeft, ok bool) {
+	ends := make([]bool, 2)
+	for i, part := range parts[3:5] {
+		switch strings.ToUpper(part) {
+		case "LEFT":
+			ends[i] = true
+		case "RIGHT":
+		default:
+			sendErrorResponse(w, unexpectedToken(parts, 3+i, "LEFT or RIGHT"))
+			return false, false, false
+		}
+	}
+	return ends[0], ends[1], true
+}
+
+// handleLMOVE handles LMOVE src dst LEFT|RIGHT LEFT|RIGHT, returning the moved element.
+func handleLMOVE(w http.ResponseWriter, parts []string) {
+	if len(parts) != 5 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	fromLeft, toLeft, ok := parseEnds(w, parts)
+	if !ok {
+		return
+	}
+
+	value, err := store.LMove(parts[1], parts[2], fromLeft, toLeft)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendValueResponse(w, value)
+}
@@ -0,0 +1,574 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQSWAPWithConcurrentProducers(t *testing.T) {
+	store.QPush("active-jobs", []string{"seed"})
+
+	var wg sync.WaitGroup
+	for producer := 0; producer < 4; producer++ {
+		wg.Add(1)
+		go func(producer int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				store.QPush("active-jobs", []string{strconv.Itoa(producer) + ":" + strconv.Itoa(i)})
+			}
+		}(producer)
+	}
+
+	// Swap while the producers are running
+	archived, err := store.QSwap("active-jobs", "archived-jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	archive := store.LRange("archived-jobs", 0, -1)
+	active := store.LRange("active-jobs", 0, -1)
+
+	if archived != len(archive) {
+		t.Errorf("Expected QSWAP to report the archived length %d, but got %d", len(archive), archived)
+	}
+
+	// Every pushed value lands in exactly one of the two queues
+	seen := make(map[string]int)
+	for _, value := range append(archive, active...) {
+		seen[value]++
+	}
+	if len(seen) != 2001 {
+		t.Errorf("Expected 2001 distinct values across both queues, but got %d", len(seen))
+	}
+	for value, count := range seen {
+		if count != 1 {
+			t.Errorf("Expected %q in exactly one queue, but found it %d times", value, count)
+		}
+	}
+}
+
+func TestQSWAPOntoItselfKeepsTheQueue(t *testing.T) {
+	testStore := &KeyValueStore{Data: make(map[string]*KeyValue)}
+	testStore.QPush("self-swap", []string{"a", "b"})
+
+	if _, err := testStore.QSwap("self-swap", "self-swap"); err != errSameKey {
+		t.Errorf("Expected %v, but got %v", errSameKey, err)
+	}
+	if values := testStore.LRange("self-swap", 0, -1); len(values) != 2 {
+		t.Errorf("Expected the queue to keep its 2 values, but got %v", values)
+	}
+}
+
+func TestLREMPREFIXDropsTenantJobs(t *testing.T) {
+	sendCommand(t, "QPUSH shared-jobs tenant:5:a tenant:1:a tenant:5:b tenant:50:a tenant:5:c tenant:2:a")
+
+	var removed IntegerResponse
+	decodeResponse(t, sendCommand(t, "LREMPREFIX shared-jobs 0 tenant:5:"), &removed)
+	if removed.Value != 3 {
+		t.Errorf("Expected 3 jobs removed, but got %d", removed.Value)
+	}
+
+	var remaining ListResponse
+	decodeResponse(t, sendCommand(t, "LRANGE shared-jobs 0 -1"), &remaining)
+	if expected := []string{"tenant:1:a", "tenant:50:a", "tenant:2:a"}; !reflect.DeepEqual(remaining.Value, expected) {
+		t.Errorf("Expected %q to remain, but got %q", expected, remaining.Value)
+	}
+
+	// A negative count removes matches from the tail first
+	sendCommand(t, "QPUSH tail-jobs t:1 t:2 x t:3")
+	decodeResponse(t, sendCommand(t, "LREMPREFIX tail-jobs -2 t:"), &removed)
+	decodeResponse(t, sendCommand(t, "LRANGE tail-jobs 0 -1"), &remaining)
+	if expected := []string{"t:1", "x"}; !reflect.DeepEqual(remaining.Value, expected) {
+		t.Errorf("Expected %q to remain, but got %q", expected, remaining.Value)
+	}
+}
+
+func TestQPUSHEXExpiresIdleQueue(t *testing.T) {
+	qlen := func() int64 {
+		var length IntegerResponse
+		decodeResponse(t, sendCommand(t, "QLEN ephemeral-queue"), &length)
+		return length.Value
+	}
+
+	sendCommand(t, "QPUSH ephemeral-queue a EX 1")
+	time.Sleep(600 * time.Millisecond)
+
+	// Each push refreshes the TTL
+	sendCommand(t, "QPUSH ephemeral-queue b EX 1")
+	time.Sleep(600 * time.Millisecond)
+	if length := qlen(); length != 2 {
+		t.Fatalf("Expected the refreshed queue to hold 2 values, but got %d", length)
+	}
+
+	// With no further pushes the whole queue disappears
+	time.Sleep(600 * time.Millisecond)
+	if length := qlen(); length != 0 {
+		t.Errorf("Expected the queue to have expired, but it holds %d values", length)
+	}
+}
+
+// BenchmarkQPopMillionElementQueue measures pops from a queue holding a million
+// values. Pops take from the end of the backing slice, so their cost does not
+// grow with the queue length.
+func BenchmarkQPopMillionElementQueue(b *testing.B) {
+	const size = 1000000
+
+	values := make([]string, size)
+	for i := range values {
+		values[i] = "job-" + strconv.Itoa(i)
+	}
+
+	testStore := &KeyValueStore{Data: make(map[string]*KeyValue)}
+	testStore.QPush("large-queue", values)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := testStore.QPop("large-queue"); err != nil {
+			b.StopTimer()
+			testStore.QPush("large-queue", values)
+			b.StartTimer()
+		}
+	}
+}
+
+func TestQPUSHMULTIIsAllOrNothing(t *testing.T) {
+	var lengths struct {
+		Value []int `json:"value"`
+	}
+	sendCommand(t, "QPUSH fanout-b existing")
+	decodeResponse(t, sendCommand(t, "QPUSHMULTI job-1 fanout-a fanout-b fanout-c"), &lengths)
+	if expected := []int{1, 2, 1}; !reflect.DeepEqual(lengths.Value, expected) {
+		t.Errorf("Expected lengths %v, but got %v", expected, lengths.Value)
+	}
+
+	// Fail after the first queue has been checked; no queue may receive the value
+	fanOutHook = func(key string) error {
+		if key == "fanout-b" {
+			return errors.New("injected failure")
+		}
+		return nil
+	}
+	defer func() { fanOutHook = nil }()
+
+	var response ErrorResponse
+	decodeResponse(t, sendCommand(t, "QPUSHMULTI job-2 fanout-a fanout-b fanout-c"), &response)
+	if response.Error != "injected failure" {
+		t.Errorf("Expected the injected failure, but got %q", response.Error)
+	}
+
+	for _, key := range []string{"fanout-a", "fanout-b", "fanout-c"} {
+		var queue ListResponse
+		decodeResponse(t, sendCommand(t, "LRANGE "+key+" 0 -1"), &queue)
+		for _, value := range queue.Value {
+			if value == "job-2" {
+				t.Errorf("Expected %s not to receive job-2 after the failure, but got %q", key, queue.Value)
+			}
+		}
+	}
+}
+
+func TestQPEEKLeavesQueueUnchanged(t *testing.T) {
+	sendCommand(t, "QPUSH peek-jobs a b c d")
+
+	var value ValueResponse
+	decodeResponse(t, sendCommand(t, "QPEEK peek-jobs"), &value)
+	if value.Value != "d" {
+		t.Errorf("Expected QPEEK to return the next value to pop d, but got %q", value.Value)
+	}
+	decodeResponse(t, sendCommand(t, "QPEEK peek-jobs 2"), &value)
+	if value.Value != "b" {
+		t.Errorf("Expected QPEEK index 2 to return b, but got %q", value.Value)
+	}
+
+	var values ListResponse
+	decodeResponse(t, sendCommand(t, "QPEEK peek-jobs 0 -1"), &values)
+	if expected := []string{"d", "c", "b", "a"}; !reflect.DeepEqual(values.Value, expected) {
+		t.Errorf("Expected the queue in pop order %v, but got %v", expected, values.Value)
+	}
+
+	if length := store.QLen("peek-jobs"); length != 4 {
+		t.Errorf("Expected QPEEK to leave the length at 4, but got %d", length)
+	}
+	if popped, _ := store.QPop("peek-jobs"); popped != "d" {
+		t.Errorf("Expected QPOP to return the peeked value d, but got %q", popped)
+	}
+
+	var response ErrorResponse
+	decodeResponse(t, sendCommand(t, "QPEEK peek-jobs 10"), &response)
+	if response.Error != "index out of range" {
+		t.Errorf("Expected an error for an index past the end, but got %q", response.Error)
+	}
+}
+
+func TestQPEEKPriorityQueue(t *testing.T) {
+	store.QPushPriority("peek-priority", []string{"low"}, 1)
+	store.QPushPriority("peek-priority", []string{"high-1", "high-2"}, 5)
+
+	values, err := store.QPeek("peek-priority", 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"high-1", "high-2", "low"}; !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected the priority queue in pop order %v, but got %v", expected, values)
+	}
+	if popped, _ := store.QPop("peek-priority"); popped != values[0] {
+		t.Errorf("Expected QPOP to return %q, but got %q", values[0], popped)
+	}
+}
+
+func TestQREPLACEWithConcurrentReaders(t *testing.T) {
+	old := []string{"a", "b", "c"}
+	replacement := []string{"x", "y"}
+	store.QPush("reconciled", old)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for reader := 0; reader < 4; reader++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				contents := store.LRange("reconciled", 0, -1)
+				if !reflect.DeepEqual(contents, old) && !reflect.DeepEqual(contents, replacement) {
+					t.Errorf("Expected the old or new contents, but got %v", contents)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 200; i++ {
+		from, to := old, replacement
+		if i%2 == 1 {
+			from, to = replacement, old
+		}
+		length, err := store.QReplace("reconciled", to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if length != len(from) {
+			t.Errorf("Expected QREPLACE to return the old length %d, but got %d", len(from), length)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	var response IntegerResponse
+	decodeResponse(t, sendCommand(t, "QREPLACE reconciled only"), &response)
+	if response.Value != 3 {
+		t.Errorf("Expected QREPLACE to return the old length 3, but got %d", response.Value)
+	}
+	if contents := store.LRange("reconciled", 0, -1); !reflect.DeepEqual(contents, []string{"only"}) {
+		t.Errorf("Expected [only], but got %v", contents)
+	}
+}
+
+func TestQPopDistinguishesMissingAndEmptyQueues(t *testing.T) {
+	useFakeClock(t)
+	testStore := &KeyValueStore{Data: make(map[string]*KeyValue)}
+
+	if _, err := testStore.QPop("missing"); err != errKeyNotFound {
+		t.Errorf("Expected %v for a missing key, but got %v", errKeyNotFound, err)
+	}
+
+	testStore.qpush(context.Background(), "expiring", []string{"a"}, time.Second)
+	clock.(*FakeClock).Advance(2 * time.Second)
+	if _, err := testStore.QPop("expiring"); err != errKeyNotFound {
+		t.Errorf("Expected %v for an expired key, but got %v", errKeyNotFound, err)
+	}
+
+	testStore.Ensure("empty", "list")
+	if _, err := testStore.QPop("empty"); err != errQueueEmpty {
+		t.Errorf("Expected %v for an empty queue, but got %v", errQueueEmpty, err)
+	}
+	if _, ok := testStore.Data["empty"]; !ok {
+		t.Error("Expected popping an empty queue to keep the key")
+	}
+
+	// A queue with only delayed values is empty but not drained
+	testStore.QPushDelayed("delayed", "later", time.Minute)
+	if _, err := testStore.QPop("delayed"); err != errQueueEmpty {
+		t.Errorf("Expected %v for a queue with only delayed values, but got %v", errQueueEmpty, err)
+	}
+
+	testStore.QPush("jobs", []string{"a", "b"})
+	testStore.QPop("jobs")
+	if _, ok := testStore.Data["jobs"]; !ok {
+		t.Error("Expected the queue to remain while it has values")
+	}
+	testStore.QPop("jobs")
+	if _, err := testStore.QPop("jobs"); err != errKeyNotFound {
+		t.Errorf("Expected popping the last value to delete the key, but got %v", err)
+	}
+
+	if length := testStore.QPush("jobs", []string{"c"}); length != 1 {
+		t.Errorf("Expected a re-push to create a new queue of length 1, but got %d", length)
+	}
+	if value, err := testStore.QPop("jobs"); err != nil || value != "c" {
+		t.Errorf("Expected to pop the re-pushed value, but got %q, %v", value, err)
+	}
+}
+
+func TestQueueCommandsCheckTheKeyType(t *testing.T) {
+	testStore := &KeyValueStore{Data: make(map[string]*KeyValue)}
+	testStore.Set("plain", "two words", nil, "")
+	testStore.Ensure("members", "set")
+
+	if _, err := testStore.QPushContext(context.Background(), "plain", []string{"a"}); err != errWrongType {
+		t.Errorf("Expected %v pushing onto a string, but got %v", errWrongType, err)
+	}
+	if _, err := testStore.QPop("plain"); err != errWrongType {
+		t.Errorf("Expected %v popping a string, but got %v", errWrongType, err)
+	}
+	if _, err := testStore.BQPop("plain", time.Second); err != errWrongType {
+		t.Errorf("Expected %v blocking on a string, but got %v", errWrongType, err)
+	}
+	if _, err := testStore.Get("members"); err != errWrongType {
+		t.Errorf("Expected %v reading a set with GET, but got %v", errWrongType, err)
+	}
+	if value, _ := testStore.Get("plain"); value != "two words" {
+		t.Errorf("Expected the string to be left unchanged, but got %q", value)
+	}
+}
+
+func TestLPUSHGETReturnsWholeList(t *testing.T) {
+	sendCommand(t, "QPUSH pushget-list a")
+
+	var response ListResponse
+	decodeResponse(t, sendCommand(t, "LPUSHGET pushget-list b c"), &response)
+	if !reflect.DeepEqual(response.Value, []string{"a", "b", "c"}) {
+		t.Errorf("Expected [a b c], but got %v", response.Value)
+	}
+
+	testStore := &KeyValueStore{Data: make(map[string]*KeyValue), maxPushGetReply: 3}
+	testStore.QPush("capped", []string{"a", "b"})
+	if _, err := testStore.LPushGet("capped", []string{"c", "d"}); err != errListTooLong {
+		t.Errorf("Expected %v past the limit, but got %v", errListTooLong, err)
+	}
+	if values, err := testStore.LPushGet("capped", []string{"c"}); err != nil || len(values) != 3 {
+		t.Errorf("Expected a push up to the limit to succeed, but got %v, %v", values, err)
+	}
+}
+
+func TestQueueOverflowPolicies(t *testing.T) {
+	newStore := func(overflow string) *KeyValueStore {
+		testStore := &KeyValueStore{Data: make(map[string]*KeyValue), maxQueueLength: 3, queueOverflow: overflow}
+		testStore.QPush("jobs", []string{"a", "b"})
+		return testStore
+	}
+
+	testStore := newStore(overflowReject)
+	if length, err := testStore.QPushContext(context.Background(), "jobs", []string{"c"}); err != nil || length != 3 {
+		t.Errorf("Expected a push up to the limit to succeed, but got %d, %v", length, err)
+	}
+	if _, err := testStore.QPushContext(context.Background(), "jobs", []string{"d"}); err != errQueueFull {
+		t.Errorf("Expected %v past the limit, but got %v", errQueueFull, err)
+	}
+	if _, err := testStore.QPushMulti([]string{"other", "jobs"}, []string{"d"}); err != errQueueFull {
+		t.Errorf("Expected QPUSHMULTI to be rejected, but got %v", err)
+	}
+	if _, ok := testStore.Data["other"]; ok {
+		t.Error("Expected a rejected QPUSHMULTI to push onto no queue")
+	}
+	if values := testStore.LRange("jobs", 0, -1); !reflect.DeepEqual(values, []string{"a", "b", "c"}) {
+		t.Errorf("Expected [a b c] after rejected pushes, but got %v", values)
+	}
+
+	testStore = newStore(overflowDropHead)
+	testStore.QPush("jobs", []string{"c", "d"})
+	if values := testStore.LRange("jobs", 0, -1); !reflect.DeepEqual(values, []string{"b", "c", "d"}) {
+		t.Errorf("Expected drop-head to keep [b c d], but got %v", values)
+	}
+
+	testStore = newStore(overflowDropNew)
+	testStore.QPush("jobs", []string{"c", "d"})
+	if values := testStore.LRange("jobs", 0, -1); !reflect.DeepEqual(values, []string{"a", "b", "c"}) {
+		t.Errorf("Expected drop-new to keep [a b c], but got %v", values)
+	}
+	if length := testStore.QPush("jobs", []string{"e"}); length != 3 {
+		t.Errorf("Expected a push onto a full queue to be dropped, but got length %d", length)
+	}
+}
+
+func TestQueueOverflowDropHeadOnPriorityQueue(t *testing.T) {
+	testStore := &KeyValueStore{Data: make(map[string]*KeyValue), maxQueueLength: 2, queueOverflow: overflowDropHead}
+	testStore.QPushPriority("tasks", []string{"low"}, 1)
+	testStore.QPushPriority("tasks", []string{"high"}, 9)
+	testStore.QPushPriority("tasks", []string{"mid"}, 5)
+
+	for _, expected := range []string{"high", "mid"} {
+		if value, err := testStore.QPop("tasks"); err != nil || value != expected {
+			t.Errorf("Expected %q, but got %q, %v", expected, value, err)
+		}
+	}
+	if _, err := testStore.QPop("tasks"); err != errKeyNotFound {
+		t.Errorf("Expected the lowest-priority value to have been dropped, but got %v", err)
+	}
+}
+
+func TestQREPLACEFollowsOverflowPolicy(t *testing.T) {
+	testStore := &KeyValueStore{Data: make(map[string]*KeyValue), maxQueueLength: 2, queueOverflow: overflowReject}
+	testStore.QPush("jobs", []string{"a"})
+	if _, err := testStore.QReplace("jobs", []string{"b", "c", "d"}); err != errQueueFull {
+		t.Errorf("Expected %v past the limit, but got %v", errQueueFull, err)
+	}
+	if values := testStore.LRange("jobs", 0, -1); !reflect.DeepEqual(values, []string{"a"}) {
+		t.Errorf("Expected a rejected QREPLACE to keep [a], but got %v", values)
+	}
+	if _, err := testStore.QReplace("fresh", []string{"b", "c", "d"}); err != errQueueFull {
+		t.Errorf("Expected %v past the limit, but got %v", errQueueFull, err)
+	}
+	if _, ok := testStore.Data["fresh"]; ok {
+		t.Error("Expected a rejected QREPLACE not to create the queue")
+	}
+
+	testStore.queueOverflow = overflowDropHead
+	testStore.QReplace("jobs", []string{"b", "c", "d"})
+	if values := testStore.LRange("jobs", 0, -1); !reflect.DeepEqual(values, []string{"c", "d"}) {
+		t.Errorf("Expected drop-head to keep [c d], but got %v", values)
+	}
+
+	testStore.queueOverflow = overflowDropNew
+	testStore.QReplace("jobs", []string{"e", "f", "g"})
+	if values := testStore.LRange("jobs", 0, -1); !reflect.DeepEqual(values, []string{"e", "f"}) {
+		t.Errorf("Expected drop-new to keep [e f], but got %v", values)
+	}
+}
+
+func TestLMOVEFollowsOverflowPolicy(t *testing.T) {
+	newStore := func(overflow string) *KeyValueStore {
+		testStore := &KeyValueStore{Data: make(map[string]*KeyValue), maxQueueLength: 2, queueOverflow: overflow}
+		testStore.QPush("src", []string{"a"})
+		testStore.QPush("dst", []string{"x", "y"})
+		return testStore
+	}
+
+	for _, overflow := range []string{overflowReject, overflowDropNew} {
+		testStore := newStore(overflow)
+		if _, err := testStore.LMove("src", "dst", false, true); err != errQueueFull {
+			t.Errorf("Expected %v moving onto a full queue under %s, but got %v", errQueueFull, overflow, err)
+		}
+		if values := testStore.LRange("src", 0, -1); !reflect.DeepEqual(values, []string{"a"}) {
+			t.Errorf("Expected the value to stay in src under %s, but got %v", overflow, values)
+		}
+		if values := testStore.LRange("dst", 0, -1); !reflect.DeepEqual(values, []string{"x", "y"}) {
+			t.Errorf("Expected dst to stay [x y] under %s, but got %v", overflow, values)
+		}
+		if value, err := testStore.LMove("dst", "dst", false, true); err != nil || value != "y" {
+			t.Errorf("Expected rotating a full queue to succeed under %s, but got %q, %v", overflow, value, err)
+		}
+	}
+
+	testStore := newStore(overflowDropHead)
+	if value, err := testStore.LMove("src", "dst", false, false); err != nil || value != "a" {
+		t.Errorf("Expected the move to succeed, but got %q, %v", value, err)
+	}
+	if values := testStore.LRange("dst", 0, -1); !reflect.DeepEqual(values, []string{"y", "a"}) {
+		t.Errorf("Expected drop-head to keep [y a], but got %v", values)
+	}
+}
+
+func TestLDEDUPKeepsFirstOccurrences(t *testing.T) {
+	sendCommand(t, "QPUSH dedup-jobs a b a c b a d")
+
+	var removed IntegerResponse
+	decodeResponse(t, sendCommand(t, "LDEDUP dedup-jobs"), &removed)
+	if removed.Value != 3 {
+		t.Errorf("Expected 3 duplicates removed, but got %d", removed.Value)
+	}
+	if values := store.LRange("dedup-jobs", 0, -1); !reflect.DeepEqual(values, []string{"a", "b", "c", "d"}) {
+		t.Errorf("Expected [a b c d], but got %v", values)
+	}
+}
+
+func TestQSTATSReportsDepthAndRates(t *testing.T) {
+	fake := useFakeClock(t)
+	sendCommand(t, "QPUSH stats-jobs a b c d e f")
+	sendCommand(t, "QPOP stats-jobs")
+	sendCommand(t, "QPOP stats-jobs")
+
+	var stats struct {
+		Value QueueStats `json:"value"`
+	}
+	decodeResponse(t, sendCommand(t, "QSTATS stats-jobs"), &stats)
+	if stats.Value.Depth != 4 {
+		t.Errorf("Expected a depth of 4, but got %d", stats.Value.Depth)
+	}
+	if stats.Value.PushRate != 6.0/queueRateWindow || stats.Value.PopRate != 2.0/queueRateWindow {
+		t.Errorf("Expected 6 pushes and 2 pops over the window, but got rates %v and %v", stats.Value.PushRate, stats.Value.PopRate)
+	}
+
+	// Rates outlive the drained queue, then age out of the window
+	sendCommand(t, "BQDRAIN stats-jobs 10 0")
+	decodeResponse(t, sendCommand(t, "QSTATS stats-jobs"), &stats)
+	if stats.Value.Depth != 0 || stats.Value.PopRate != 6.0/queueRateWindow {
+		t.Errorf("Expected an empty queue with 6 pops, but got %+v", stats.Value)
+	}
+	fake.Advance(queueRateWindow * time.Second)
+	decodeResponse(t, sendCommand(t, "QSTATS stats-jobs"), &stats)
+	if stats.Value.PushRate != 0 || stats.Value.PopRate != 0 {
+		t.Errorf("Expected the rates to age out, but got %+v", stats.Value)
+	}
+}
+
+func TestQCLOSERejectsPushesAndDrains(t *testing.T) {
+	sendCommand(t, "QPUSH closing-jobs a b")
+
+	sendCommand(t, "QCLOSE closing-jobs")
+
+	var response ErrorResponse
+	rr := sendCommand(t, "QPUSH closing-jobs c")
+	decodeResponse(t, rr, &response)
+	if rr.Code != http.StatusBadRequest || response.Error != errQueueClosed.Error() {
+		t.Errorf("Expected a push onto a closed queue to fail, but got %d %q", rr.Code, response.Error)
+	}
+
+	for _, expected := range []string{"b", "a"} {
+		var value ValueResponse
+		decodeResponse(t, sendCommand(t, "QPOP closing-jobs"), &value)
+		if value.Value != expected {
+			t.Errorf("Expected QPOP to drain %q, but got %q", expected, value.Value)
+		}
+	}
+	decodeResponse(t, sendCommand(t, "QPOP closing-jobs"), &response)
+	if response.Error != errQueueClosed.Error() {
+		t.Errorf("Expected a drained closed queue to report it is closed, but got %q", response.Error)
+	}
+
+	// A client blocked on an empty queue is released as soon as it closes
+	done := make(chan string)
+	go func() {
+		var response ErrorResponse
+		decodeResponse(t, sendCommand(t, "BQPOP idle-jobs 10"), &response)
+		done <- response.Error
+	}()
+	for !isBlockedOn("idle-jobs") {
+		time.Sleep(time.Millisecond)
+	}
+
+	var released IntegerResponse
+	decodeResponse(t, sendCommand(t, "QCLOSE idle-jobs"), &released)
+	if released.Value != 1 {
+		t.Errorf("Expected QCLOSE to release 1 blocked client, but got %d", released.Value)
+	}
+	select {
+	case err := <-done:
+		if err != errQueueClosed.Error() {
+			t.Errorf("Expected the blocked client to get %q, but got %q", errQueueClosed, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected QCLOSE to release the blocked client promptly")
+	}
+}
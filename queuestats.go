@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// queueRateWindow is the span, in one-second buckets, over which QSTATS reports push and pop rates.
+const queueRateWindow = 60
+
+// queueStatsPrune is the number of tracked queues above which idle ones are
+// forgotten as new queues are tracked.
+const queueStatsPrune = 1024
+
+// queueRateBucket counts the pushes and pops of one second.
+type queueRateBucket struct {
+	second       int64 // Unix second the counts belong to
+	pushes, pops int64
+}
+
+// queueRates counts a queue's recent pushes and pops in a ring of one-second
+// buckets. Rates are kept by key name rather than on the queue itself, so they
+// survive the queue being drained and deleted.
+type queueRates struct {
+	buckets [queueRateWindow]queueRateBucket
+	last    int64 // Unix second of the latest push or pop
+}
+
+func (r *queueRates) add(now time.Time, pushes, pops int) {
+	second := now.Unix()
+	b := &r.buckets[second%queueRateWindow]
+	if b.second != second {
+		*b = queueRateBucket{second: second}
+	}
+	b.pushes += int64(pushes)
+	b.pops += int64(pops)
+	r.last = second
+}
+
+// totals returns the pushes and pops in the window ending at now.
+func (r *queueRates) totals(now time.Time) (pushes, pops int64) {
+	since := now.Unix() - queueRateWindow
+	for _, b := range r.buckets {
+		if b.second > since {
+			pushes += b.pushes
+			pops += b.pops
+		}
+	}
+	return pushes, pops
+}
+
+// recordQueue counts pushes and pops on the queue at key for QSTATS, and marks
+// the queue as written so its new size is accounted.
+// The caller must hold the store write lock.
+func (store *KeyValueStore) recordQueue(key string, pushes, pops int) {
+	if pushes == 0 && pops == 0 {
+		return
+	}
+	store.markWritten(key)
+	now := clock.Now()
+
+	rates, ok := store.queueStats[key]
+	if !ok {
+		if store.queueStats == nil {
+			store.queueStats = make(map[string]*queueRates)
+		}
+		if len(store.queueStats) >= queueStatsPrune {
+			for name, r := range store.queueStats {
+				if r.last <= now.Unix()-queueRateWindow {
+					delete(store.queueStats, name)
+				}
+			}
+		}
+		rates = &queueRates{}
+		store.queueStats[key] = rates
+	}
+	rates.add(now, pushes, pops)
+}
+
+// QueueStats is the reply to QSTATS. Values are not timestamped when pushed,
+// so the age of the oldest value is not known.
+type QueueStats struct {
+	Depth    int     `json:"depth"`     // Values visible to QPOP
+	Delayed  int     `json:"delayed"`   // Values pushed with a delay that are not yet visible
+	PushRate float64 `json:"push_rate"` // Values pushed per second over the window
+	PopRate  float64 `json:"pop_rate"`  // Values popped per second over the window
+	Window   int     `json:"window"`    // Seconds the rates are averaged over
+}
+
+// QStats reports the depth of the queue at key and its push and pop rates
+// over the last queueRateWindow seconds. A missing key has a depth of 0 but
+// keeps its rates, since queues are deleted once drained.
+func (store *KeyValueStore) QStats(key string) (QueueStats, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := clock.Now()
+	stats := QueueStats{Window: queueRateWindow}
+	if kv, ok := store.lookup(key); ok {
+		if kv.Kind != kindList {
+			return QueueStats{}, errWrongType
+		}
+		kv.promoteDelayed(now)
+		stats.Depth = kv.queueLen()
+		stats.Delayed = len(kv.Delayed)
+	}
+	if rates, ok := store.queueStats[key]; ok {
+		pushes, pops := rates.totals(now)
+		stats.PushRate = float64(pushes) / queueRateWindow
+		stats.PopRate = float64(pops) / queueRateWindow
+	}
+	return stats, nil
+}
+
+// handleQSTATS handles QSTATS key, returning the queue's depth and rates as an object.
+func handleQSTATS(w http.ResponseWriter, parts []string) {
+	if len(parts) != 2 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	stats, err := store.QStats(parts[1])
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendObjectResponse(w, stats)
+}
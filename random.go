@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxRandomCount bounds how many members a negative count may ask for, since
+// each one is allocated up front and may repeat without limit.
+const maxRandomCount = 1 << 20
+
+var errCountTooLarge = errors.New("count is too large")
+
+// sampleIndexes picks indexes into a collection of size n. A positive count returns
+// up to count distinct indexes; a negative count returns exactly -count indexes
+// that may repeat. Sampling is uniform, independent of map iteration order.
+func sampleIndexes(n, count int) []int {
+	if n == 0 || count == 0 {
+		return nil
+	}
+
+	if count < 0 {
+		indexes := make([]int, -count)
+		for i := range indexes {
+			indexes[i] = rand.Intn(n)
+		}
+		return indexes
+	}
+
+	if count > n {
+		count = n
+	}
+	return rand.Perm(n)[:count]
+}
+
+// SRandMember returns random members of the set stored at key following sampleIndexes semantics.
+func (store *KeyValueStore) SRandMember(key string, count int) ([]string, error) {
+	if count < -maxRandomCount {
+		return nil, errCountTooLarge
+	}
+
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	set, err := store.lookupSet(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sorting gives a stable indexable view of the set to sample from
+	members := sortedMembers(set)
+
+	result := []string{}
+	for _, i := range sampleIndexes(len(members), count) {
+		result = append(result, members[i])
+	}
+	return result, nil
+}
+
+// HRandField returns random fields of the hash stored at key following sampleIndexes
+// semantics, each followed by its value when withValues is set.
+func (store *KeyValueStore) HRandField(key string, count int, withValues bool) ([]string, error) {
+	if count < -maxRandomCount {
+		return nil, errCountTooLarge
+	}
+
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	hash, err := store.lookupHash(key)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make([]string, 0, len(hash))
+	for field := range hash {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	result := []string{}
+	for _, i := range sampleIndexes(len(fields), count) {
+		result = append(result, fields[i])
+		if withValues {
+			result = append(result, hash[fields[i]])
+		}
+	}
+	return result, nil
+}
+
+// handleSRANDMEMBER handles SRANDMEMBER key [count]. Without a count a single member is returned.
+func handleSRANDMEMBER(w http.ResponseWriter, parts []string) {
+	if len(parts) != 2 && len(parts) != 3 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	count := 1
+	if len(parts) == 3 {
+		var err error
+		if count, err = strconv.Atoi(parts[2]); err != nil {
+			sendErrorResponse(w, "invalid count")
+			return
+		}
+	}
+
+	members, err := store.SRandMember(parts[1], count)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	if len(parts) == 2 {
+		if len(members) == 0 {
+			sendErrorResponse(w, "key not found")
+			return
+		}
+		sendValueResponse(w, members[0])
+		return
+	}
+
+	sendListResponse(w, members)
+}
+
+// handleHRANDFIELD handles HRANDFIELD key [count [WITHVALUES]]. Without a count a single field is returned.
+func handleHRANDFIELD(w http.ResponseWriter, parts []string) {
+	if len(parts) < 2 || len(parts) > 4 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	count := 1
+	if len(parts) >= 3 {
+		var err error
+		if count, err = strconv.Atoi(parts[2]); err != nil {
+			sendErrorResponse(w, "invalid count")
+			return
+		}
+	}
+
+	withValues := false
+	if len(parts) == 4 {
+		if strings.ToUpper(parts[3]) != "WITHVALUES" {
+			sendErrorResponse(w, "invalid command format")
+			return
+		}
+		withValues = true
+	}
+
+	fields, err := store.HRandField(parts[1], count, withValues)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	if len(parts) == 2 {
+		if len(fields) == 0 {
+			sendErrorResponse(w, "key not found")
+			return
+		}
+		sendValueResponse(w, fields[0])
+		return
+	}
+
+	sendListResponse(w, fields)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestSRANDMEMBERCountSemantics(t *testing.T) {
+	sendCommand(t, "SADD sample-set a b c d e")
+	members := map[string]bool{"a": true, "b": true, "c": true, "d": true, "e": true}
+
+	// A positive count returns distinct members, capped at the set size
+	for _, command := range []string{"SRANDMEMBER sample-set 3", "SRANDMEMBER sample-set 10"} {
+		var response ListResponse
+		decodeResponse(t, sendCommand(t, command), &response)
+
+		seen := map[string]bool{}
+		for _, member := range response.Value {
+			if !members[member] {
+				t.Errorf("%s: unexpected member %q", command, member)
+			}
+			if seen[member] {
+				t.Errorf("%s: expected distinct members, but %q repeated", command, member)
+			}
+			seen[member] = true
+		}
+	}
+
+	var capped ListResponse
+	decodeResponse(t, sendCommand(t, "SRANDMEMBER sample-set 10"), &capped)
+	if len(capped.Value) != 5 {
+		t.Errorf("Expected a positive count to be capped at 5 members, but got %d", len(capped.Value))
+	}
+
+	// A negative count returns exactly that many members, with repeats allowed
+	var repeated ListResponse
+	decodeResponse(t, sendCommand(t, "SRANDMEMBER sample-set -20"), &repeated)
+	if len(repeated.Value) != 20 {
+		t.Errorf("Expected 20 members for a negative count, but got %d", len(repeated.Value))
+	}
+	for _, member := range repeated.Value {
+		if !members[member] {
+			t.Errorf("Unexpected member %q", member)
+		}
+	}
+}
+
+func TestHRANDFIELDWithValues(t *testing.T) {
+	sendCommand(t, "HSET sample-hash f1 v1 f2 v2 f3 v3")
+
+	var response ListResponse
+	decodeResponse(t, sendCommand(t, "HRANDFIELD sample-hash 2 WITHVALUES"), &response)
+
+	if len(response.Value) != 4 {
+		t.Fatalf("Expected 2 field-value pairs, but got %q", response.Value)
+	}
+	for i := 0; i < len(response.Value); i += 2 {
+		if "v"+response.Value[i][1:] != response.Value[i+1] {
+			t.Errorf("Expected field %q to be followed by its value, but got %q", response.Value[i], response.Value[i+1])
+		}
+	}
+	if response.Value[0] == response.Value[2] {
+		t.Errorf("Expected distinct fields for a positive count, but got %q twice", response.Value[0])
+	}
+}
+
+func TestRandomCountIsBounded(t *testing.T) {
+	sendCommand(t, "SADD bounded-set a b")
+	sendCommand(t, "HSET bounded-hash f v")
+
+	for _, command := range []string{
+		"SRANDMEMBER bounded-set " + strconv.Itoa(-maxRandomCount-1),
+		"SRANDMEMBER bounded-set -9223372036854775808",
+		"HRANDFIELD bounded-hash -9223372036854775808",
+	} {
+		if rr := sendCommand(t, command); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, but got %d", command, http.StatusBadRequest, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCRC64Jones(t *testing.T) {
+	// Check value of the CRC-64 variant used by Redis
+	if sum := crc64Jones(0, []byte("123456789")); sum != 0xe9c6d914c4b8d9ca {
+		t.Errorf("Expected checksum 0xe9c6d914c4b8d9ca, but got %#x", sum)
+	}
+}
+
+func TestRDBRoundTrip(t *testing.T) {
+	expiry := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	source := &KeyValueStore{Data: map[string]*KeyValue{
+		"greeting": {Kind: kindString, Value: []string{"hello world"}, ExpiryTime: &expiry},
+		"counter":  {Kind: kindString, Value: []string{"42"}},
+		"queue":    {Kind: kindList, Value: []string{"a", "b c", ""}},
+		"long":     {Kind: kindString, Value: []string{string(bytes.Repeat([]byte("x"), 20000))}},
+		"members":  {Kind: kindSet, Set: map[string]struct{}{"x": {}, "y": {}}},
+		"fields":   {Kind: kindHash, Hash: map[string]string{"f": "v"}},
+	}}
+
+	var buf bytes.Buffer
+	if err := source.WriteRDB(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(buf.Bytes(), []byte("REDIS0009")) {
+		t.Errorf("Expected an RDB version 9 header, but got %q", buf.Bytes()[:9])
+	}
+
+	data, err := ReadRDB(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data) != len(source.Data) {
+		t.Fatalf("Expected %d keys after loading, but got %d", len(source.Data), len(data))
+	}
+	for key, want := range source.Data {
+		got := data[key]
+		if got.Kind != want.Kind || !reflect.DeepEqual(got.Value, want.Value) ||
+			!reflect.DeepEqual(got.Set, want.Set) || !reflect.DeepEqual(got.Hash, want.Hash) {
+			t.Errorf("%s: expected %+v, but got %+v", key, want, got)
+		}
+	}
+	if got := data["greeting"].ExpiryTime; got == nil || !got.Equal(expiry) {
+		t.Errorf("Expected expiry %v to survive the round trip, but got %v", expiry, got)
+	}
+
+	// Any corruption is caught by the checksum
+	corrupted := append([]byte(nil), buf.Bytes()...)
+	corrupted[20] ^= 0xFF
+	if _, err := ReadRDB(bytes.NewReader(corrupted)); err == nil {
+		t.Error("Expected a corrupted file to be rejected")
+	}
+}
+
+func TestRDBRejectsLengthsPastTheInput(t *testing.T) {
+	header := "REDIS0009"
+	cases := map[string]string{
+		// A string whose 64-bit length does not fit an int
+		"huge string": header + "\x00\x01k\x81\xff\xff\xff\xff\xff\xff\xff\xff",
+		// A 2 GB string in a file a few bytes long
+		"long string": header + "\x00\x01k\x80\x7f\xff\xff\xffabc",
+		// A list claiming 2^64-1 entries, which overflows once multiplied
+		"huge list": header + "\x01\x01k\x81\xff\xff\xff\xff\xff\xff\xff\xff",
+		// A hash claiming a billion pairs with one present
+		"long hash": header + "\x04\x01k\x80\x40\x00\x00\x00\x01f\x01v",
+	}
+	for name, file := range cases {
+		if _, err := ReadRDB(strings.NewReader(file)); err != errInvalidRDB {
+			t.Errorf("%s: expected %v, but got %v", name, errInvalidRDB, err)
+		}
+	}
+}
+
+func TestDumpRDBEndpoint(t *testing.T) {
+	sendCommand(t, "SET rdb-endpoint-key value")
+
+	req, err := http.NewRequest("GET", "/dump.rdb", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	handleDumpRDB(rr, req)
+
+	data, err := ReadRDB(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kv, ok := data["rdb-endpoint-key"]; !ok || kv.Value[0] != "value" {
+		t.Errorf("Expected the exported keyspace to contain rdb-endpoint-key")
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// keyRoutePrefix is the path prefix of the RESTful single-key routes.
+const keyRoutePrefix = "/kv/"
+
+// keyRouteCommands are the commands the /kv/ routes run, by method.
+var keyRouteCommands = map[string]string{
+	http.MethodGet:    "GET",
+	http.MethodPut:    "SET",
+	http.MethodDelete: "DEL",
+}
+
+// handleKeyRequest maps RESTful routes onto the store, alongside the command endpoint:
+//
+//	GET    /kv/{key}          -> GET key
+//	PUT    /kv/{key}?ex=secs  -> SET key <body> [EXsecs]
+//	DELETE /kv/{key}          -> DEL key
+//
+// Keys are URL-decoded, so a key containing slashes can be sent as /kv/a%2Fb,
+// and are prefixed with the X-Key-Namespace header like command keys. A route
+// is refused when its command is renamed or disabled.
+func handleKeyRequest(w http.ResponseWriter, r *http.Request) {
+	key, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), keyRoutePrefix))
+	if err != nil || key == "" {
+		sendErrorResponse(w, "invalid key")
+		return
+	}
+	namespace, err := keyNamespace(r)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+	key = namespace + key
+	w = &formatWriter{ResponseWriter: w, format: formatArray, namespace: namespace}
+	if name, ok := keyRouteCommands[r.Method]; ok && !allowRoute(w, name) {
+		return
+	}
+
+	// Keep memory under the configured limit once the request has run
+	defer store.evictIfNeeded(key)
+
+	switch r.Method {
+	case http.MethodGet:
+		value, err := store.Get(key)
+		if err != nil {
+			sendReadError(w, err)
+			return
+		}
+		sendValueResponse(w, value)
+
+	case http.MethodPut:
+		defer r.Body.Close()
+		if !admitWrite(w, "SET") {
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			sendErrorResponse(w, "invalid request")
+			return
+		}
+
+		var expiryTime *time.Time
+		if ex := r.URL.Query().Get("ex"); ex != "" {
+			seconds, err := strconv.Atoi(ex)
+			if err != nil || seconds <= 0 {
+				sendErrorResponse(w, "invalid expiry time")
+				return
+			}
+			expiry := clock.Now().Add(time.Duration(seconds) * time.Second)
+			expiryTime = &expiry
+		}
+
+		if err := store.Set(key, string(body), expiryTime, ""); err != nil {
+			sendErrorResponse(w, err.Error())
+			return
+		}
+		sendOKResponse(w)
+
+	case http.MethodDelete:
+		sendIntegerResponse(w, int64(store.Del(key)))
+
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "method not allowed"})
+	}
+}
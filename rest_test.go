@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sendKeyRequest sends a request to the RESTful key routes.
+func sendKeyRequest(t *testing.T, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req, err := http.NewRequest(method, target, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handleKeyRequest(rr, req)
+	return rr
+}
+
+func TestRESTKeyRoutes(t *testing.T) {
+	// PUT stores the body verbatim, spaces included
+	if rr := sendKeyRequest(t, "PUT", "/kv/rest-key", "hello rest world"); rr.Code != http.StatusOK {
+		t.Fatalf("PUT: expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+
+	rr := sendKeyRequest(t, "GET", "/kv/rest-key", "")
+	var response ValueResponse
+	decodeResponse(t, rr, &response)
+	if rr.Code != http.StatusOK || response.Value != "hello rest world" {
+		t.Errorf("GET: expected %q, but got %d %q", "hello rest world", rr.Code, response.Value)
+	}
+
+	var deleted IntegerResponse
+	decodeResponse(t, sendKeyRequest(t, "DELETE", "/kv/rest-key", ""), &deleted)
+	if deleted.Value != 1 {
+		t.Errorf("DELETE: expected 1 key deleted, but got %d", deleted.Value)
+	}
+
+	if rr := sendKeyRequest(t, "GET", "/kv/rest-key", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("GET after DELETE: expected status code %d, but got %d", http.StatusNotFound, rr.Code)
+	}
+
+	if rr := sendKeyRequest(t, "POST", "/kv/rest-key", ""); rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: expected status code %d, but got %d", http.StatusMethodNotAllowed, rr.Code)
+	}
+}
+
+func TestRESTKeyWithSlashAndTTL(t *testing.T) {
+	if rr := sendKeyRequest(t, "PUT", "/kv/tenant%2F42%2Fconfig?ex=60", "v"); rr.Code != http.StatusOK {
+		t.Fatalf("PUT: expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+
+	// The encoded slashes are part of the key
+	value, err := store.Get("tenant/42/config")
+	if err != nil || value != "v" {
+		t.Errorf("Expected key tenant/42/config to hold v, but got %q (%v)", value, err)
+	}
+
+	store.mutex.RLock()
+	expiry := store.Data["tenant/42/config"].ExpiryTime
+	store.mutex.RUnlock()
+	if expiry == nil || time.Until(*expiry) < 50*time.Second {
+		t.Errorf("Expected a TTL of about 60 seconds, but got expiry %v", expiry)
+	}
+
+	if rr := sendKeyRequest(t, "PUT", "/kv/bad-ttl?ex=abc", "v"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid ex to be rejected, but got %d", rr.Code)
+	}
+}
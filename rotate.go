@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Rotate moves the active key prefix:current to a dated archive key,
+// prefix:YYYY-MM-DD in the server's local time, and returns the archive's
+// name. The active key is left missing, which every command reads as empty,
+// so the next write starts a fresh value. If the archive already exists, as
+// when rotating twice in a day, a suffix .1, .2, ... is added rather than
+// overwriting it. The move keeps the value's type and TTL.
+func (store *KeyValueStore) Rotate(prefix string) (string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	active := prefix + ":current"
+	kv, ok := store.lookup(active)
+	if !ok {
+		return "", errKeyNotFound
+	}
+
+	archive := prefix + ":" + clock.Now().Format("2006-01-02")
+	for i, base := 1, archive; ; i++ {
+		if _, taken := store.lookup(archive); !taken {
+			break
+		}
+		archive = base + "." + strconv.Itoa(i)
+	}
+
+	store.drop(active)
+	store.insert(archive, kv)
+	if kv.Kind == kindList {
+		store.serveWaiters(archive, kv)
+	}
+	return archive, nil
+}
+
+// handleROTATE handles ROTATE prefix, returning the archive key's name.
+func handleROTATE(w http.ResponseWriter, parts []string) {
+	if len(parts) != 2 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	archive, err := store.Rotate(parts[1])
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	// The name is relative to the request's namespace, like the prefix it was given
+	if fw, ok := w.(*formatWriter); ok {
+		archive = strings.TrimPrefix(archive, fw.namespace)
+	}
+	sendValueResponse(w, archive)
+}
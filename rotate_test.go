@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestROTATEArchivesTheActiveKey(t *testing.T) {
+	fake := useFakeClock(t)
+	fake.Set(time.Date(2024, time.June, 1, 23, 59, 0, 0, time.Local))
+
+	sendCommand(t, "INCR rotated:current")
+	sendCommand(t, "INCR rotated:current")
+
+	var archive ValueResponse
+	decodeResponse(t, sendCommand(t, "ROTATE rotated"), &archive)
+	if archive.Value != "rotated:2024-06-01" {
+		t.Fatalf("Expected the archive rotated:2024-06-01, but got %q", archive.Value)
+	}
+
+	var value ValueResponse
+	decodeResponse(t, sendCommand(t, "GET rotated:2024-06-01"), &value)
+	if value.Value != "2" {
+		t.Errorf("Expected the archive to hold the former count 2, but got %q", value.Value)
+	}
+	if rr := sendCommand(t, "GET rotated:current"); rr.Code != 404 {
+		t.Errorf("Expected the active key to be empty after ROTATE, but GET returned %d %s", rr.Code, rr.Body.String())
+	}
+
+	// A fresh count starts, and a second rotation the same day does not overwrite the first
+	var count ValueResponse
+	decodeResponse(t, sendCommand(t, "INCR rotated:current"), &count)
+	if count.Value != "1" {
+		t.Errorf("Expected the active counter to restart at 1, but got %s", count.Value)
+	}
+	decodeResponse(t, sendCommand(t, "ROTATE rotated"), &archive)
+	if archive.Value != "rotated:2024-06-01.1" {
+		t.Errorf("Expected a suffixed archive, but got %q", archive.Value)
+	}
+
+	if rr := sendCommand(t, "ROTATE rotated"); rr.Code != 400 {
+		t.Errorf("Expected rotating a missing active key to fail, but got %d", rr.Code)
+	}
+}
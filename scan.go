@@ -0,0 +1,257 @@
+package main
+
+import (
+	"container/heap"
+	"hash/crc32"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultScanCount is the number of keys SCAN examines per call when COUNT is not given.
+const defaultScanCount = 10
+
+// ScanResult is a batch of keys and the cursor to continue from; a cursor of 0 means the scan is complete.
+type ScanResult struct {
+	Cursor uint64   `json:"cursor"`
+	Keys   []string `json:"keys"`
+}
+
+// ScanOptions filter the keys returned by Scan. Empty fields match everything.
+type ScanOptions struct {
+	Match string // Glob pattern keys must match
+	Count int    // Number of keys examined per call
+	Kind  string // Type keys must hold (string, list, set, hash)
+}
+
+// scanHash orders the keyspace for SCAN. Ordering by hash rather than by position
+// keeps cursors valid while keys are added and removed between calls.
+func scanHash(key string) uint64 {
+	return uint64(crc32.ChecksumIEEE([]byte(key)))
+}
+
+// hashedKey is a key with its place in the SCAN order.
+type hashedKey struct {
+	hash uint64
+	key  string
+}
+
+func (a hashedKey) before(b hashedKey) bool {
+	if a.hash != b.hash {
+		return a.hash < b.hash
+	}
+	return a.key < b.key
+}
+
+// scanHeap is a heap of keys with the last in SCAN order on top, so the first
+// count keys can be kept while walking the keyspace.
+type scanHeap []hashedKey
+
+func (h scanHeap) Len() int            { return len(h) }
+func (h scanHeap) Less(i, j int) bool  { return h[j].before(h[i]) }
+func (h scanHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *scanHeap) Push(x interface{}) { *h = append(*h, x.(hashedKey)) }
+
+func (h *scanHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// Scan examines up to options.Count keys whose hash is at or after cursor and
+// returns those passing the MATCH and TYPE filters. Every key present for the
+// whole scan is returned at least once. A call walks the keyspace once but
+// only orders the keys it returns, so it costs O(N log count).
+func (store *KeyValueStore) Scan(cursor uint64, options ScanOptions) ScanResult {
+	count := options.Count
+	if count <= 0 {
+		count = defaultScanCount
+	}
+
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	var first scanHeap
+	more := false
+	for key, kv := range store.Data {
+		if kv.isExpired() {
+			continue
+		}
+		candidate := hashedKey{scanHash(key), key}
+		if candidate.hash < cursor {
+			continue
+		}
+		if len(first) < count {
+			heap.Push(&first, candidate)
+			continue
+		}
+		more = true
+		if candidate.before(first[0]) {
+			first[0] = candidate
+			heap.Fix(&first, 0)
+		}
+	}
+	sort.Slice(first, func(i, j int) bool { return first[i].before(first[j]) })
+
+	// Extend the batch to every key sharing the last hash, so that resuming
+	// from the next hash cannot skip any of them.
+	result := ScanResult{Keys: []string{}}
+	batch := []hashedKey(first)
+	if more {
+		last := first[len(first)-1].hash
+		for len(batch) > 0 && batch[len(batch)-1].hash == last {
+			batch = batch[:len(batch)-1]
+		}
+		var tied []hashedKey
+		more = false
+		for key, kv := range store.Data {
+			if kv.isExpired() {
+				continue
+			}
+			switch hash := scanHash(key); {
+			case hash == last:
+				tied = append(tied, hashedKey{hash, key})
+			case hash > last:
+				more = true
+			}
+		}
+		sort.Slice(tied, func(i, j int) bool { return tied[i].key < tied[j].key })
+		batch = append(batch, tied...)
+		if more {
+			result.Cursor = last + 1
+		}
+	}
+
+	for _, candidate := range batch {
+		if options.Match != "" && !globMatch(options.Match, candidate.key) {
+			continue
+		}
+		if options.Kind != "" && !strings.EqualFold(store.Data[candidate.key].Kind, options.Kind) {
+			continue
+		}
+		result.Keys = append(result.Keys, candidate.key)
+	}
+	return result
+}
+
+// globMatch reports whether s matches the Redis-style glob pattern, supporting
+// *, ?, character classes such as [abc], [^a] and [a-z], and backslash escapes.
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			// Collapse consecutive stars, then try every possible split
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 {
+				// An unterminated class matches a literal '['
+				if s[0] != '[' {
+					return false
+				}
+				pattern, s = pattern[1:], s[1:]
+				continue
+			}
+			class := pattern[1 : end+1]
+			if !matchClass(class, s[0]) {
+				return false
+			}
+			pattern, s = pattern[end+2:], s[1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+// matchClass reports whether c is in the bracket expression class (without the brackets).
+func matchClass(class string, c byte) bool {
+	negate := false
+	if len(class) > 0 && class[0] == '^' {
+		negate = true
+		class = class[1:]
+	}
+
+	matched := false
+	for i := 0; i < len(class); i++ {
+		if i+2 < len(class) && class[i+1] == '-' {
+			low, high := class[i], class[i+2]
+			if low > high {
+				low, high = high, low
+			}
+			if c >= low && c <= high {
+				matched = true
+			}
+			i += 2
+			continue
+		}
+		if class[i] == c {
+			matched = true
+		}
+	}
+	return matched != negate
+}
+
+// handleSCAN handles SCAN cursor [MATCH pattern] [COUNT count] [TYPE kind].
+func handleSCAN(w http.ResponseWriter, parts []string) {
+	if len(parts) < 2 || len(parts)%2 != 0 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	cursor, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		sendErrorResponse(w, "invalid cursor")
+		return
+	}
+
+	var options ScanOptions
+	for i := 2; i < len(parts); i += 2 {
+		switch strings.ToUpper(parts[i]) {
+		case "MATCH":
+			options.Match = parts[i+1]
+		case "COUNT":
+			options.Count, err = strconv.Atoi(parts[i+1])
+			if err != nil || options.Count <= 0 {
+				sendErrorResponse(w, "invalid count")
+				return
+			}
+		case "TYPE":
+			options.Kind = parts[i+1]
+		default:
+			sendErrorResponse(w, "invalid command format")
+			return
+		}
+	}
+
+	sendObjectResponse(w, store.Scan(cursor, options))
+}
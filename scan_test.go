@@ -0,0 +1,108 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// scanAll iterates SCAN until the cursor returns to 0 and collects every key.
+func scanAll(t *testing.T, args string) []string {
+	t.Helper()
+
+	var keys []string
+	cursor := uint64(0)
+	for {
+		var response struct {
+			Value ScanResult `json:"value"`
+		}
+		decodeResponse(t, sendCommand(t, "SCAN "+strconv.FormatUint(cursor, 10)+args), &response)
+
+		keys = append(keys, response.Value.Keys...)
+		cursor = response.Value.Cursor
+		if cursor == 0 {
+			break
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestSCANTypeFilter(t *testing.T) {
+	for i := 0; i < 5; i++ {
+		n := strconv.Itoa(i)
+		sendCommand(t, "SET scan-string-"+n+" v")
+		sendCommand(t, "QPUSH scan-list-"+n+" a b")
+		sendCommand(t, "SADD scan-set-"+n+" a b")
+	}
+
+	keys := scanAll(t, " MATCH scan-* COUNT 3 TYPE list")
+
+	expected := []string{"scan-list-0", "scan-list-1", "scan-list-2", "scan-list-3", "scan-list-4"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected %q, but got %q", expected, keys)
+	}
+}
+
+func TestScanReturnsEveryKeyInHashOrder(t *testing.T) {
+	testStore := &KeyValueStore{Data: make(map[string]*KeyValue)}
+	for i := 0; i < 100; i++ {
+		testStore.Data["scan-"+strconv.Itoa(i)] = &KeyValue{Kind: kindString, Value: []string{"v"}}
+	}
+
+	seen := make(map[string]bool)
+	var last uint64
+	for cursor := uint64(0); ; {
+		result := testStore.Scan(cursor, ScanOptions{Count: 7})
+		for _, key := range result.Keys {
+			if seen[key] {
+				t.Errorf("Expected %s to be returned once", key)
+			}
+			seen[key] = true
+			if hash := scanHash(key); hash < last {
+				t.Errorf("Expected keys in hash order, but %s came after hash %d", key, last)
+			} else {
+				last = hash
+			}
+		}
+		if cursor = result.Cursor; cursor == 0 {
+			break
+		}
+	}
+	if len(seen) != 100 {
+		t.Errorf("Expected all 100 keys, but got %d", len(seen))
+	}
+
+	// These two keys share a hash, so a batch of one must return both
+	collisions := &KeyValueStore{Data: map[string]*KeyValue{
+		"scan-16923":    {Kind: kindString, Value: []string{"v"}},
+		"scan-31710000": {Kind: kindString, Value: []string{"v"}},
+	}}
+	result := collisions.Scan(0, ScanOptions{Count: 1})
+	if len(result.Keys) != 2 || result.Cursor != 0 {
+		t.Errorf("Expected both colliding keys in one batch, but got %q with cursor %d", result.Keys, result.Cursor)
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"cache:*", "cache:user/1", true},
+		{"cache:*", "cached", false},
+		{"h?llo", "hello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+	}
+
+	for _, c := range cases {
+		if got := globMatch(c.pattern, c.s); got != c.match {
+			t.Errorf("globMatch(%q, %q) = %v, expected %v", c.pattern, c.s, got, c.match)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scheduleAux names the RDB auxiliary field that carries the scheduled jobs,
+// so they survive a restart with -load.
+const scheduleAux = "greedy-schedule"
+
+// ScheduledJob is a command the server runs on a schedule, as reported by SCHEDULE LIST.
+type ScheduledJob struct {
+	ID        uint64    `json:"id"`
+	Spec      string    `json:"spec"`
+	Command   string    `json:"command"`
+	Namespace string    `json:"namespace,omitempty"` // X-Key-Namespace the job was scheduled with
+	Next      time.Time `json:"next"`
+	Runs      int       `json:"runs"`
+	LastError string    `json:"last_error,omitempty"` // Error reply of the latest run, if it failed
+
+	schedule cronSchedule
+}
+
+// Scheduler runs commands through dispatchRequest on their schedules. Its
+// goroutine starts with the first job. Schedules follow the wall clock, not
+// the store clock, so DEBUG SET-TIME does not fire jobs.
+type Scheduler struct {
+	mutex  sync.Mutex
+	jobs   map[uint64]*ScheduledJob
+	lastID uint64
+	wake   chan struct{} // Signalled when the earliest run time may have changed
+	start  sync.Once
+}
+
+var scheduler = &Scheduler{}
+
+// Add schedules command to run on spec in namespace and returns the job's ID.
+func (s *Scheduler) Add(spec, command, namespace string) (uint64, error) {
+	schedule, err := parseSchedule(spec)
+	if err != nil {
+		return 0, err
+	}
+	if strings.TrimSpace(command) == "" {
+		return 0, errors.New("invalid command")
+	}
+
+	s.mutex.Lock()
+	s.lastID++
+	id := s.lastID
+	s.add(&ScheduledJob{ID: id, Spec: spec, Command: command, Namespace: namespace, schedule: schedule})
+	s.mutex.Unlock()
+
+	s.notify()
+	return id, nil
+}
+
+// add registers job with its next run time. The caller must hold the scheduler lock.
+func (s *Scheduler) add(job *ScheduledJob) {
+	if s.jobs == nil {
+		s.jobs = make(map[uint64]*ScheduledJob)
+	}
+	job.Next = job.schedule.next(time.Now())
+	s.jobs[job.ID] = job
+}
+
+// Remove unschedules the job with the given ID if it was scheduled in
+// namespace, reporting whether it was.
+func (s *Scheduler) Remove(id uint64, namespace string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok || job.Namespace != namespace {
+		return false
+	}
+	delete(s.jobs, id)
+	return true
+}
+
+// List returns the scheduled jobs ordered by ID.
+func (s *Scheduler) List() []ScheduledJob {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	jobs := make([]ScheduledJob, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+	return jobs
+}
+
+// encode returns the jobs as JSON for the RDB snapshot, or "" when there are none.
+func (s *Scheduler) encode() string {
+	jobs := s.List()
+	if len(jobs) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(jobs)
+	return string(data)
+}
+
+// restore replaces the jobs with those encoded in data by encode. Jobs whose
+// schedule no longer parses are dropped.
+func (s *Scheduler) restore(data string) error {
+	var jobs []ScheduledJob
+	if data != "" {
+		if err := json.Unmarshal([]byte(data), &jobs); err != nil {
+			return err
+		}
+	}
+
+	s.mutex.Lock()
+	s.jobs = nil
+	for i := range jobs {
+		job := &jobs[i]
+		schedule, err := parseSchedule(job.Spec)
+		if err != nil {
+			continue
+		}
+		job.schedule = schedule
+		s.add(job)
+		if job.ID > s.lastID {
+			s.lastID = job.ID
+		}
+	}
+	s.mutex.Unlock()
+
+	s.notify()
+	return nil
+}
+
+// notify starts the scheduler goroutine if needed and wakes it to recompute
+// the earliest run time.
+func (s *Scheduler) notify() {
+	s.start.Do(func() {
+		s.wake = make(chan struct{}, 1)
+		go s.run()
+	})
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run fires each job when it is due, then schedules its next run. Jobs run in
+// their own goroutines, so a slow or blocking command does not delay others.
+func (s *Scheduler) run() {
+	timer := time.NewTimer(time.Hour)
+	for {
+		s.mutex.Lock()
+		now := time.Now()
+		wait := time.Hour
+		for _, job := range s.jobs {
+			if !job.Next.After(now) {
+				job.Next = job.schedule.next(now)
+				go s.fire(job.ID, job.Command, job.Namespace)
+			}
+			if job.Next.IsZero() {
+				delete(s.jobs, job.ID)
+				continue
+			}
+			if d := job.Next.Sub(now); d < wait {
+				wait = d
+			}
+		}
+		s.mutex.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// fire runs a job's command through dispatchRequest, as a client request
+// would, and records the outcome.
+func (s *Scheduler) fire(id uint64, command, namespace string) {
+	status, body := runCommand(command, namespace)
+
+	var response ErrorResponse
+	if status != http.StatusOK {
+		json.Unmarshal(body, &response)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if job, ok := s.jobs[id]; ok {
+		job.Runs++
+		job.LastError = response.Error
+	}
+}
+
+// splitQuoted splits s on spaces, treating a double-quoted string, with Go
+// escapes, as a single argument.
+func splitQuoted(s string) ([]string, error) {
+	var args []string
+	for {
+		s = strings.TrimLeft(s, " ")
+		if s == "" {
+			return args, nil
+		}
+		if s[0] != '"' {
+			end := strings.IndexByte(s, ' ')
+			if end < 0 {
+				end = len(s)
+			}
+			args = append(args, s[:end])
+			s = s[end:]
+			continue
+		}
+
+		quoted, err := strconv.QuotedPrefix(s)
+		if err != nil {
+			return nil, errors.New("unbalanced quotes")
+		}
+		arg, _ := strconv.Unquote(quoted)
+		args = append(args, arg)
+		s = s[len(quoted):]
+	}
+}
+
+// handleSCHEDULE handles SCHEDULE "spec" "command", returning the new job's
+// ID, SCHEDULE LIST and SCHEDULE REMOVE id, returning 1 if the job existed.
+// Jobs belong to the request's namespace and run in it.
+func handleSCHEDULE(w http.ResponseWriter, parts []string) {
+	var namespace string
+	if fw, ok := w.(*formatWriter); ok {
+		namespace = strings.TrimSuffix(fw.namespace, ":")
+	}
+
+	switch strings.ToUpper(parts[1]) {
+	case "LIST":
+		if len(parts) != 2 {
+			sendErrorResponse(w, "invalid command format")
+			return
+		}
+		jobs := []ScheduledJob{}
+		for _, job := range scheduler.List() {
+			if job.Namespace == namespace {
+				jobs = append(jobs, job)
+			}
+		}
+		sendObjectResponse(w, jobs)
+		return
+	case "REMOVE":
+		if len(parts) != 3 {
+			sendErrorResponse(w, "invalid command format")
+			return
+		}
+		id, err := strconv.ParseUint(parts[2], 10, 64)
+		if err != nil {
+			sendErrorResponse(w, "invalid job id")
+			return
+		}
+		if scheduler.Remove(id, namespace) {
+			sendIntegerResponse(w, 1)
+			return
+		}
+		sendIntegerResponse(w, 0)
+		return
+	}
+
+	args, err := splitQuoted(strings.Join(parts[1:], " "))
+	if err != nil || len(args) != 2 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	id, err := scheduler.Add(args[0], args[1], namespace)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+	sendIntegerResponse(w, int64(id))
+}
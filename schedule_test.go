@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	from := time.Date(2024, time.January, 31, 10, 17, 30, 0, time.UTC) // A Wednesday
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"*/30 * * * *", time.Date(2024, time.January, 31, 10, 30, 0, 0, time.UTC)},
+		{"0 9-17 * * 1-5", time.Date(2024, time.January, 31, 11, 0, 0, 0, time.UTC)},
+		{"15 2 * * 7", time.Date(2024, time.February, 4, 2, 15, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		// Both day fields restricted: the 1st of the month or any Friday
+		{"0 0 1 * 5", time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		schedule, err := parseSchedule(tt.spec)
+		if err != nil {
+			t.Fatalf("parseSchedule(%q): %v", tt.spec, err)
+		}
+		if got := schedule.next(from); !got.Equal(tt.want) {
+			t.Errorf("Expected %q to run next at %v, but got %v", tt.spec, tt.want, got)
+		}
+	}
+
+	// Hours in a half-hour zone start half way through UTC hours
+	kolkata := time.FixedZone("IST", 5*60*60+30*60)
+	schedule, _ := parseSchedule("0 9 * * *")
+	want := time.Date(2024, time.February, 1, 9, 0, 0, 0, kolkata)
+	if got := schedule.next(time.Date(2024, time.January, 31, 10, 17, 30, 0, kolkata)); !got.Equal(want) {
+		t.Errorf("Expected a run at %v in a half-hour zone, but got %v", want, got)
+	}
+
+	for _, spec := range []string{"* * * *", "60 * * * *", "0 0 30 2 *", "*/0 * * * *", "@every -1s", "@every 1ns", "@every 999ms"} {
+		if _, err := parseSchedule(spec); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+}
+
+func TestSCHEDULERunsListsAndRemovesJobs(t *testing.T) {
+	saved := minEveryInterval
+	t.Cleanup(func() { minEveryInterval = saved })
+	minEveryInterval = time.Millisecond
+
+	var id IntegerResponse
+	decodeResponse(t, sendCommand(t, `SCHEDULE "@every 20ms" "INCR scheduled-counter"`), &id)
+	if id.Value == 0 {
+		t.Fatal("Expected SCHEDULE to return a job ID")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var value ValueResponse
+		rr := sendCommand(t, "GET scheduled-counter")
+		if rr.Code == 200 {
+			decodeResponse(t, rr, &value)
+			if n, _ := strconv.Atoi(value.Value); n >= 2 {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the scheduled INCR to run at least twice")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var list struct {
+		Value []ScheduledJob `json:"value"`
+	}
+	decodeResponse(t, sendCommand(t, "SCHEDULE LIST"), &list)
+	if len(list.Value) != 1 || list.Value[0].Command != "INCR scheduled-counter" || list.Value[0].Spec != "@every 20ms" {
+		t.Fatalf("Expected SCHEDULE LIST to show the job, but got %+v", list.Value)
+	}
+
+	// The job survives a snapshot round trip
+	sendCommand(t, "DEBUG RELOAD")
+	decodeResponse(t, sendCommand(t, "SCHEDULE LIST"), &list)
+	if len(list.Value) != 1 || list.Value[0].ID != uint64(id.Value) {
+		t.Fatalf("Expected the job to survive DEBUG RELOAD, but got %+v", list.Value)
+	}
+
+	var removed IntegerResponse
+	decodeResponse(t, sendCommand(t, "SCHEDULE REMOVE "+strconv.FormatInt(id.Value, 10)), &removed)
+	if removed.Value != 1 {
+		t.Errorf("Expected SCHEDULE REMOVE to return 1, but got %d", removed.Value)
+	}
+	decodeResponse(t, sendCommand(t, "SCHEDULE REMOVE "+strconv.FormatInt(id.Value, 10)), &removed)
+	if removed.Value != 0 {
+		t.Errorf("Expected removing the job again to return 0, but got %d", removed.Value)
+	}
+	decodeResponse(t, sendCommand(t, "SCHEDULE LIST"), &list)
+	if len(list.Value) != 0 {
+		t.Errorf("Expected no jobs after SCHEDULE REMOVE, but got %+v", list.Value)
+	}
+}
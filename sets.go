@@ -0,0 +1,283 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errWrongType = errors.New("operation against a key holding the wrong kind of value")
+
+// setOperation identifies a set algebra operation.
+type setOperation int
+
+const (
+	setIntersection setOperation = iota
+	setUnion
+	setDifference
+)
+
+// lookupSet returns the set stored at key, or nil when the key is missing.
+// The caller must hold the store mutex.
+func (store *KeyValueStore) lookupSet(key string) (map[string]struct{}, error) {
+	kv, ok := store.lookup(key)
+	if !ok {
+		return nil, nil
+	}
+	if kv.Set == nil {
+		return nil, errWrongType
+	}
+	return kv.Set, nil
+}
+
+// SAdd adds members to the set stored at key and returns how many were newly added.
+func (store *KeyValueStore) SAdd(key string, members []string) (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	set, err := store.lookupSet(key)
+	if err != nil {
+		return 0, err
+	}
+	if set == nil {
+		set = make(map[string]struct{})
+		store.insert(key, &KeyValue{Kind: kindSet, Set: set})
+	}
+
+	added := 0
+	for _, member := range members {
+		if _, ok := set[member]; !ok {
+			set[member] = struct{}{}
+			added++
+		}
+	}
+	return added, nil
+}
+
+// SMembers returns the members of the set stored at key in sorted order.
+func (store *KeyValueStore) SMembers(key string) ([]string, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	set, err := store.lookupSet(key)
+	if err != nil {
+		return nil, err
+	}
+	return sortedMembers(set), nil
+}
+
+// SMove atomically moves member from the set at src to the set at dst.
+// It returns 1 if the member was moved and 0 if it was not in src.
+func (store *KeyValueStore) SMove(src, dst, member string) (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	srcSet, err := store.lookupSet(src)
+	if err != nil {
+		return 0, err
+	}
+	dstSet, err := store.lookupSet(dst)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, ok := srcSet[member]; !ok {
+		return 0, nil
+	}
+	if src == dst {
+		return 1, nil
+	}
+
+	delete(srcSet, member)
+	if len(srcSet) == 0 {
+		store.drop(src)
+	}
+
+	if dstSet == nil {
+		dstSet = make(map[string]struct{})
+		store.insert(dst, &KeyValue{Kind: kindSet, Set: dstSet})
+	}
+	dstSet[member] = struct{}{}
+
+	return 1, nil
+}
+
+// computeSet applies op across the sets stored at keys. Missing keys count as empty sets.
+// The caller must hold the store mutex.
+func (store *KeyValueStore) computeSet(op setOperation, keys []string) (map[string]struct{}, error) {
+	sets := make([]map[string]struct{}, len(keys))
+	for i, key := range keys {
+		set, err := store.lookupSet(key)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = set
+	}
+
+	result := make(map[string]struct{})
+	switch op {
+	case setUnion:
+		for _, set := range sets {
+			for member := range set {
+				result[member] = struct{}{}
+			}
+		}
+	case setIntersection:
+		for member := range sets[0] {
+			inAll := true
+			for _, set := range sets[1:] {
+				if _, ok := set[member]; !ok {
+					inAll = false
+					break
+				}
+			}
+			if inAll {
+				result[member] = struct{}{}
+			}
+		}
+	case setDifference:
+		for member := range sets[0] {
+			inOther := false
+			for _, set := range sets[1:] {
+				if _, ok := set[member]; ok {
+					inOther = true
+					break
+				}
+			}
+			if !inOther {
+				result[member] = struct{}{}
+			}
+		}
+	}
+	return result, nil
+}
+
+// SetOpStore computes op across keys and stores the result at dest, returning its cardinality.
+// The destination is overwritten, or deleted when the result is empty. A
+// positive ttl sets the stored result to expire, in the same step.
+func (store *KeyValueStore) SetOpStore(op setOperation, dest string, keys []string, ttl time.Duration) (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	result, err := store.computeSet(op, keys)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(result) == 0 {
+		store.drop(dest)
+		return 0, nil
+	}
+
+	kv := &KeyValue{Kind: kindSet, Set: result}
+	store.insert(dest, kv)
+	kv.refreshTTL(ttl)
+	return len(result), nil
+}
+
+// sortedMembers returns the members of set in lexical order.
+func sortedMembers(set map[string]struct{}) []string {
+	members := make([]string, 0, len(set))
+	for member := range set {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members
+}
+
+// handleSADD handles SADD key member...
+func handleSADD(w http.ResponseWriter, parts []string) {
+	if len(parts) < 3 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	added, err := store.SAdd(parts[1], parts[2:])
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendIntegerResponse(w, int64(added))
+}
+
+// handleSMEMBERS handles SMEMBERS key.
+func handleSMEMBERS(w http.ResponseWriter, parts []string) {
+	if len(parts) != 2 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	members, err := store.SMembers(parts[1])
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendListResponse(w, members)
+}
+
+// handleSMOVE handles SMOVE source dest member.
+func handleSMOVE(w http.ResponseWriter, parts []string) {
+	if len(parts) != 4 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	moved, err := store.SMove(parts[1], parts[2], parts[3])
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendIntegerResponse(w, int64(moved))
+}
+
+// setOpStoreKeys returns the index of the first source key in a SINTERSTORE,
+// SUNIONSTORE or SDIFFSTORE command, which follows the optional EX seconds.
+func setOpStoreKeys(parts []string) int {
+	if len(parts) > 4 && strings.EqualFold(parts[2], "EX") {
+		return 4
+	}
+	return 2
+}
+
+// handleSetOpStore handles SINTERSTORE, SUNIONSTORE and SDIFFSTORE dest [EX seconds] key...
+func handleSetOpStore(w http.ResponseWriter, parts []string) {
+	if len(parts) < 3 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	var op setOperation
+	switch strings.ToUpper(parts[0]) {
+	case "SINTERSTORE":
+		op = setIntersection
+	case "SUNIONSTORE":
+		op = setUnion
+	case "SDIFFSTORE":
+		op = setDifference
+	}
+
+	first := setOpStoreKeys(parts)
+	var ttl time.Duration
+	if first == 4 {
+		seconds, err := strconv.Atoi(parts[3])
+		if err != nil || seconds <= 0 {
+			sendErrorResponse(w, "invalid expiry time")
+			return
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+
+	count, err := store.SetOpStore(op, parts[1], parts[first:], ttl)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendIntegerResponse(w, int64(count))
+}
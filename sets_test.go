@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSINTERSTORE(t *testing.T) {
+	sendCommand(t, "SADD team-a alice bob carol")
+	sendCommand(t, "SADD team-b bob carol dave")
+
+	rr := sendCommand(t, "SINTERSTORE both team-a team-b")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d", http.StatusOK, rr.Code)
+	}
+
+	var count IntegerResponse
+	decodeResponse(t, rr, &count)
+	if count.Value != 2 {
+		t.Errorf("Expected cardinality 2, but got %d", count.Value)
+	}
+
+	var members ListResponse
+	decodeResponse(t, sendCommand(t, "SMEMBERS both"), &members)
+	if expected := []string{"bob", "carol"}; !reflect.DeepEqual(members.Value, expected) {
+		t.Errorf("Expected %q, but got %q", expected, members.Value)
+	}
+
+	// An empty result deletes the destination
+	sendCommand(t, "SDIFFSTORE both team-a team-a")
+	if _, ok := store.Data["both"]; ok {
+		t.Errorf("Expected an empty result to delete the destination key")
+	}
+}
+
+func TestSINTERSTOREWithExpiry(t *testing.T) {
+	fake := useFakeClock(t)
+	sendCommand(t, "SADD cached-a alice bob carol")
+	sendCommand(t, "SADD cached-b bob carol dave")
+
+	var count IntegerResponse
+	decodeResponse(t, sendCommand(t, "SINTERSTORE cached-both EX 60 cached-a cached-b"), &count)
+	if count.Value != 2 {
+		t.Errorf("Expected cardinality 2, but got %d", count.Value)
+	}
+
+	var members ListResponse
+	decodeResponse(t, sendCommand(t, "SMEMBERS cached-both"), &members)
+	if expected := []string{"bob", "carol"}; !reflect.DeepEqual(members.Value, expected) {
+		t.Errorf("Expected %q, but got %q", expected, members.Value)
+	}
+	if got := store.ExpireTime("cached-both"); got != fake.Now().Add(60*time.Second).Unix() {
+		t.Errorf("Expected the result to expire in 60s, but expiry is %d", got)
+	}
+
+	// Inside a namespace EX and its seconds are not prefixed as if they were keys
+	sendNamespacedCommand(t, "tenant", "SADD a x y")
+	sendNamespacedCommand(t, "tenant", "SADD b y z")
+	decodeResponse(t, sendNamespacedCommand(t, "tenant", "SUNIONSTORE all EX 30 a b"), &count)
+	if count.Value != 3 || store.ExpireTime("tenant:all") != fake.Now().Add(30*time.Second).Unix() {
+		t.Errorf("Expected tenant:all with 3 members and a 30s TTL, but got %d members", count.Value)
+	}
+}
+
+func TestSMoveIsAtomic(t *testing.T) {
+	if _, err := store.SAdd("smove-left", []string{"token", "anchor"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.SAdd("smove-right", []string{"anchor"}); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	// Two movers keep shuttling the member between the sets
+	for _, pair := range [][2]string{{"smove-left", "smove-right"}, {"smove-right", "smove-left"}} {
+		wg.Add(1)
+		go func(src, dst string) {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					if _, err := store.SMove(src, dst, "token"); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}(pair[0], pair[1])
+	}
+
+	// The member must always be in exactly one of the two sets
+	for i := 0; i < 2000; i++ {
+		store.mutex.RLock()
+		_, inLeft := store.Data["smove-left"].Set["token"]
+		_, inRight := store.Data["smove-right"].Set["token"]
+		store.mutex.RUnlock()
+
+		if inLeft == inRight {
+			t.Fatalf("Expected the member in exactly one set, but inLeft=%v inRight=%v", inLeft, inRight)
+		}
+	}
+
+	close(done)
+	wg.Wait()
+
+	moved, err := store.SMove("smove-left", "smove-left", "anchor")
+	if err != nil || moved != 1 {
+		t.Errorf("Expected moving within the same set to return 1, but got %d (%v)", moved, err)
+	}
+}
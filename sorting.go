@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var errNotNumber = errors.New("not a valid number")
+
+// SortOptions controls the order and paging of SORT.
+type SortOptions struct {
+	Alpha  bool // Compare elements as strings rather than numbers
+	Desc   bool
+	Offset int
+	Count  int // Number of elements to return after Offset; negative returns all
+}
+
+// Sort returns the elements of the list or set at key in sorted order without
+// changing the stored value. Elements are compared as numbers unless Alpha is set.
+func (store *KeyValueStore) Sort(key string, options SortOptions) ([]string, error) {
+	store.mutex.RLock()
+	kv, ok := store.lookup(key)
+	var elements []string
+	if ok {
+		switch kv.Kind {
+		case kindList:
+			elements = append(elements, kv.Value...)
+		case kindSet:
+			elements = sortedMembers(kv.Set)
+		default:
+			store.mutex.RUnlock()
+			return nil, errWrongType
+		}
+	}
+	store.mutex.RUnlock()
+
+	if options.Alpha {
+		sort.SliceStable(elements, func(i, j int) bool {
+			if options.Desc {
+				return elements[i] > elements[j]
+			}
+			return elements[i] < elements[j]
+		})
+	} else {
+		numbers := make([]float64, len(elements))
+		for i, element := range elements {
+			n, err := strconv.ParseFloat(element, 64)
+			if err != nil {
+				return nil, errNotNumber
+			}
+			numbers[i] = n
+		}
+
+		order := make([]int, len(elements))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			if options.Desc {
+				return numbers[order[i]] > numbers[order[j]]
+			}
+			return numbers[order[i]] < numbers[order[j]]
+		})
+
+		sorted := make([]string, len(elements))
+		for i, index := range order {
+			sorted[i] = elements[index]
+		}
+		elements = sorted
+	}
+
+	if options.Offset > 0 {
+		if options.Offset >= len(elements) {
+			return nil, nil
+		}
+		elements = elements[options.Offset:]
+	}
+	if options.Count >= 0 && options.Count < len(elements) {
+		elements = elements[:options.Count]
+	}
+	return elements, nil
+}
+
+// handleSORT handles SORT key [ALPHA] [LIMIT offset count] [ASC|DESC].
+func handleSORT(w http.ResponseWriter, parts []string) {
+	if len(parts) < 2 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	options := SortOptions{Count: -1}
+	for i := 2; i < len(parts); i++ {
+		switch strings.ToUpper(parts[i]) {
+		case "ALPHA":
+			options.Alpha = true
+		case "ASC":
+			options.Desc = false
+		case "DESC":
+			options.Desc = true
+		case "LIMIT":
+			if i+2 >= len(parts) {
+				sendErrorResponse(w, "invalid command format")
+				return
+			}
+			offset, err := strconv.Atoi(parts[i+1])
+			if err != nil {
+				sendErrorResponse(w, "invalid offset")
+				return
+			}
+			count, err := strconv.Atoi(parts[i+2])
+			if err != nil {
+				sendErrorResponse(w, "invalid count")
+				return
+			}
+			options.Offset, options.Count = offset, count
+			i += 2
+		default:
+			sendErrorResponse(w, unexpectedToken(parts, i, "ALPHA, LIMIT, ASC, or DESC"))
+			return
+		}
+	}
+
+	elements, err := store.Sort(parts[1], options)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendListResponse(w, elements)
+}
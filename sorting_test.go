@@ -0,0 +1,47 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSORT(t *testing.T) {
+	sendCommand(t, "QPUSH sort-scores 10 2 33 -1 2.5")
+	sendCommand(t, "SADD sort-names carol alice bob dave")
+	sendCommand(t, "QPUSH sort-mixed 1 two 3")
+
+	tests := []struct {
+		command  string
+		expected []string
+	}{
+		{"SORT sort-scores", []string{"-1", "2", "2.5", "10", "33"}},
+		{"SORT sort-scores DESC", []string{"33", "10", "2.5", "2", "-1"}},
+		{"SORT sort-scores ALPHA", []string{"-1", "10", "2", "2.5", "33"}},
+		{"SORT sort-names ALPHA", []string{"alice", "bob", "carol", "dave"}},
+		{"SORT sort-names ALPHA DESC", []string{"dave", "carol", "bob", "alice"}},
+		{"SORT sort-scores LIMIT 1 2", []string{"2", "2.5"}},
+		{"SORT sort-scores LIMIT 3 10", []string{"10", "33"}},
+		{"SORT sort-names ALPHA LIMIT 10 2", []string{}},
+		{"SORT sort-missing", []string{}},
+	}
+	for _, test := range tests {
+		var response ListResponse
+		decodeResponse(t, sendCommand(t, test.command), &response)
+		if !reflect.DeepEqual(response.Value, test.expected) {
+			t.Errorf("%s: expected %q, but got %q", test.command, test.expected, response.Value)
+		}
+	}
+
+	// The stored order is left alone
+	var stored ListResponse
+	decodeResponse(t, sendCommand(t, "LRANGE sort-scores 0 -1"), &stored)
+	if expected := []string{"10", "2", "33", "-1", "2.5"}; !reflect.DeepEqual(stored.Value, expected) {
+		t.Errorf("Expected the list to keep its order %q, but got %q", expected, stored.Value)
+	}
+
+	var response ErrorResponse
+	decodeResponse(t, sendCommand(t, "SORT sort-mixed"), &response)
+	if response.Error != "not a valid number" {
+		t.Errorf("Expected %q, but got %q", "not a valid number", response.Error)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sseKeepAlive is how often an idle event stream gets a comment line, so
+// browsers and proxies do not time the connection out.
+var sseKeepAlive = 15 * time.Second
+
+// sseCommands are the blocking commands GET /events runs.
+var sseCommands = map[string]bool{"BQPOP": true, "BLMOVE": true, "BQDRAIN": true, "WATCHGET": true}
+
+// eventStream writes Server-Sent Events to a response.
+type eventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// newEventStream starts a text/event-stream response on w.
+func newEventStream(w http.ResponseWriter) *eventStream {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	s := &eventStream{w: w}
+	s.flusher, _ = w.(http.Flusher)
+	s.flush()
+	return s
+}
+
+func (s *eventStream) flush() {
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+}
+
+// send writes an event whose data is a single line of JSON. An empty id is left out.
+func (s *eventStream) send(event, id string, data []byte) error {
+	if id != "" {
+		if _, err := fmt.Fprintf(s.w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, strings.TrimRight(string(data), "\n")); err != nil {
+		return err
+	}
+	s.flush()
+	return nil
+}
+
+// keepAlive writes a comment line, which EventSource ignores.
+func (s *eventStream) keepAlive() error {
+	if _, err := fmt.Fprint(s.w, ": keep-alive\n\n"); err != nil {
+		return err
+	}
+	s.flush()
+	return nil
+}
+
+// handleEvents serves blocking commands and pub/sub as Server-Sent Events, for
+// browsers using EventSource:
+//
+//	GET /events?command=BQPOP+jobs+30[&namespace=ns]
+//	GET /events?channel=a&channel=b[&replay=offset]
+//
+// A command (BQPOP, BLMOVE, BQDRAIN or WATCHGET) runs once; its reply is sent
+// as a "result" event, or an "error" event if it failed, and the stream ends.
+// Channels stream a "message" event per message, with the offset as the event
+// ID, so a reconnecting EventSource resumes from history through Last-Event-ID.
+// Idle streams get a comment every sseKeepAlive. A client that disconnects
+// while blocked stops waiting, and any value taken for it is given back; other
+// streams on the same connection are unaffected.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		sendStatusErrorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	if channels := query["channel"]; len(channels) > 0 {
+		streamMessages(w, r, channels)
+		return
+	}
+
+	command := query.Get("command")
+	name, err := resolveCommand(strings.SplitN(command, " ", 2)[0])
+	if err != nil || !sseCommands[strings.ToUpper(name)] {
+		sendErrorResponse(w, "only BQPOP, BLMOVE, BQDRAIN and WATCHGET can be streamed")
+		return
+	}
+	namespace := r.Header.Get(namespaceHeader)
+	if namespace == "" {
+		namespace = query.Get("namespace")
+	}
+
+	type reply struct {
+		status int
+		body   []byte
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	done := make(chan reply, 1)
+	go func() {
+		status, body := runCommandContext(ctx, command, namespace, r.RemoteAddr)
+		done <- reply{status, body}
+	}()
+
+	stream := newEventStream(w)
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case result := <-done:
+			event := "result"
+			if result.status != http.StatusOK {
+				event = "error"
+			}
+			stream.send(event, "", result.body)
+			return
+		case <-ticker.C:
+			if stream.keepAlive() == nil {
+				continue
+			}
+		case <-r.Context().Done():
+		}
+		// The deferred cancel stops this request's command alone, giving back anything it took
+		return
+	}
+}
+
+// streamMessages streams the messages published to channels as "message" events.
+func streamMessages(w http.ResponseWriter, r *http.Request, channels []string) {
+	offset, replay, err := replayOffset(r)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sub, backlog := broker.Subscribe(channels, replay, offset)
+	defer broker.Unsubscribe(sub)
+
+	stream := newEventStream(w)
+	send := func(m Message) bool {
+		data, _ := json.Marshal(m)
+		return stream.send("message", strconv.FormatUint(m.Offset, 10), data) == nil
+	}
+	for _, m := range backlog {
+		if !send(m) {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case m := <-sub.ch:
+			if !send(m) {
+				return
+			}
+		case <-ticker.C:
+			if stream.keepAlive() != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventsDeliverBQPOPResult(t *testing.T) {
+	saved := sseKeepAlive
+	sseKeepAlive = 10 * time.Millisecond
+	t.Cleanup(func() { sseKeepAlive = saved })
+
+	server := httptest.NewServer(http.HandlerFunc(handleEvents))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events?command=BQPOP+sse-jobs+5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected an event stream, but got %q", ct)
+	}
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	next := func() string {
+		select {
+		case line := <-lines:
+			return line
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for the event stream")
+			return ""
+		}
+	}
+
+	// The stream is kept alive while the client is blocked
+	if line := next(); line != ": keep-alive" {
+		t.Fatalf("Expected a keep-alive comment, but got %q", line)
+	}
+	for !isBlockedOn("sse-jobs") {
+		time.Sleep(time.Millisecond)
+	}
+	store.QPush("sse-jobs", []string{"job-1"})
+
+	var event, data string
+	for line := next(); data == ""; line = next() {
+		if strings.HasPrefix(line, "event: ") {
+			event = strings.TrimPrefix(line, "event: ")
+		}
+		if strings.HasPrefix(line, "data: ") {
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	if event != "result" || data != `{"value":"job-1"}` {
+		t.Errorf("Expected a result event with the popped value, but got %q %q", event, data)
+	}
+}
+
+func TestEventsUnblockOnDisconnect(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(handleEvents))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events?command=BQPOP+sse-abandoned+5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for !isBlockedOn("sse-abandoned") {
+		time.Sleep(time.Millisecond)
+	}
+	resp.Body.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for isBlockedOn("sse-abandoned") {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the disconnected client to be unblocked")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Nothing is popped for the client that went away
+	store.QPush("sse-abandoned", []string{"kept"})
+	if n := store.QLen("sse-abandoned"); n != 1 {
+		t.Errorf("Expected the value to stay queued, but the queue has %d", n)
+	}
+
+}
+
+func TestEventsDisconnectLeavesSiblingStreams(t *testing.T) {
+	// Streams multiplexed over one HTTP/2 connection share a remote address
+	const addr = "192.0.2.1:4321"
+	sibling := make(chan error, 1)
+	go func() {
+		_, err := store.blockingPop(context.Background(), "sse-sibling", 5*time.Second, addr)
+		sibling <- err
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", "/events?command=BQPOP+sse-leaving+5", nil).WithContext(ctx)
+	req.RemoteAddr = addr
+	done := make(chan struct{})
+	go func() {
+		handleEvents(httptest.NewRecorder(), req)
+		close(done)
+	}()
+	for !isBlockedOn("sse-leaving") || !isBlockedOn("sse-sibling") {
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	<-done
+	for isBlockedOn("sse-leaving") {
+		time.Sleep(time.Millisecond)
+	}
+	if !isBlockedOn("sse-sibling") {
+		t.Fatal("Expected the other stream from the same address to keep waiting")
+	}
+
+	store.QPush("sse-sibling", []string{"job"})
+	if err := <-sibling; err != nil {
+		t.Errorf("Expected the other stream to get the value, but got %v", err)
+	}
+}
+
+func TestEventsRejectNonBlockingCommands(t *testing.T) {
+	rr := httptest.NewRecorder()
+	handleEvents(rr, httptest.NewRequest("GET", "/events?command=SET+sse-key+value", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected a non-blocking command to be rejected, but got %d", rr.Code)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import "time"
+
+// SweeperConfig bounds the work done by the background expiry sweeper.
+// Each round samples at most SampleSize keys under the write lock; a cycle keeps
+// running rounds only while the fraction of expired keys in the last sample
+// exceeds ExpiredThreshold, so the lock is never held for long and heavily
+// expired keyspaces are still cleaned quickly.
+type SweeperConfig struct {
+	Interval         time.Duration // Time between sweep cycles
+	SampleSize       int           // Maximum number of keys examined per round
+	ExpiredThreshold float64       // Expired fraction above which another round is run
+	MaxRounds        int           // Upper bound on rounds in a single cycle
+}
+
+var sweeperConfig = SweeperConfig{
+	Interval:         100 * time.Millisecond,
+	SampleSize:       20,
+	ExpiredThreshold: 0.25,
+	MaxRounds:        16,
+}
+
+// SweepStats reports the work done by one sweep cycle.
+type SweepStats struct {
+	Rounds  int // Number of lock acquisitions
+	Sampled int // Keys examined across all rounds
+	Expired int // Keys deleted across all rounds
+}
+
+// sweepRound examines at most sampleSize keys and deletes the expired ones.
+// It returns how many keys carrying an expiry or idle window were seen and how many were deleted.
+func (store *KeyValueStore) sweepRound(sampleSize int) (withExpiry, expired int) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := clock.Now()
+	visited := 0
+	// Map iteration order is randomised, which gives a cheap random sample
+	for key, kv := range store.Data {
+		if visited == sampleSize {
+			break
+		}
+		visited++
+
+		// Keys with an idle window are sampled like keys with a TTL
+		if kv.ExpiryTime == nil && kv.MaxIdle == 0 && store.maxIdle == 0 {
+			continue
+		}
+		withExpiry++
+
+		if (kv.ExpiryTime != nil && now.After(*kv.ExpiryTime)) || store.isIdle(kv, now) {
+			store.notifyExpired(key, kv, store.expiredAt(kv))
+			store.drop(key)
+			expired++
+		}
+	}
+
+	return withExpiry, expired
+}
+
+// sweepCycle runs sampling rounds until the sampled expired fraction drops
+// to the configured threshold or the round limit is reached.
+func (store *KeyValueStore) sweepCycle(config SweeperConfig) SweepStats {
+	var stats SweepStats
+
+	for stats.Rounds < config.MaxRounds {
+		withExpiry, expired := store.sweepRound(config.SampleSize)
+		stats.Rounds++
+		stats.Sampled += withExpiry
+		stats.Expired += expired
+
+		if withExpiry == 0 || float64(expired)/float64(withExpiry) <= config.ExpiredThreshold {
+			break
+		}
+	}
+
+	return stats
+}
+
+// runSweeper actively removes expired keys, and requeues lapsed QCLAIM claims,
+// on every tick until stop is closed.
+func (store *KeyValueStore) runSweeper(config SweeperConfig, stop <-chan struct{}) {
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			store.sweepCycle(config)
+			store.RequeueClaims()
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSweepCycleBoundsWork(t *testing.T) {
+	testStore := &KeyValueStore{Data: make(map[string]*KeyValue)}
+
+	// Many short-TTL keys alongside a few persistent ones
+	expired := time.Now().Add(-time.Millisecond)
+	for i := 0; i < 1000; i++ {
+		testStore.Data["short:"+strconv.Itoa(i)] = &KeyValue{Value: []string{"v"}, ExpiryTime: &expired}
+	}
+	for i := 0; i < 10; i++ {
+		testStore.Data["persistent:"+strconv.Itoa(i)] = &KeyValue{Value: []string{"v"}}
+	}
+
+	config := SweeperConfig{SampleSize: 20, ExpiredThreshold: 0.25, MaxRounds: 16}
+
+	cycles := 0
+	for len(testStore.Data) > 10 {
+		cycles++
+		if cycles > 10 {
+			t.Fatalf("Expected expired keys to be cleaned within 10 cycles, %d keys remain", len(testStore.Data)-10)
+		}
+
+		stats := testStore.sweepCycle(config)
+
+		// Each round holds the lock for at most SampleSize keys
+		if stats.Rounds > config.MaxRounds {
+			t.Errorf("Expected at most %d rounds per cycle, but got %d", config.MaxRounds, stats.Rounds)
+		}
+		if stats.Sampled > stats.Rounds*config.SampleSize {
+			t.Errorf("Expected at most %d keys sampled per round, but sampled %d in %d rounds",
+				config.SampleSize, stats.Sampled, stats.Rounds)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		if _, ok := testStore.Data["persistent:"+strconv.Itoa(i)]; !ok {
+			t.Errorf("Expected persistent key %d to survive the sweep", i)
+		}
+	}
+}
+
+func TestSweeperExpiresIdleKeys(t *testing.T) {
+	sendCommand(t, "SET idle-key value IDLE 60")
+	sendCommand(t, "SET idle-busy value IDLE 60")
+
+	// Nothing touches idle-key past its window; idle-busy was just read
+	store.mutex.Lock()
+	store.Data["idle-key"].touch(time.Now().Add(-2 * time.Minute))
+	store.mutex.Unlock()
+	sendCommand(t, "GET idle-busy")
+
+	// Sample the whole keyspace in a single round
+	store.sweepCycle(SweeperConfig{SampleSize: math.MaxInt32, MaxRounds: 1})
+
+	store.mutex.RLock()
+	_, idle := store.Data["idle-key"]
+	_, busy := store.Data["idle-busy"]
+	store.mutex.RUnlock()
+
+	if idle {
+		t.Errorf("Expected the idle key to be swept")
+	}
+	if !busy {
+		t.Errorf("Expected the recently read key to survive")
+	}
+
+	// A global window applies to keys without their own
+	testStore := &KeyValueStore{Data: make(map[string]*KeyValue), maxIdle: time.Minute}
+	testStore.insert("stale", &KeyValue{Kind: kindString, Value: []string{"v"}})
+	testStore.Data["stale"].touch(time.Now().Add(-2 * time.Minute))
+	testStore.sweepCycle(SweeperConfig{SampleSize: 10, MaxRounds: 1})
+	if len(testStore.Data) != 0 {
+		t.Errorf("Expected the globally idle key to be swept, %d keys remain", len(testStore.Data))
+	}
+}
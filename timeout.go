@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// timeoutHeader lets clients set a command's time budget without changing the URL.
+// The ?timeout= query parameter takes precedence.
+const timeoutHeader = "X-Command-Timeout"
+
+var errCommandTimeout = errors.New("command timed out")
+
+// commandContext returns the context a command runs under. It carries a
+// deadline when the client set a budget such as ?timeout=500ms.
+func commandContext(r *http.Request) (context.Context, context.CancelFunc, error) {
+	budget := r.URL.Query().Get("timeout")
+	if budget == "" {
+		budget = r.Header.Get(timeoutHeader)
+	}
+	if budget == "" {
+		ctx, cancel := context.WithCancel(r.Context())
+		return ctx, cancel, nil
+	}
+
+	timeout, err := time.ParseDuration(budget)
+	if err != nil || timeout <= 0 {
+		return nil, nil, errors.New("invalid timeout")
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	return ctx, cancel, nil
+}
+
+// acquire takes a lock with lock, giving up with errCommandTimeout once ctx
+// is done. sync.RWMutex cannot be cancelled, so a contended lock is taken by a
+// goroutine that queues for it like any other caller; if the command gives up
+// first, the goroutine releases the lock with unlock as soon as it gets it.
+func acquire(ctx context.Context, lock, unlock func()) error {
+	acquired := make(chan struct{})
+	go func() {
+		lock()
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+	case <-ctx.Done():
+		go func() {
+			<-acquired
+			unlock()
+		}()
+		return errCommandTimeout
+	}
+	// A command that got the lock after its budget ran out must not take effect
+	if ctx.Err() != nil {
+		unlock()
+		return errCommandTimeout
+	}
+	return nil
+}
+
+// lockContext takes the store write lock, waiting no longer than ctx allows.
+func (store *KeyValueStore) lockContext(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return errCommandTimeout
+	}
+	if store.mutex.TryLock() {
+		return nil
+	}
+	return acquire(ctx, store.mutex.Lock, store.mutex.Unlock)
+}
+
+// rLockContext takes the store read lock, waiting no longer than ctx allows.
+func (store *KeyValueStore) rLockContext(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return errCommandTimeout
+	}
+	if store.mutex.TryRLock() {
+		return nil
+	}
+	return acquire(ctx, store.mutex.RLock, store.mutex.RUnlock)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandTimesOutWaitingForLock(t *testing.T) {
+	sendCommand(t, "SET timeout-key value")
+
+	// Starve the command of the lock for longer than its budget
+	store.mutex.Lock()
+	released := make(chan struct{})
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		store.mutex.Unlock()
+		close(released)
+	}()
+
+	req, err := http.NewRequest("POST", "/?timeout=50ms", strings.NewReader(`{"command": "DEL timeout-key"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	rr := httptest.NewRecorder()
+	handleRequest(rr, req)
+	elapsed := time.Since(start)
+
+	var response ErrorResponse
+	decodeResponse(t, rr, &response)
+	if rr.Code != http.StatusBadRequest || response.Error != "command timed out" {
+		t.Errorf("Expected %q, but got %d %q", "command timed out", rr.Code, response.Error)
+	}
+	if elapsed >= 400*time.Millisecond {
+		t.Errorf("Expected the command to give up after its budget, but it took %v", elapsed)
+	}
+
+	// The timed out DEL must not have run once the lock was released
+	<-released
+	var value ValueResponse
+	decodeResponse(t, sendCommand(t, "GET timeout-key"), &value)
+	if value.Value != "value" {
+		t.Errorf("Expected the key to survive the timed out DEL, but got %q", value.Value)
+	}
+}
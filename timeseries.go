@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errTooOld = errors.New("timestamp is older than the series retention")
+
+// timeSeries holds samples in timestamp order as two parallel slices, which
+// takes 16 bytes per sample instead of a slice of structs with padding or a
+// map. Samples older than retention, counted back from the newest sample, and
+// beyond the newest maxSamples are dropped as samples are added.
+type timeSeries struct {
+	timestamps []int64 // Unix milliseconds, strictly increasing
+	values     []float64
+	retention  time.Duration // 0 keeps samples regardless of age
+	maxSamples int           // 0 keeps any number of samples
+}
+
+// Sample is a point in a time series, as returned by TSRANGE.
+type Sample struct {
+	Timestamp int64   `json:"timestamp"` // Unix milliseconds
+	Value     float64 `json:"value"`
+}
+
+// add inserts a sample in timestamp order, replacing any sample with the same
+// timestamp, then applies the retention limits.
+func (ts *timeSeries) add(timestamp int64, value float64) error {
+	n := len(ts.timestamps)
+	if ts.retention > 0 && n > 0 && timestamp < ts.timestamps[n-1]-ts.retention.Milliseconds() {
+		return errTooOld
+	}
+
+	i := sort.Search(n, func(i int) bool { return ts.timestamps[i] >= timestamp })
+	switch {
+	case i < n && ts.timestamps[i] == timestamp:
+		ts.values[i] = value
+	case i == n:
+		ts.timestamps = append(ts.timestamps, timestamp)
+		ts.values = append(ts.values, value)
+	default:
+		ts.timestamps = append(ts.timestamps, 0)
+		copy(ts.timestamps[i+1:], ts.timestamps[i:])
+		ts.timestamps[i] = timestamp
+		ts.values = append(ts.values, 0)
+		copy(ts.values[i+1:], ts.values[i:])
+		ts.values[i] = value
+	}
+	ts.prune()
+	return nil
+}
+
+// prune drops the samples the retention limits no longer allow.
+func (ts *timeSeries) prune() {
+	n := len(ts.timestamps)
+	if n == 0 {
+		return
+	}
+
+	drop := 0
+	if ts.retention > 0 {
+		oldest := ts.timestamps[n-1] - ts.retention.Milliseconds()
+		drop = sort.Search(n, func(i int) bool { return ts.timestamps[i] >= oldest })
+	}
+	if ts.maxSamples > 0 && n-drop > ts.maxSamples {
+		drop = n - ts.maxSamples
+	}
+	if drop > 0 {
+		ts.timestamps = append([]int64(nil), ts.timestamps[drop:]...)
+		ts.values = append([]float64(nil), ts.values[drop:]...)
+	}
+}
+
+// rangeOf returns the samples with timestamps from from to to, inclusive.
+func (ts *timeSeries) rangeOf(from, to int64) []Sample {
+	start := sort.Search(len(ts.timestamps), func(i int) bool { return ts.timestamps[i] >= from })
+	samples := []Sample{}
+	for i := start; i < len(ts.timestamps) && ts.timestamps[i] <= to; i++ {
+		samples = append(samples, Sample{Timestamp: ts.timestamps[i], Value: ts.values[i]})
+	}
+	return samples
+}
+
+// TSAdd adds a sample to the time series at key, creating it if needed, and
+// returns its timestamp. A positive retention or maxSamples replaces the
+// series' limit; 0 leaves it unchanged.
+func (store *KeyValueStore) TSAdd(key string, timestamp int64, value float64, retention time.Duration, maxSamples int) (int64, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	kv, ok := store.lookup(key)
+	if ok && kv.Kind != kindTimeSeries {
+		return 0, errWrongType
+	}
+	if !ok {
+		kv = &KeyValue{Kind: kindTimeSeries, Series: &timeSeries{}}
+		store.insert(key, kv)
+	}
+
+	if retention > 0 {
+		kv.Series.retention = retention
+	}
+	if maxSamples > 0 {
+		kv.Series.maxSamples = maxSamples
+	}
+	if err := kv.Series.add(timestamp, value); err != nil {
+		return 0, err
+	}
+	return timestamp, nil
+}
+
+// TSRange returns the samples of the time series at key with timestamps from
+// from to to, inclusive, oldest first. A missing key has no samples.
+func (store *KeyValueStore) TSRange(key string, from, to int64) ([]Sample, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	kv, ok := store.lookup(key)
+	if !ok {
+		return []Sample{}, nil
+	}
+	if kv.Kind != kindTimeSeries {
+		return nil, errWrongType
+	}
+	return kv.Series.rangeOf(from, to), nil
+}
+
+// parseTimestamp parses a sample timestamp in Unix milliseconds, where "*"
+// means the current time, "-" the earliest and "+" the latest.
+func parseTimestamp(s string) (int64, bool) {
+	switch s {
+	case "*":
+		return clock.Now().UnixMilli(), true
+	case "-":
+		return math.MinInt64, true
+	case "+":
+		return math.MaxInt64, true
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n, err == nil
+}
+
+// handleTSADD handles TSADD key timestamp value [RETENTION ms] [MAXSAMPLES n],
+// returning the sample's timestamp.
+func handleTSADD(w http.ResponseWriter, parts []string) {
+	if len(parts) < 4 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	timestamp, ok := parseTimestamp(parts[2])
+	if !ok || parts[2] == "-" || parts[2] == "+" {
+		sendErrorResponse(w, "invalid timestamp")
+		return
+	}
+	value, err := strconv.ParseFloat(parts[3], 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		sendErrorResponse(w, "invalid value")
+		return
+	}
+
+	var retention time.Duration
+	var maxSamples int
+	for i := 4; i < len(parts); i += 2 {
+		option := strings.ToUpper(parts[i])
+		if (option != "RETENTION" && option != "MAXSAMPLES") || i+1 == len(parts) {
+			sendErrorResponse(w, unexpectedToken(parts, i, "RETENTION <ms> or MAXSAMPLES <n>"))
+			return
+		}
+		n, err := strconv.Atoi(parts[i+1])
+		if err != nil || n <= 0 {
+			sendErrorResponse(w, "invalid "+strings.ToLower(option))
+			return
+		}
+		if option == "RETENTION" {
+			retention = time.Duration(n) * time.Millisecond
+		} else {
+			maxSamples = n
+		}
+	}
+
+	added, err := store.TSAdd(parts[1], timestamp, value, retention, maxSamples)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendIntegerResponse(w, added)
+}
+
+// handleTSRANGE handles TSRANGE key from to, returning the samples in the window.
+func handleTSRANGE(w http.ResponseWriter, parts []string) {
+	if len(parts) != 4 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	from, ok := parseTimestamp(parts[2])
+	if !ok || parts[2] == "*" {
+		sendErrorResponse(w, "invalid timestamp")
+		return
+	}
+	to, ok := parseTimestamp(parts[3])
+	if !ok || parts[3] == "*" {
+		sendErrorResponse(w, "invalid timestamp")
+		return
+	}
+
+	samples, err := store.TSRange(parts[1], from, to)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendObjectResponse(w, samples)
+}
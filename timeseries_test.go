@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTSRANGEReturnsOrderedSamplesWithinRetention(t *testing.T) {
+	for _, command := range []string{
+		"TSADD cpu 3000 30 RETENTION 5000",
+		"TSADD cpu 1000 10",
+		"TSADD cpu 2000 20",
+		"TSADD cpu 5000 50",
+		"TSADD cpu 4000 40.5",
+	} {
+		if rr := sendCommand(t, command); rr.Code != 200 {
+			t.Fatalf("%s: unexpected status %d: %s", command, rr.Code, rr.Body.String())
+		}
+	}
+
+	var samples struct {
+		Value []Sample `json:"value"`
+	}
+	decodeResponse(t, sendCommand(t, "TSRANGE cpu 2000 4000"), &samples)
+	expected := []Sample{{2000, 20}, {3000, 30}, {4000, 40.5}}
+	if !reflect.DeepEqual(samples.Value, expected) {
+		t.Errorf("Expected %v, but got %v", expected, samples.Value)
+	}
+
+	// A sample at 7000 pushes everything before 2000 out of the 5s retention
+	sendCommand(t, "TSADD cpu 7000 70")
+	decodeResponse(t, sendCommand(t, "TSRANGE cpu - +"), &samples)
+	expected = []Sample{{2000, 20}, {3000, 30}, {4000, 40.5}, {5000, 50}, {7000, 70}}
+	if !reflect.DeepEqual(samples.Value, expected) {
+		t.Errorf("Expected the 1000 sample to be pruned, leaving %v, but got %v", expected, samples.Value)
+	}
+
+	var response ErrorResponse
+	decodeResponse(t, sendCommand(t, "TSADD cpu 1500 15"), &response)
+	if response.Error != errTooOld.Error() {
+		t.Errorf("Expected a sample older than the retention to be rejected, but got %q", response.Error)
+	}
+
+	sendCommand(t, "TSADD cpu 8000 80 MAXSAMPLES 2")
+	decodeResponse(t, sendCommand(t, "TSRANGE cpu - +"), &samples)
+	if expected := []Sample{{7000, 70}, {8000, 80}}; !reflect.DeepEqual(samples.Value, expected) {
+		t.Errorf("Expected MAXSAMPLES to keep %v, but got %v", expected, samples.Value)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"os"
+)
+
+// TLSOptions configures HTTPS and client-certificate authentication.
+type TLSOptions struct {
+	CertFile          string // Server certificate; TLS is disabled when empty
+	KeyFile           string // Server private key
+	ClientCAFile      string // CA bundle used to verify client certificates
+	RequireClientCert bool   // Reject clients without a certificate signed by ClientCAFile
+}
+
+// tlsConfig builds the server TLS configuration for options. Client certificates
+// are verified against ClientCAFile whenever one is presented, and are mandatory
+// with RequireClientCert.
+func tlsConfig(options TLSOptions) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if options.ClientCAFile == "" {
+		if options.RequireClientCert {
+			return nil, errors.New("requiring client certificates needs a client CA")
+		}
+		return config, nil
+	}
+
+	pem, err := os.ReadFile(options.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificates found in client CA file")
+	}
+
+	config.ClientCAs = pool
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	if options.RequireClientCert {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// clientPrincipal returns the identity of the client certificate that
+// authenticated r: its common name, or else its first DNS, email or URI SAN.
+// It returns "" for requests without a verified client certificate.
+func clientPrincipal(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return ""
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	}
+	return ""
+}
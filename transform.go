@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var errNotJSONObject = errors.New("value is not a JSON object")
+
+// Transformer computes the new value of a string key for APPLY from its
+// current value, which is "" with exists false for a missing key, and the
+// command's arguments. It runs under the store write lock, so it must not call
+// back into the store.
+type Transformer func(value string, exists bool, args []string) (string, error)
+
+// transformers holds the transformers APPLY can run, by lowercase name.
+var transformers = map[string]Transformer{
+	"uppercase":       transformCase(strings.ToUpper),
+	"lowercase":       transformCase(strings.ToLower),
+	"append":          transformAppend,
+	"json-merge":      transformJSONMerge,
+	"increment-field": transformIncrementField,
+}
+
+// RegisterTransformer makes t available to APPLY as name, replacing any
+// transformer of that name. It must be called before the server starts
+// handling requests.
+func RegisterTransformer(name string, t Transformer) {
+	transformers[strings.ToLower(name)] = t
+}
+
+// Apply replaces the string stored at key with the result of the named
+// transformer, reading and writing it under one lock acquisition so no other
+// write lands in between, and returns the new value. The key keeps its TTL.
+func (store *KeyValueStore) Apply(key, name string, args []string) (string, error) {
+	transform, ok := transformers[strings.ToLower(name)]
+	if !ok {
+		return "", fmt.Errorf("unknown transformer '%s'", name)
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	kv, ok := store.lookup(key)
+	if ok && kv.Kind != kindString {
+		return "", errWrongType
+	}
+	var current string
+	if ok {
+		current = strings.Join(kv.Value, " ")
+	}
+
+	result, err := transform(current, ok, args)
+	if err != nil {
+		return "", err
+	}
+
+	if !ok {
+		store.insert(key, &KeyValue{Kind: kindString, Value: []string{result}})
+		return result, nil
+	}
+	kv.Value = []string{result}
+	store.stamp(key, kv)
+	return result, nil
+}
+
+// transformCase returns a transformer that maps an existing value with fn.
+func transformCase(fn func(string) string) Transformer {
+	return func(value string, exists bool, args []string) (string, error) {
+		if len(args) != 0 {
+			return "", errors.New("transformer takes no arguments")
+		}
+		if !exists {
+			return "", errKeyNotFound
+		}
+		return fn(value), nil
+	}
+}
+
+// transformAppend appends its arguments, joined by spaces, to the value.
+func transformAppend(value string, exists bool, args []string) (string, error) {
+	if len(args) == 0 {
+		return "", errors.New("append requires a suffix")
+	}
+	return value + strings.Join(args, " "), nil
+}
+
+// transformJSONMerge applies its argument as a JSON merge patch (RFC 7386) to
+// the JSON object stored in the value: fields set to null are removed, nested
+// objects are merged and anything else is replaced. A missing key starts as {}.
+func transformJSONMerge(value string, exists bool, args []string) (string, error) {
+	if len(args) == 0 {
+		return "", errors.New("json-merge requires a patch")
+	}
+	target, err := decodeJSONObject(value, exists)
+	if err != nil {
+		return "", err
+	}
+
+	var patch interface{}
+	if err := json.Unmarshal([]byte(strings.Join(args, " ")), &patch); err != nil {
+		return "", errors.New("invalid JSON patch")
+	}
+	merged, err := json.Marshal(mergePatch(target, patch))
+	if err != nil {
+		return "", err
+	}
+	return string(merged), nil
+}
+
+// mergePatch returns target with patch applied as a JSON merge patch.
+func mergePatch(target, patch interface{}) interface{} {
+	fields, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	object, ok := target.(map[string]interface{})
+	if !ok {
+		object = make(map[string]interface{})
+	}
+	for name, value := range fields {
+		if value == nil {
+			delete(object, name)
+			continue
+		}
+		object[name] = mergePatch(object[name], value)
+	}
+	return object
+}
+
+// transformIncrementField adds to a numeric field of the JSON object stored in
+// the value, 1 unless an amount is given. A missing field or key counts as 0.
+// Integers stay integers; anything else is added as a float.
+func transformIncrementField(value string, exists bool, args []string) (string, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return "", errors.New("increment-field requires a field and an optional amount")
+	}
+	object, err := decodeJSONObject(value, exists)
+	if err != nil {
+		return "", err
+	}
+
+	by := json.Number("1")
+	if len(args) == 2 {
+		by = json.Number(args[1])
+		if _, err := by.Float64(); err != nil {
+			return "", errors.New("invalid increment")
+		}
+	}
+
+	current := json.Number("0")
+	if field, ok := object[args[0]]; ok {
+		if current, ok = field.(json.Number); !ok {
+			return "", fmt.Errorf("field '%s' is not a number", args[0])
+		}
+	}
+
+	a, aErr := current.Int64()
+	b, bErr := by.Int64()
+	if aErr == nil && bErr == nil {
+		if (b > 0 && a > math.MaxInt64-b) || (b < 0 && a < math.MinInt64-b) {
+			return "", errors.New("increment would overflow")
+		}
+		object[args[0]] = json.Number(strconv.FormatInt(a+b, 10))
+	} else {
+		x, _ := current.Float64()
+		y, _ := by.Float64()
+		object[args[0]] = json.Number(strconv.FormatFloat(x+y, 'g', -1, 64))
+	}
+
+	result, err := json.Marshal(object)
+	if err != nil {
+		return "", err
+	}
+	return string(result), nil
+}
+
+// decodeJSONObject parses value as a JSON object, keeping numbers as
+// json.Number so integers round-trip exactly. A missing key reads as {}.
+func decodeJSONObject(value string, exists bool) (map[string]interface{}, error) {
+	object := make(map[string]interface{})
+	if !exists {
+		return object, nil
+	}
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.UseNumber()
+	if err := decoder.Decode(&object); err != nil || object == nil {
+		return nil, errNotJSONObject
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errNotJSONObject
+	}
+	return object, nil
+}
+
+// handleAPPLY handles APPLY key transformer [arg ...], returning the new value.
+func handleAPPLY(w http.ResponseWriter, parts []string) {
+	if len(parts) < 3 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	value, err := store.Apply(parts[1], parts[2], parts[3:])
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendValueResponse(w, value)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestAPPLYIncrementFieldIsAtomic(t *testing.T) {
+	const clients, applies = 8, 50
+
+	sendCommand(t, `SET apply-stats {"name":"hits","count":0}`)
+
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < applies; j++ {
+				sendCommand(t, "APPLY apply-stats increment-field count")
+			}
+		}()
+	}
+	wg.Wait()
+
+	var response ValueResponse
+	decodeResponse(t, sendCommand(t, "GET apply-stats"), &response)
+	expected := `{"count":` + strconv.Itoa(clients*applies) + `,"name":"hits"}`
+	if response.Value != expected {
+		t.Errorf("Expected %s, but got %s", expected, response.Value)
+	}
+}
+
+func TestAPPLYBuiltins(t *testing.T) {
+	sendCommand(t, `SET apply-config {"a":1,"b":{"c":2,"d":3}}`)
+
+	tests := []struct {
+		command  string
+		expected string
+	}{
+		{`APPLY apply-config json-merge {"b": {"c": null, "e": 4}, "f": "x"}`, `{"a":1,"b":{"d":3,"e":4},"f":"x"}`},
+		{"APPLY apply-config increment-field a 2.5", `{"a":3.5,"b":{"d":3,"e":4},"f":"x"}`},
+		{"APPLY apply-new increment-field n", `{"n":1}`},
+		{"APPLY apply-new uppercase", `{"N":1}`},
+		{"APPLY apply-new append !", `{"N":1}!`},
+	}
+	for _, test := range tests {
+		var response ValueResponse
+		decodeResponse(t, sendCommand(t, test.command), &response)
+		if response.Value != test.expected {
+			t.Errorf("%s: expected %s, but got %s", test.command, test.expected, response.Value)
+		}
+	}
+
+	for _, command := range []string{"APPLY apply-config rot13", "APPLY apply-missing uppercase", "APPLY apply-new increment-field n"} {
+		if rr := sendCommand(t, command); rr.Code != 400 {
+			t.Errorf("%s: expected an error, but got %d %s", command, rr.Code, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var errVersionMismatch = errors.New("version mismatch")
+
+// VersionedValue is the reply to GETVER.
+type VersionedValue struct {
+	Value   string `json:"value"`
+	Version uint64 `json:"version"`
+}
+
+// stamp gives kv, stored at key, a new version after a write, wakes any
+// WATCHGET on key and releases any GETORLOCK lock on it. Versions come from a
+// single counter, so a key that is deleted and recreated never repeats an old
+// version. Any etag is cleared, as it described the old value. The caller must
+// hold the store write lock.
+func (store *KeyValueStore) stamp(key string, kv *KeyValue) {
+	store.lastVersion++
+	kv.version = store.lastVersion
+	kv.etag = ""
+	store.markWritten(key)
+	store.notifyWatchers(key)
+	delete(store.computeLocks, key)
+}
+
+// GetVer returns the string stored at key along with its version.
+func (store *KeyValueStore) GetVer(key string) (VersionedValue, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	kv, ok := store.lookup(key)
+	if !ok {
+		return VersionedValue{}, errKeyNotFound
+	}
+	if kv.Kind != kindString {
+		return VersionedValue{}, errWrongType
+	}
+	return VersionedValue{Value: strings.Join(kv.Value, " "), Version: kv.version}, nil
+}
+
+// SetVer stores value at key only if the key's current version is version, and
+// returns the new version. A version of 0 only succeeds when the key is missing.
+// The key keeps its expiry time, so SetVer can update a value without changing its TTL.
+func (store *KeyValueStore) SetVer(key, value string, version uint64) (uint64, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	kv, ok := store.lookup(key)
+	if !ok {
+		if version != 0 {
+			return 0, errVersionMismatch
+		}
+		kv = &KeyValue{Kind: kindString, Value: []string{value}}
+		store.insert(key, kv)
+		return kv.version, nil
+	}
+
+	if kv.Kind != kindString {
+		return 0, errWrongType
+	}
+	if kv.version != version {
+		return 0, errVersionMismatch
+	}
+
+	kv.Value = []string{value}
+	store.stamp(key, kv)
+	return kv.version, nil
+}
+
+// handleGETVER handles GETVER key.
+func handleGETVER(w http.ResponseWriter, parts []string) {
+	if len(parts) != 2 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	result, err := store.GetVer(parts[1])
+	if err != nil {
+		sendReadError(w, err)
+		return
+	}
+
+	sendObjectResponse(w, result)
+}
+
+// handleSETVER handles SETVER key value version, returning the new version.
+func handleSETVER(w http.ResponseWriter, parts []string) {
+	if len(parts) != 4 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	version, err := strconv.ParseUint(parts[3], 10, 64)
+	if err != nil {
+		sendErrorResponse(w, "invalid version")
+		return
+	}
+
+	newVersion, err := store.SetVer(parts[1], parts[2], version)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendIntegerResponse(w, int64(newVersion))
+}
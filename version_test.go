@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSETVERRejectsStaleVersion(t *testing.T) {
+	var created IntegerResponse
+	decodeResponse(t, sendCommand(t, "SETVER cas-key v1 0"), &created)
+
+	var read struct {
+		Value VersionedValue `json:"value"`
+	}
+	decodeResponse(t, sendCommand(t, "GETVER cas-key"), &read)
+	if read.Value.Value != "v1" || read.Value.Version != uint64(created.Value) {
+		t.Fatalf("Expected v1 at version %d, but got %+v", created.Value, read.Value)
+	}
+
+	// Another writer gets in first
+	var updated IntegerResponse
+	decodeResponse(t, sendCommand(t, "SETVER cas-key v2 "+strconv.FormatUint(read.Value.Version, 10)), &updated)
+	if uint64(updated.Value) <= read.Value.Version {
+		t.Errorf("Expected a newer version than %d, but got %d", read.Value.Version, updated.Value)
+	}
+
+	// The stale version no longer matches
+	rr := sendCommand(t, "SETVER cas-key v3 "+strconv.FormatUint(read.Value.Version, 10))
+	var response ErrorResponse
+	decodeResponse(t, rr, &response)
+	if response.Error != errVersionMismatch.Error() {
+		t.Errorf("Expected %q, but got %q", errVersionMismatch.Error(), response.Error)
+	}
+
+	// Plain writes change the version too
+	sendCommand(t, "SET cas-key v4")
+	decodeResponse(t, sendCommand(t, "GETVER cas-key"), &read)
+	if read.Value.Value != "v4" || read.Value.Version == uint64(updated.Value) {
+		t.Errorf("Expected SET to bump the version, but got %+v", read.Value)
+	}
+}
+
+func TestWATCHGETWakesOnSet(t *testing.T) {
+	sendCommand(t, "SET watched-config v1")
+	var read struct {
+		Value VersionedValue `json:"value"`
+	}
+	decodeResponse(t, sendCommand(t, "GETVER watched-config"), &read)
+	initial := read.Value
+
+	// A stale version is answered at once
+	var stale struct {
+		Value VersionedValue `json:"value"`
+	}
+	decodeResponse(t, sendCommand(t, "WATCHGET watched-config 5 "+strconv.FormatUint(initial.Version-1, 10)), &stale)
+	if stale.Value != initial {
+		t.Fatalf("Expected a stale version to return %+v at once, but got %+v", initial, stale.Value)
+	}
+
+	done := make(chan VersionedValue)
+	go func() {
+		var response struct {
+			Value VersionedValue `json:"value"`
+		}
+		decodeResponse(t, sendCommand(t, "WATCHGET watched-config 5 "+strconv.FormatUint(initial.Version, 10)), &response)
+		done <- response.Value
+	}()
+
+	select {
+	case got := <-done:
+		t.Fatalf("Expected WATCHGET to block until the key changed, but got %+v", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	sendCommand(t, "SET watched-config v2")
+	select {
+	case got := <-done:
+		if got.Value != "v2" || got.Version <= initial.Version {
+			t.Errorf("Expected the new value and a newer version, but got %+v", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected SET to wake the blocked WATCHGET")
+	}
+
+	// Without a change the current version comes back after the timeout
+	var timedOut struct {
+		Value VersionedValue `json:"value"`
+	}
+	decodeResponse(t, sendCommand(t, "WATCHGET watched-config 0.05"), &timedOut)
+	if timedOut.Value.Value != "v2" {
+		t.Errorf("Expected the unchanged value after the timeout, but got %+v", timedOut.Value)
+	}
+}
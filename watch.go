@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// keyWatch wakes the WATCHGET clients waiting on a key by closing changed at
+// the key's next write or deletion.
+type keyWatch struct {
+	changed chan struct{}
+	clients int // Clients still waiting, so an unwritten key's watch can be dropped
+}
+
+// watchKey registers a client waiting for key to change and returns the watch
+// it should wait on. The caller must hold the store write lock.
+func (store *KeyValueStore) watchKey(key string) *keyWatch {
+	if store.watchers == nil {
+		store.watchers = make(map[string]*keyWatch)
+	}
+	watch, ok := store.watchers[key]
+	if !ok {
+		watch = &keyWatch{changed: make(chan struct{})}
+		store.watchers[key] = watch
+	}
+	watch.clients++
+	return watch
+}
+
+// unwatchKey removes a client that stopped waiting on watch before key changed.
+// The caller must hold the store write lock.
+func (store *KeyValueStore) unwatchKey(key string, watch *keyWatch) {
+	watch.clients--
+	if watch.clients == 0 && store.watchers[key] == watch {
+		delete(store.watchers, key)
+	}
+}
+
+// notifyWatchers wakes the clients waiting for key to change.
+// The caller must hold the store write lock.
+func (store *KeyValueStore) notifyWatchers(key string) {
+	if watch, ok := store.watchers[key]; ok {
+		close(watch.changed)
+		delete(store.watchers, key)
+	}
+}
+
+// watchedValue returns the string stored at key with its version, or an empty
+// value with version 0 when the key is missing. The caller must hold the store lock.
+func (store *KeyValueStore) watchedValue(key string) (VersionedValue, error) {
+	kv, ok := store.lookup(key)
+	if !ok {
+		return VersionedValue{}, nil
+	}
+	if kv.Kind != kindString {
+		return VersionedValue{}, errWrongType
+	}
+	return VersionedValue{Value: strings.Join(kv.Value, " "), Version: kv.version}, nil
+}
+
+// WatchGet returns the string stored at key with its version once the version
+// differs from version, which is at once if the caller's version is already
+// stale. Otherwise it waits for up to timeout, or until ctx is done, for the
+// key to be written or deleted, then returns the value as it is then. A
+// missing key has version 0, as with SETVER.
+func (store *KeyValueStore) WatchGet(ctx context.Context, key string, version uint64, timeout time.Duration) (VersionedValue, error) {
+	store.mutex.Lock()
+	current, err := store.watchedValue(key)
+	if err != nil || current.Version != version || timeout <= 0 {
+		store.mutex.Unlock()
+		return current, err
+	}
+	watch := store.watchKey(key)
+	store.mutex.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	changed := false
+	select {
+	case <-watch.changed:
+		changed = true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if !changed {
+		store.unwatchKey(key, watch)
+	}
+	return store.watchedValue(key)
+}
+
+// handleWATCHGET handles WATCHGET key timeout [version], where timeout is in
+// seconds. Without a version it waits for the key's next change. The reply is
+// the value and version; an unchanged version means the timeout elapsed.
+func handleWATCHGET(ctx context.Context, w http.ResponseWriter, parts []string) {
+	if len(parts) != 3 && len(parts) != 4 {
+		sendErrorResponse(w, "invalid command format")
+		return
+	}
+
+	seconds, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil || seconds < 0 {
+		sendErrorResponse(w, "invalid timeout")
+		return
+	}
+	timeout := blockTimeout(seconds)
+
+	var version uint64
+	if len(parts) == 4 {
+		version, err = strconv.ParseUint(parts[3], 10, 64)
+		if err != nil {
+			sendErrorResponse(w, "invalid version")
+			return
+		}
+	} else {
+		store.mutex.RLock()
+		current, err := store.watchedValue(parts[1])
+		store.mutex.RUnlock()
+		if err != nil {
+			sendErrorResponse(w, err.Error())
+			return
+		}
+		version = current.Version
+	}
+
+	result, err := store.WatchGet(ctx, parts[1], version, timeout)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	sendObjectResponse(w, result)
+}